@@ -0,0 +1,23 @@
+package main
+
+import (
+	"github.com/rail2025/AetherDraw-Server/protocol"
+)
+
+// sendAck reports a frame dropped before it reached the room. The ack goes
+// through the room's loop because only the loop may write to, and close, c.send.
+func (c *Client) sendAck(frameSeq int64, status protocol.AckStatus) {
+	if frameSeq == 0 {
+		return
+	}
+	c.deliver(&Message{room: c.room, source: c, frameSeq: frameSeq, ackOnly: true, ackStatus: status})
+}
+
+// ack queues an Ack frame for the sender of message if it asked for one.
+// Only called from the room's loop.
+func (r *Room) ack(message *Message, roomSeq int64, status protocol.AckStatus) {
+	if message.frameSeq == 0 || !r.clients[message.source] {
+		return
+	}
+	message.source.enqueue(protocol.EncodeAck(message.frameSeq, roomSeq, status))
+}
@@ -0,0 +1,302 @@
+// Package board keeps the server's authoritative copy of a live room's pages.
+// It applies the same PayloadActionType operations the clients apply and can
+// produce a compact snapshot for late joiners.
+package board
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/rail2025/AetherDraw-Server/drawable"
+	"github.com/rail2025/AetherDraw-Server/protocol"
+)
+
+// MaxPages mirrors the page count limit the clients enforce on plans.
+const MaxPages = 1000
+
+// Errors returned by Apply.
+var (
+	// ErrNotInitialized means the room has not received its initial ReplacePage.
+	ErrNotInitialized = errors.New("board: initial state not set")
+	ErrNoSuchPage     = errors.New("board: page does not exist")
+	ErrTooManyPages   = errors.New("board: page limit reached")
+	ErrPageFull       = errors.New("board: drawable limit reached")
+	// ErrMalformed wraps errors from decoding the payload data.
+	ErrMalformed = errors.New("board: malformed payload data")
+)
+
+type object struct {
+	raw   drawable.Raw
+	order uint64
+}
+
+// page holds the drawables of a single page keyed by GUID.
+type page struct {
+	objects   map[drawable.Guid]*object
+	nextOrder uint64
+	// authoritative is set once the full content of the page is known, i.e.
+	// after a ReplacePage or ClearPage. Pages created by AddNewPage start out
+	// with default waymarks generated locally by each client, which the
+	// server never sees.
+	authoritative bool
+}
+
+func newPage(authoritative bool) *page {
+	return &page{objects: make(map[drawable.Guid]*object), authoritative: authoritative}
+}
+
+// put inserts or replaces a drawable, keeping the z-order of existing ones.
+func (p *page) put(raw drawable.Raw) {
+	if obj, ok := p.objects[raw.ID]; ok {
+		obj.raw = raw
+		return
+	}
+	p.objects[raw.ID] = &object{raw: raw, order: p.nextOrder}
+	p.nextOrder++
+}
+
+// sorted returns the page's drawables in draw order.
+func (p *page) sorted() []drawable.Raw {
+	objs := make([]*object, 0, len(p.objects))
+	for _, obj := range p.objects {
+		objs = append(objs, obj)
+	}
+	sort.Slice(objs, func(i, j int) bool { return objs[i].order < objs[j].order })
+	raws := make([]drawable.Raw, len(objs))
+	for i, obj := range objs {
+		raws[i] = obj.raw
+	}
+	return raws
+}
+
+// Board is the page model of a single room. It is not safe for concurrent use.
+type Board struct {
+	pages []*page
+}
+
+// New returns an empty, uninitialized board.
+func New() *Board {
+	return &Board{}
+}
+
+// Clone returns a copy of the board that can be changed without affecting b.
+func (b *Board) Clone() *Board {
+	clone := &Board{pages: make([]*page, len(b.pages))}
+	for i, pg := range b.pages {
+		objects := make(map[drawable.Guid]*object, len(pg.objects))
+		for id, obj := range pg.objects {
+			copied := *obj
+			objects[id] = &copied
+		}
+		clone.pages[i] = &page{objects: objects, nextOrder: pg.nextOrder, authoritative: pg.authoritative}
+	}
+	return clone
+}
+
+// Initialized reports whether the board has received its initial ReplacePage.
+func (b *Board) Initialized() bool {
+	return len(b.pages) > 0
+}
+
+// PageCount returns the number of pages on the board.
+func (b *Board) PageCount() int {
+	return len(b.pages)
+}
+
+// growTo makes sure a page exists at index, adding client-default pages as
+// the clients do when they receive an update for a page they lack.
+func (b *Board) growTo(index int) error {
+	if index >= MaxPages {
+		return fmt.Errorf("%w: index %d", ErrTooManyPages, index)
+	}
+	for len(b.pages) <= index {
+		b.pages = append(b.pages, newPage(false))
+	}
+	return nil
+}
+
+func (b *Board) page(index int) (*page, error) {
+	if index >= len(b.pages) {
+		return nil, fmt.Errorf("%w: %d of %d", ErrNoSuchPage, index, len(b.pages))
+	}
+	return b.pages[index], nil
+}
+
+// Apply updates the board with a single payload.
+func (b *Board) Apply(p *protocol.NetworkPayload) error {
+	if !b.Initialized() && p.Action != protocol.ReplacePage {
+		return ErrNotInitialized
+	}
+	index := int(p.PageIndex)
+
+	switch p.Action {
+	case protocol.AddObjects, protocol.UpdateObjects:
+		pg, err := b.page(index)
+		if err != nil {
+			return err
+		}
+		raws, err := drawable.SplitPage(p.Data)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrMalformed, err)
+		}
+		// Check the limit up front so a rejected update changes nothing.
+		added := make(map[drawable.Guid]bool)
+		for _, raw := range raws {
+			if _, exists := pg.objects[raw.ID]; !exists {
+				added[raw.ID] = true
+			}
+		}
+		if len(pg.objects)+len(added) > drawable.MaxDrawablesPerPage {
+			return ErrPageFull
+		}
+		for _, raw := range raws {
+			pg.put(raw)
+		}
+
+	case protocol.DeleteObjects:
+		pg, err := b.page(index)
+		if err != nil {
+			return err
+		}
+		ids, err := drawable.DecodeGuidList(p.Data)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrMalformed, err)
+		}
+		for _, id := range ids {
+			delete(pg.objects, id)
+		}
+
+	case protocol.ClearPage:
+		if _, err := b.page(index); err != nil {
+			return err
+		}
+		b.pages[index] = newPage(true)
+
+	case protocol.ReplacePage:
+		raws, err := drawable.SplitPage(p.Data)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrMalformed, err)
+		}
+		if err := b.growTo(index); err != nil {
+			return err
+		}
+		pg := newPage(true)
+		for _, raw := range raws {
+			pg.put(raw)
+		}
+		b.pages[index] = pg
+
+	case protocol.AddNewPage:
+		return b.growTo(index)
+
+	case protocol.DeletePage:
+		if _, err := b.page(index); err != nil {
+			return err
+		}
+		// The clients refuse to delete their last page.
+		if len(b.pages) > 1 {
+			b.pages = append(b.pages[:index], b.pages[index+1:]...)
+		}
+	}
+	return nil
+}
+
+// Snapshot returns the payloads that rebuild the board on a freshly connected
+// client. Authoritative pages are sent as a single ReplacePage; pages the
+// server only knows the additions for are recreated with AddNewPage so the
+// joiner gets the same default layout, followed by their drawables.
+func (b *Board) Snapshot() []*protocol.NetworkPayload {
+	payloads := make([]*protocol.NetworkPayload, 0, len(b.pages))
+	for i, pg := range b.pages {
+		raws := pg.sorted()
+		if pg.authoritative {
+			payloads = append(payloads, &protocol.NetworkPayload{
+				PageIndex: int32(i),
+				Action:    protocol.ReplacePage,
+				Data:      drawable.EncodePage(raws),
+			})
+			continue
+		}
+		payloads = append(payloads, &protocol.NetworkPayload{PageIndex: int32(i), Action: protocol.AddNewPage})
+		if len(raws) > 0 {
+			payloads = append(payloads, &protocol.NetworkPayload{
+				PageIndex: int32(i),
+				Action:    protocol.AddObjects,
+				Data:      drawable.EncodePage(raws),
+			})
+		}
+	}
+	return payloads
+}
+
+// PageState returns a ReplacePage payload carrying the page at index as the
+// board knows it, or false if there is no such page. Pages the server never
+// saw in full (see page.authoritative) carry only the drawables it knows about.
+func (b *Board) PageState(index int) (*protocol.NetworkPayload, bool) {
+	if index >= len(b.pages) {
+		return nil, false
+	}
+	return b.replacement(index), true
+}
+
+// MarshalBinary encodes the board as its snapshot payloads, each prefixed with
+// its length, so it can be checkpointed and restored with UnmarshalBinary.
+func (b *Board) MarshalBinary() ([]byte, error) {
+	var out []byte
+	for _, p := range b.Snapshot() {
+		encoded := p.AppendPayload(nil)
+		out = binary.LittleEndian.AppendUint32(out, uint32(len(encoded)))
+		out = append(out, encoded...)
+	}
+	return out, nil
+}
+
+// UnmarshalBinary replaces the board with one previously encoded by MarshalBinary.
+func (b *Board) UnmarshalBinary(data []byte) error {
+	restored := New()
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return fmt.Errorf("%w: truncated checkpoint", ErrMalformed)
+		}
+		size := binary.LittleEndian.Uint32(data)
+		data = data[4:]
+		if uint64(size) > uint64(len(data)) {
+			return fmt.Errorf("%w: truncated checkpoint", ErrMalformed)
+		}
+		p, err := protocol.DecodePayload(data[:size])
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrMalformed, err)
+		}
+		if err := restored.Apply(p); err != nil {
+			return err
+		}
+		data = data[size:]
+	}
+	*b = *restored
+	return nil
+}
+
+// Objects returns the drawables with the given GUIDs on a page, in draw
+// order, and the GUIDs that are not on it.
+func (b *Board) Objects(index int, ids []drawable.Guid) (present []drawable.Raw, missing []drawable.Guid) {
+	if index >= len(b.pages) {
+		return nil, ids
+	}
+	pg := b.pages[index]
+	wanted := make(map[drawable.Guid]bool, len(ids))
+	for _, id := range ids {
+		if _, ok := pg.objects[id]; ok {
+			wanted[id] = true
+		} else {
+			missing = append(missing, id)
+		}
+	}
+	for _, raw := range pg.sorted() {
+		if wanted[raw.ID] {
+			present = append(present, raw)
+		}
+	}
+	return present, missing
+}
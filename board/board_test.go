@@ -0,0 +1,233 @@
+package board
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/rail2025/AetherDraw-Server/drawable"
+	"github.com/rail2025/AetherDraw-Server/protocol"
+)
+
+// circle returns a serialized circle drawable whose GUID is derived from n.
+func circle(n int) drawable.Raw {
+	var id drawable.Guid
+	binary.LittleEndian.PutUint32(id[:], uint32(n))
+	d := &drawable.Drawable{
+		Mode:  drawable.Circle,
+		Color: drawable.Color{R: 1, A: 1},
+		ID:    id,
+		Body:  &drawable.CircleBody{Center: drawable.Point{X: float32(n), Y: 10}, Radius: 5},
+	}
+	return d.Raw()
+}
+
+// circles returns a page blob holding the circles for ns.
+func circles(ns ...int) []byte {
+	raws := make([]drawable.Raw, len(ns))
+	for i, n := range ns {
+		raws[i] = circle(n)
+	}
+	return drawable.EncodePage(raws)
+}
+
+// span returns the integers from first up to, not including, last.
+func span(first, last int) []int {
+	ns := make([]int, 0, last-first)
+	for n := first; n < last; n++ {
+		ns = append(ns, n)
+	}
+	return ns
+}
+
+// count returns the number of drawables on page index.
+func count(t *testing.T, b *Board, index int) int {
+	t.Helper()
+	p, ok := b.PageState(index)
+	if !ok {
+		t.Fatalf("page %d does not exist", index)
+	}
+	raws, err := drawable.SplitPage(p.Data)
+	if err != nil {
+		t.Fatalf("SplitPage() error = %v", err)
+	}
+	return len(raws)
+}
+
+func TestApply(t *testing.T) {
+	replace := func(page int32, data []byte) *protocol.NetworkPayload {
+		return &protocol.NetworkPayload{PageIndex: page, Action: protocol.ReplacePage, Data: data}
+	}
+	tests := []struct {
+		name  string
+		setup []*protocol.NetworkPayload
+		apply *protocol.NetworkPayload
+		err   error
+		// Pages and drawables per page after apply.
+		pages   int
+		objects []int
+	}{
+		{
+			name:  "update before initial state",
+			apply: &protocol.NetworkPayload{Action: protocol.AddObjects, Data: circles(1)},
+			err:   ErrNotInitialized,
+		},
+		{
+			name:    "initial replace",
+			apply:   replace(0, circles(1, 2)),
+			pages:   1,
+			objects: []int{2},
+		},
+		{
+			name:    "replace grows the board",
+			setup:   []*protocol.NetworkPayload{replace(0, nil)},
+			apply:   replace(2, circles(1)),
+			pages:   3,
+			objects: []int{0, 0, 1},
+		},
+		{
+			name:    "add and update",
+			setup:   []*protocol.NetworkPayload{replace(0, circles(1))},
+			apply:   &protocol.NetworkPayload{Action: protocol.UpdateObjects, Data: circles(1, 2)},
+			pages:   1,
+			objects: []int{2},
+		},
+		{
+			name:    "add to missing page",
+			setup:   []*protocol.NetworkPayload{replace(0, circles(1))},
+			apply:   &protocol.NetworkPayload{PageIndex: 1, Action: protocol.AddObjects, Data: circles(2)},
+			err:     ErrNoSuchPage,
+			pages:   1,
+			objects: []int{1},
+		},
+		{
+			name:    "malformed drawables",
+			setup:   []*protocol.NetworkPayload{replace(0, circles(1))},
+			apply:   &protocol.NetworkPayload{Action: protocol.AddObjects, Data: []byte{1, 0, 0, 0, 1, 0, 0, 0}},
+			err:     ErrMalformed,
+			pages:   1,
+			objects: []int{1},
+		},
+		{
+			name:  "delete objects",
+			setup: []*protocol.NetworkPayload{replace(0, circles(1, 2, 3))},
+			apply: &protocol.NetworkPayload{Action: protocol.DeleteObjects,
+				Data: drawable.EncodeGuidList([]drawable.Guid{circle(1).ID, circle(3).ID})},
+			pages:   1,
+			objects: []int{1},
+		},
+		{
+			name:    "clear page",
+			setup:   []*protocol.NetworkPayload{replace(0, circles(1, 2))},
+			apply:   &protocol.NetworkPayload{Action: protocol.ClearPage},
+			pages:   1,
+			objects: []int{0},
+		},
+		{
+			name:    "delete page",
+			setup:   []*protocol.NetworkPayload{replace(0, circles(1)), replace(1, circles(2, 3))},
+			apply:   &protocol.NetworkPayload{Action: protocol.DeletePage},
+			pages:   1,
+			objects: []int{2},
+		},
+		{
+			name:    "delete last page is refused",
+			setup:   []*protocol.NetworkPayload{replace(0, circles(1))},
+			apply:   &protocol.NetworkPayload{Action: protocol.DeletePage},
+			pages:   1,
+			objects: []int{1},
+		},
+		{
+			name:  "too many pages",
+			setup: []*protocol.NetworkPayload{replace(0, nil)},
+			apply: &protocol.NetworkPayload{PageIndex: MaxPages, Action: protocol.AddNewPage},
+			err:   ErrTooManyPages,
+			pages: 1,
+		},
+		{
+			name:    "page full with updates to existing drawables",
+			setup:   []*protocol.NetworkPayload{replace(0, circles(span(0, drawable.MaxDrawablesPerPage-1)...))},
+			apply:   &protocol.NetworkPayload{Action: protocol.AddObjects, Data: circles(0, 1, drawable.MaxDrawablesPerPage)},
+			pages:   1,
+			objects: []int{drawable.MaxDrawablesPerPage},
+		},
+		{
+			name:    "page full rejects the whole update",
+			setup:   []*protocol.NetworkPayload{replace(0, circles(span(0, drawable.MaxDrawablesPerPage-1)...))},
+			apply:   &protocol.NetworkPayload{Action: protocol.AddObjects, Data: circles(drawable.MaxDrawablesPerPage, drawable.MaxDrawablesPerPage+1)},
+			err:     ErrPageFull,
+			pages:   1,
+			objects: []int{drawable.MaxDrawablesPerPage - 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := New()
+			for _, p := range tt.setup {
+				if err := b.Apply(p); err != nil {
+					t.Fatalf("setup Apply(%s) error = %v", p.Action, err)
+				}
+			}
+			if err := b.Apply(tt.apply); !errors.Is(err, tt.err) {
+				t.Fatalf("Apply() error = %v, want %v", err, tt.err)
+			}
+			if got := b.PageCount(); got != tt.pages {
+				t.Fatalf("PageCount() = %d, want %d", got, tt.pages)
+			}
+			for index, want := range tt.objects {
+				if got := count(t, b, index); got != want {
+					t.Errorf("page %d has %d drawables, want %d", index, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestClone(t *testing.T) {
+	b := New()
+	if err := b.Apply(&protocol.NetworkPayload{Action: protocol.ReplacePage, Data: circles(1, 2)}); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	clone := b.Clone()
+	for _, p := range []*protocol.NetworkPayload{
+		{Action: protocol.AddObjects, Data: circles(3)},
+		{PageIndex: 1, Action: protocol.AddNewPage},
+	} {
+		if err := clone.Apply(p); err != nil {
+			t.Fatalf("clone Apply(%s) error = %v", p.Action, err)
+		}
+	}
+	if b.PageCount() != 1 || count(t, b, 0) != 2 {
+		t.Errorf("changing the clone changed the board")
+	}
+	if clone.PageCount() != 2 || count(t, clone, 0) != 3 {
+		t.Errorf("clone has %d pages", clone.PageCount())
+	}
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	b := New()
+	for _, p := range []*protocol.NetworkPayload{
+		{Action: protocol.ReplacePage, Data: circles(1, 2)},
+		{PageIndex: 1, Action: protocol.AddNewPage},
+		{PageIndex: 1, Action: protocol.AddObjects, Data: circles(3)},
+	} {
+		if err := b.Apply(p); err != nil {
+			t.Fatalf("Apply(%s) error = %v", p.Action, err)
+		}
+	}
+	data, err := b.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary() error = %v", err)
+	}
+	restored := New()
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary() error = %v", err)
+	}
+	if restored.PageCount() != 2 || count(t, restored, 0) != 2 || count(t, restored, 1) != 1 {
+		t.Fatalf("restored board has %d pages", restored.PageCount())
+	}
+	if err := restored.UnmarshalBinary(data[:len(data)-1]); !errors.Is(err, ErrMalformed) {
+		t.Errorf("UnmarshalBinary(truncated) error = %v, want %v", err, ErrMalformed)
+	}
+}
@@ -0,0 +1,144 @@
+package board
+
+import (
+	"github.com/rail2025/AetherDraw-Server/drawable"
+	"github.com/rail2025/AetherDraw-Server/protocol"
+)
+
+// Inverse returns the payloads that undo p, computed against the board's
+// current state, and the GUIDs of the drawables p touches. It must be called
+// before p is applied. ok is false if p cannot be undone: it initializes
+// the board, changes nothing, removes a page other than the last one, or is
+// not valid for the current state.
+//
+// Undoing is best effort. Drawables come back at the end of the z-order, and
+// pages the server never saw in full (see page.authoritative) come back with
+// only the drawables it knows about.
+func (b *Board) Inverse(p *protocol.NetworkPayload) (inverse []*protocol.NetworkPayload, ids []drawable.Guid, ok bool) {
+	if !b.Initialized() {
+		return nil, nil, false
+	}
+	index := int(p.PageIndex)
+
+	switch p.Action {
+	case protocol.AddObjects, protocol.UpdateObjects:
+		pg, err := b.page(index)
+		if err != nil {
+			return nil, nil, false
+		}
+		raws, err := drawable.SplitPage(p.Data)
+		if err != nil || len(raws) == 0 {
+			return nil, nil, false
+		}
+		// Drawables that already existed get their old encoding back; new ones are deleted.
+		var added []drawable.Guid
+		var previous []drawable.Raw
+		seen := make(map[drawable.Guid]bool, len(raws))
+		for _, raw := range raws {
+			ids = append(ids, raw.ID)
+			if seen[raw.ID] {
+				continue
+			}
+			seen[raw.ID] = true
+			if obj, exists := pg.objects[raw.ID]; exists {
+				previous = append(previous, obj.raw)
+			} else {
+				added = append(added, raw.ID)
+			}
+		}
+		if len(added) > 0 {
+			inverse = append(inverse, &protocol.NetworkPayload{PageIndex: p.PageIndex, Action: protocol.DeleteObjects, Data: drawable.EncodeGuidList(added)})
+		}
+		if len(previous) > 0 {
+			inverse = append(inverse, &protocol.NetworkPayload{PageIndex: p.PageIndex, Action: protocol.UpdateObjects, Data: drawable.EncodePage(previous)})
+		}
+		return inverse, ids, true
+
+	case protocol.DeleteObjects:
+		pg, err := b.page(index)
+		if err != nil {
+			return nil, nil, false
+		}
+		ids, err = drawable.DecodeGuidList(p.Data)
+		if err != nil {
+			return nil, nil, false
+		}
+		doomed := make(map[drawable.Guid]bool, len(ids))
+		for _, id := range ids {
+			doomed[id] = true
+		}
+		var deleted []drawable.Raw
+		for _, raw := range pg.sorted() {
+			if doomed[raw.ID] {
+				deleted = append(deleted, raw)
+			}
+		}
+		if len(deleted) == 0 {
+			return nil, nil, false
+		}
+		inverse = append(inverse, &protocol.NetworkPayload{PageIndex: p.PageIndex, Action: protocol.AddObjects, Data: drawable.EncodePage(deleted)})
+		return inverse, ids, true
+
+	case protocol.ClearPage:
+		pg, err := b.page(index)
+		if err != nil || len(pg.objects) == 0 {
+			return nil, nil, false
+		}
+		return []*protocol.NetworkPayload{b.replacement(index)}, pageIDs(pg), true
+
+	case protocol.ReplacePage:
+		if index >= len(b.pages) {
+			// Undoing would mean deleting pages the update created; leave that to the clients.
+			return nil, nil, false
+		}
+		raws, err := drawable.SplitPage(p.Data)
+		if err != nil {
+			return nil, nil, false
+		}
+		for _, raw := range raws {
+			ids = append(ids, raw.ID)
+		}
+		return []*protocol.NetworkPayload{b.replacement(index)}, ids, true
+
+	case protocol.AddNewPage:
+		if index < len(b.pages) || index >= MaxPages {
+			return nil, nil, false
+		}
+		// Remove the added pages again, last first.
+		for i := index; i >= len(b.pages); i-- {
+			inverse = append(inverse, &protocol.NetworkPayload{PageIndex: int32(i), Action: protocol.DeletePage})
+		}
+		return inverse, nil, true
+
+	case protocol.DeletePage:
+		// Pages cannot be inserted in the middle of a plan, so only the last
+		// page can be brought back. The clients refuse to delete their only page.
+		if index != len(b.pages)-1 || len(b.pages) == 1 {
+			return nil, nil, false
+		}
+		inverse = append(inverse,
+			&protocol.NetworkPayload{PageIndex: p.PageIndex, Action: protocol.AddNewPage},
+			b.replacement(index))
+		return inverse, pageIDs(b.pages[index]), true
+	}
+	return nil, nil, false
+}
+
+// replacement returns a ReplacePage payload restoring the page at index as it is now.
+func (b *Board) replacement(index int) *protocol.NetworkPayload {
+	return &protocol.NetworkPayload{
+		PageIndex: int32(index),
+		Action:    protocol.ReplacePage,
+		Data:      drawable.EncodePage(b.pages[index].sorted()),
+	}
+}
+
+// pageIDs returns the GUIDs of the drawables on pg in draw order.
+func pageIDs(pg *page) []drawable.Guid {
+	raws := pg.sorted()
+	ids := make([]drawable.Guid, len(raws))
+	for i, raw := range raws {
+		ids[i] = raw.ID
+	}
+	return ids
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rail2025/AetherDraw-Server/protocol"
+)
+
+// Broker carries room traffic between server instances, so clients connected
+// to different replicas behind a load balancer can share a room. Every
+// instance keeps its own Room for the clients connected to it; the broker
+// forwards relayed frames and presence changes between those rooms.
+type Broker interface {
+	// Publish sends msg to every subscriber of the room, including ones in
+	// this instance. It may wait on the network, so rooms call it from
+	// their publishLoop rather than their event loop.
+	Publish(room string, msg []byte) error
+	// Subscribe calls deliver for every message published to the room until
+	// the returned cancel function is called. deliver must not block for long.
+	Subscribe(room string, deliver func(msg []byte)) (cancel func(), err error)
+	// Close releases the broker's connections.
+	Close() error
+}
+
+// loadBroker returns the broker selected by the BROKER_URL environment
+// variable: a redis:// URL for the Redis broker, or the in-memory broker when unset.
+func loadBroker() (Broker, error) {
+	value := os.Getenv("BROKER_URL")
+	switch {
+	case value == "":
+		return newMemoryBroker(), nil
+	case strings.HasPrefix(value, "redis://"):
+		return newRedisBroker(value)
+	}
+	return nil, errors.New("unsupported BROKER_URL scheme, expected redis://")
+}
+
+// brokerChannel is the channel name a room is published under. Room names
+// are passphrases, so only a hash of them leaves the server.
+func brokerChannel(room string) string {
+	sum := sha256.Sum256([]byte(room))
+	return "aetherdraw:room:" + hex.EncodeToString(sum[:])
+}
+
+// memoryBroker is the default Broker. It connects rooms of hubs in the same
+// process, which for a single server means a room only hears itself.
+type memoryBroker struct {
+	mu     sync.RWMutex
+	nextID int
+	subs   map[string]map[int]func([]byte)
+}
+
+func newMemoryBroker() *memoryBroker {
+	return &memoryBroker{subs: make(map[string]map[int]func([]byte))}
+}
+
+func (b *memoryBroker) Publish(room string, msg []byte) error {
+	b.mu.RLock()
+	delivers := make([]func([]byte), 0, len(b.subs[room]))
+	for _, deliver := range b.subs[room] {
+		delivers = append(delivers, deliver)
+	}
+	b.mu.RUnlock()
+	for _, deliver := range delivers {
+		deliver(msg)
+	}
+	return nil
+}
+
+func (b *memoryBroker) Subscribe(room string, deliver func([]byte)) (func(), error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.nextID++
+	id := b.nextID
+	if b.subs[room] == nil {
+		b.subs[room] = make(map[int]func([]byte))
+	}
+	b.subs[room][id] = deliver
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subs[room], id)
+		if len(b.subs[room]) == 0 {
+			delete(b.subs, room)
+		}
+	}, nil
+}
+
+func (b *memoryBroker) Close() error {
+	return nil
+}
+
+// envelopeKind is the kind of message a room publishes to the broker.
+type envelopeKind byte
+
+const (
+	// envelopeFrame carries a frame relayed by the publishing room.
+	envelopeFrame envelopeKind = iota
+	// envelopePresence carries a Joined or Left presence frame for the publisher's clients.
+	envelopePresence
+	// envelopeSync asks the other instances for their clients and board.
+	envelopeSync
+	// envelopeSnapshot carries the publisher's board, as written by Board.MarshalBinary.
+	envelopeSnapshot
+	// envelopeDeadline carries the room's extended deadline in Unix nanoseconds, as a little-endian int64.
+	envelopeDeadline
+	// envelopeLock carries an ObjectLock frame for locks taken or released on the publisher.
+	envelopeLock
+	// envelopeRevoke carries the ID of a join token revoked on the publisher.
+	envelopeRevoke
+	// envelopeClose says the room's creator closed it.
+	envelopeClose
+)
+
+// errBadEnvelope is returned for broker messages that cannot be parsed.
+var errBadEnvelope = errors.New("malformed broker envelope")
+
+// envelope is a broker message. On the wire it is
+//
+//	[kind byte][instance len byte][instance][ephemeral byte][sourceType len byte][sourceType]
+//	[author len byte][author][body]
+//
+// Ephemeral, sourceType and author only matter for envelopeFrame. The first
+// two say how the frame is fanned out, see Room.handleMessage; author is the
+// client ID of the sender, used in the operation log.
+type envelope struct {
+	kind       envelopeKind
+	instance   string
+	ephemeral  bool
+	sourceType string
+	author     string
+	body       []byte
+}
+
+func (e *envelope) marshal() []byte {
+	buf := make([]byte, 0, 5+len(e.instance)+len(e.sourceType)+len(e.author)+len(e.body))
+	buf = append(buf, byte(e.kind), byte(len(e.instance)))
+	buf = append(buf, e.instance...)
+	ephemeral := byte(0)
+	if e.ephemeral {
+		ephemeral = 1
+	}
+	buf = append(buf, ephemeral, byte(len(e.sourceType)))
+	buf = append(buf, e.sourceType...)
+	buf = append(buf, byte(len(e.author)))
+	buf = append(buf, e.author...)
+	return append(buf, e.body...)
+}
+
+func parseEnvelope(msg []byte) (*envelope, error) {
+	readString := func() (string, bool) {
+		if len(msg) < 1 || len(msg) < 1+int(msg[0]) {
+			return "", false
+		}
+		s := string(msg[1 : 1+int(msg[0])])
+		msg = msg[1+int(msg[0]):]
+		return s, true
+	}
+	if len(msg) < 1 {
+		return nil, errBadEnvelope
+	}
+	e := &envelope{kind: envelopeKind(msg[0])}
+	msg = msg[1:]
+	var ok bool
+	if e.instance, ok = readString(); !ok || len(msg) < 1 {
+		return nil, errBadEnvelope
+	}
+	e.ephemeral = msg[0] == 1
+	msg = msg[1:]
+	if e.sourceType, ok = readString(); !ok {
+		return nil, errBadEnvelope
+	}
+	if e.author, ok = readString(); !ok {
+		return nil, errBadEnvelope
+	}
+	e.body = msg
+	return e, nil
+}
+
+// envelopeInstance returns the instance that published msg without parsing the rest.
+func envelopeInstance(msg []byte) string {
+	if len(msg) < 2 || len(msg) < 2+int(msg[1]) {
+		return ""
+	}
+	return string(msg[2 : 2+int(msg[1])])
+}
+
+// publish queues an envelope about the room for the other instances. It
+// never waits on the broker: if publishLoop has fallen that far behind, the
+// envelope is dropped. Only called from the room's loop.
+func (r *Room) publish(e *envelope) {
+	e.instance = r.hub.instanceID
+	select {
+	case r.outbox <- e.marshal():
+	default:
+		slog.Warn("Broker is falling behind, dropping envelope", "room", roomLogID(r.name), "kind", e.kind)
+	}
+}
+
+// publishLoop sends the room's queued envelopes to the broker in order. It
+// exits once the room has unregistered and the queue is empty.
+func (r *Room) publishLoop() {
+	channel := brokerChannel(r.name)
+	for msg := range r.outbox {
+		if err := r.hub.broker.Publish(channel, msg); err != nil {
+			slog.Warn("Failed to publish to broker", "room", roomLogID(r.name), "kind", envelopeKind(msg[0]), "error", err)
+		}
+	}
+}
+
+// liveBoardWait is how long a room restored from a checkpoint waits for the
+// board of the same room on another instance before using the checkpoint.
+const liveBoardWait = time.Second
+
+// subscribe starts forwarding the room's traffic from other instances to its
+// loop. The broker delivers every room from one goroutine, so a room that
+// falls behind drops envelopes rather than holding up the others.
+func (r *Room) subscribe() {
+	cancel, err := r.hub.broker.Subscribe(brokerChannel(r.name), func(msg []byte) {
+		if envelopeInstance(msg) == r.hub.instanceID {
+			return
+		}
+		select {
+		case r.remote <- msg:
+		case <-r.done:
+		default:
+			slog.Warn("Room is falling behind the broker, dropping envelope", "room", roomLogID(r.name))
+		}
+	})
+	if err != nil {
+		slog.Error("Failed to subscribe to broker, room is local to this instance", "room", roomLogID(r.name), "error", err)
+		return
+	}
+	r.unsubscribe = cancel
+	// Ask the other instances who is in the room and what the board looks like.
+	r.publish(&envelope{kind: envelopeSync})
+}
+
+// handleRemote applies a message published by the room on another instance.
+// Only called from the room's loop.
+func (r *Room) handleRemote(msg []byte) {
+	e, err := parseEnvelope(msg)
+	if err != nil {
+		slog.Warn("Dropping broker message", "room", roomLogID(r.name), "error", err)
+		return
+	}
+	switch e.kind {
+	case envelopeFrame:
+		r.touch()
+		if e.ephemeral {
+			r.relayEphemeral(e.body, nil)
+			return
+		}
+		var op *operation
+		if e.sourceType != "ab" {
+			// The publisher already validated the frame; keep our board in step.
+			payload, err := protocol.DecodeStateUpdate(e.body)
+			if err != nil {
+				slog.Warn("Dropping malformed frame from broker", "room", roomLogID(r.name), "error", err)
+				return
+			}
+			op, _, _ = r.apply(payload)
+		}
+		seq := r.fanOut(e.body, op, nil, e.sourceType)
+		r.logOperation(op, seq, e.author, r.remoteClients[e.author].DisplayName)
+	case envelopePresence:
+		_, body, err := protocol.SplitFrame(e.body)
+		var p *protocol.Presence
+		if err == nil {
+			p, err = protocol.DecodePresence(body)
+		}
+		if err != nil {
+			slog.Warn("Dropping malformed presence from broker", "room", roomLogID(r.name), "error", err)
+			return
+		}
+		r.clientsMux.Lock()
+		for _, entry := range p.Entries {
+			if p.Kind == protocol.PresenceLeft {
+				delete(r.remoteClients, entry.ID)
+			} else {
+				r.remoteClients[entry.ID] = entry
+			}
+		}
+		r.clientsMux.Unlock()
+		if p.Kind == protocol.PresenceLeft {
+			for _, entry := range p.Entries {
+				r.releaseLocks(entry.ID, entry.DisplayName, nil)
+			}
+		}
+		r.sendPresence(p, nil)
+		if len(r.clients) > 0 {
+			r.updateLoneTimer()
+		}
+	case envelopeSync:
+		if len(r.clients) > 0 {
+			joined := &protocol.Presence{Kind: protocol.PresenceJoined, Entries: r.localRoster()}
+			r.publish(&envelope{kind: envelopePresence, body: protocol.EncodePresence(joined)})
+		}
+		r.stateMux.RLock()
+		var snapshot []byte
+		if r.board.Initialized() {
+			snapshot, _ = r.board.MarshalBinary()
+		}
+		r.stateMux.RUnlock()
+		if snapshot != nil {
+			r.publish(&envelope{kind: envelopeSnapshot, body: snapshot})
+		}
+	case envelopeSnapshot:
+		r.stateMux.Lock()
+		if r.board.Initialized() {
+			r.stateMux.Unlock()
+			return
+		}
+		err := r.board.UnmarshalBinary(e.body)
+		payloads := r.board.Snapshot()
+		if err == nil {
+			// The live board supersedes any checkpoint.
+			r.checkpoint = nil
+		}
+		r.stateMux.Unlock()
+		if err != nil {
+			slog.Warn("Dropping malformed board from broker", "room", roomLogID(r.name), "error", err)
+			return
+		}
+		slog.Info("Adopted board from another instance", "room", roomLogID(r.name), "pages", len(payloads))
+		for _, payload := range payloads {
+			r.fanOut(protocol.EncodeStateUpdate(payload), nil, nil, r.clientType)
+		}
+	case envelopeDeadline:
+		if len(e.body) != 8 {
+			slog.Warn("Dropping malformed deadline from broker", "room", roomLogID(r.name))
+			return
+		}
+		deadline := time.Unix(0, int64(binary.LittleEndian.Uint64(e.body)))
+		if deadline.After(r.deadlineAt()) && !deadline.After(r.creationTime.Add(maxRoomLifetime)) {
+			r.setDeadline(deadline)
+			r.sendExtended(nil)
+		}
+	case envelopeLock:
+		r.adoptLock(e.body)
+	case envelopeRevoke:
+		id := string(e.body)
+		r.addRevocation(id)
+		r.disconnectToken(id)
+	case envelopeClose:
+		r.requestClose(closeAdmin)
+	}
+}
+
+// useCheckpoint puts the board restored from a checkpoint in place, unless
+// the room got a live board from another instance or a client meanwhile.
+// Only called from the room's loop.
+func (r *Room) useCheckpoint() {
+	r.stateMux.Lock()
+	restored := r.checkpoint
+	r.checkpoint = nil
+	var payloads []*protocol.NetworkPayload
+	if restored != nil && !r.board.Initialized() {
+		r.board = restored
+		payloads = restored.Snapshot()
+	}
+	r.stateMux.Unlock()
+	if payloads == nil {
+		return
+	}
+	slog.Info("Restored board from checkpoint", "room", roomLogID(r.name), "pages", restored.PageCount())
+	for _, payload := range payloads {
+		r.fanOut(protocol.EncodeStateUpdate(payload), nil, nil, r.clientType)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/rail2025/AetherDraw-Server/board"
+	"github.com/rail2025/AetherDraw-Server/protocol"
+)
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		in   envelope
+	}{
+		{"sync", envelope{kind: envelopeSync, instance: "inst"}},
+		{"frame", envelope{kind: envelopeFrame, instance: "inst", sourceType: "ad", author: "client", body: []byte{1, 2, 3}}},
+		{"ephemeral frame", envelope{kind: envelopeFrame, instance: "inst", ephemeral: true, sourceType: "ad-web", body: []byte{4}}},
+		{"revoke", envelope{kind: envelopeRevoke, instance: "inst", body: []byte("token")}},
+		{"empty instance", envelope{kind: envelopeClose}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := tt.in.marshal()
+			if got := envelopeInstance(msg); got != tt.in.instance {
+				t.Errorf("envelopeInstance() = %q, want %q", got, tt.in.instance)
+			}
+			got, err := parseEnvelope(msg)
+			if err != nil {
+				t.Fatalf("parseEnvelope() error = %v", err)
+			}
+			if got.kind != tt.in.kind || got.instance != tt.in.instance || got.ephemeral != tt.in.ephemeral ||
+				got.sourceType != tt.in.sourceType || got.author != tt.in.author || !bytes.Equal(got.body, tt.in.body) {
+				t.Errorf("parseEnvelope() = %+v, want %+v", got, tt.in)
+			}
+		})
+	}
+}
+
+func TestParseEnvelopeMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  []byte
+	}{
+		{"empty", nil},
+		{"no instance", []byte{byte(envelopeFrame)}},
+		{"short instance", []byte{byte(envelopeFrame), 4, 'a', 'b'}},
+		{"no ephemeral flag", []byte{byte(envelopeFrame), 1, 'a'}},
+		{"no source type", []byte{byte(envelopeFrame), 1, 'a', 0}},
+		{"short source type", []byte{byte(envelopeFrame), 1, 'a', 0, 2, 'a'}},
+		{"no author", []byte{byte(envelopeFrame), 1, 'a', 0, 2, 'a', 'd'}},
+		{"short author", []byte{byte(envelopeFrame), 1, 'a', 0, 2, 'a', 'd', 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseEnvelope(tt.msg); !errors.Is(err, errBadEnvelope) {
+				t.Errorf("parseEnvelope() error = %v, want %v", err, errBadEnvelope)
+			}
+		})
+	}
+}
+
+// TestHubsSharingBroker checks that a room stuck on one instance does not
+// hold up the same room on another. Room loops that waited on each other's
+// queues could deadlock once both were busy.
+func TestHubsSharingBroker(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	defer func(policy string) { slowConsumerPolicy = policy }(slowConsumerPolicy)
+	// The clients have no connection to evict.
+	slowConsumerPolicy = slowConsumerDropOldest
+
+	broker := newMemoryBroker()
+	hubs := make([]*Hub, 2)
+	clients := make([]*Client, 2)
+	for i := range hubs {
+		hub := closeRoomsOnCleanup(t, broker)
+		hubs[i] = hub
+		clients[i] = newTestClient(t, hub, "shared")
+		hub.join(clients[i])
+	}
+	sender, receiver := clients[0], clients[1]
+
+	initial := circleUpdate(protocol.ReplacePage, 0)
+	sender.deliver(&Message{room: "shared", data: protocol.EncodeStateUpdate(initial), source: sender, payload: initial})
+	frame := nextFrame(t, receiver, protocol.StateUpdate)
+	if got, err := protocol.DecodeStateUpdate(frame); err != nil || got.Action != protocol.ReplacePage {
+		t.Fatalf("other instance got %v, %v, want the initial page", got, err)
+	}
+
+	// Stall the receiving room's loop on its board.
+	hubs[1].roomsMux.RLock()
+	stalled := hubs[1].rooms["shared"]
+	hubs[1].roomsMux.RUnlock()
+	stalled.stateMux.Lock()
+	done := make(chan struct{})
+	go func() {
+		for n := range 8 * roomInboxSize {
+			payload := circleUpdate(protocol.UpdateObjects, n)
+			sender.deliver(&Message{room: "shared", data: protocol.EncodeStateUpdate(payload), source: sender, payload: payload})
+		}
+		sender.leaveRoom()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("sending room stopped responding while the other instance was stuck")
+	}
+	stalled.stateMux.Unlock()
+	receiver.leaveRoom()
+}
+
+// restoringClient returns a client of hub that reopens room from a
+// checkpoint holding page.
+func restoringClient(t *testing.T, hub *Hub, room string, page *protocol.NetworkPayload) *Client {
+	t.Helper()
+	b := board.New()
+	if err := b.Apply(page); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	c := newTestClient(t, hub, room)
+	c.restore = &roomCheckpoint{clientType: "ad", creationTime: time.Now(), policy: defaultRoomPolicy(room, "ad"), board: b}
+	return c
+}
+
+// TestStaleCheckpointYieldsToLiveBoard checks that an instance reopening a
+// room from a checkpoint takes the board of the room live on another
+// instance instead.
+func TestStaleCheckpointYieldsToLiveBoard(t *testing.T) {
+	broker := newMemoryBroker()
+	live, restoring := closeRoomsOnCleanup(t, broker), closeRoomsOnCleanup(t, broker)
+	author := newTestClient(t, live, "split")
+	live.join(author)
+	current := circleUpdate(protocol.ReplacePage, 1)
+	author.deliver(&Message{room: "split", data: protocol.EncodeStateUpdate(current), source: author, payload: current, frameSeq: 1})
+	nextFrame(t, author, protocol.Ack)
+
+	joiner := restoringClient(t, restoring, "split", circleUpdate(protocol.ReplacePage, 2))
+	restoring.join(joiner)
+	got, err := protocol.DecodeStateUpdate(nextFrame(t, joiner, protocol.StateUpdate))
+	if err != nil || !bytes.Equal(got.Data, current.Data) {
+		t.Fatalf("joiner got %v, %v, want the live page", got, err)
+	}
+
+	// The board stays the live one after the checkpoint would have been used.
+	time.Sleep(liveBoardWait + 100*time.Millisecond)
+	boards := make([][]byte, 2)
+	for i, hub := range []*Hub{live, restoring} {
+		hub.roomsMux.RLock()
+		room := hub.rooms["split"]
+		hub.roomsMux.RUnlock()
+		room.stateMux.RLock()
+		boards[i], _ = room.board.MarshalBinary()
+		room.stateMux.RUnlock()
+	}
+	if !bytes.Equal(boards[0], boards[1]) {
+		t.Errorf("instances hold different boards")
+	}
+}
+
+func TestCheckpointUsedWithoutLiveBoard(t *testing.T) {
+	hub := newTestHub(t)
+	restored := circleUpdate(protocol.ReplacePage, 2)
+	joiner := restoringClient(t, hub, "alone", restored)
+	hub.join(joiner)
+	select {
+	case frame := <-joiner.send:
+		if len(frame) > 0 && protocol.MessageType(frame[0]) == protocol.StateUpdate {
+			t.Fatal("checkpoint used before other instances could answer")
+		}
+	case <-time.After(liveBoardWait / 2):
+	}
+	got, err := protocol.DecodeStateUpdate(nextFrame(t, joiner, protocol.StateUpdate))
+	if err != nil || !bytes.Equal(got.Data, restored.Data) {
+		t.Fatalf("joiner got %v, %v, want the checkpointed page", got, err)
+	}
+}
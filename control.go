@@ -0,0 +1,51 @@
+package main
+
+import (
+	"log/slog"
+
+	"github.com/rail2025/AetherDraw-Server/protocol"
+)
+
+// isControlFrame reports whether an AetherDraw frame is a request to the room
+// rather than an update for the other clients.
+func isControlFrame(frame []byte) bool {
+	if len(frame) == 0 {
+		return false
+	}
+	switch protocol.MessageType(frame[0]) {
+	case protocol.RoomExtension, protocol.OperationLog, protocol.UndoOperation, protocol.ObjectLock, protocol.PageSubscription:
+		return true
+	}
+	return false
+}
+
+// controlMessage parses a control frame into the message handed to the
+// room, or returns nil if the frame is malformed.
+func (c *Client) controlMessage(frame []byte) *Message {
+	message := &Message{room: c.room, source: c}
+	var err error
+	switch protocol.MessageType(frame[0]) {
+	case protocol.RoomExtension:
+		message.extension, err = protocol.DecodeExtensionRequest(frame[1:])
+	case protocol.UndoOperation:
+		message.undo, err = protocol.DecodeUndoRequest(frame[1:])
+	case protocol.OperationLog:
+		message.opLog = true
+	case protocol.ObjectLock:
+		var locked bool
+		var ids [][16]byte
+		if locked, ids, err = protocol.DecodeLockRequest(frame[1:]); err == nil {
+			message.lock = newLockRequest(locked, ids)
+		}
+	case protocol.PageSubscription:
+		var pages []int32
+		if pages, err = protocol.DecodePageSubscription(frame[1:]); err == nil {
+			message.subscribe, message.subscription = true, newPageFilter(pages)
+		}
+	}
+	if err != nil {
+		slog.Warn("Dropping malformed control frame", "room", roomLogID(c.room), "type", protocol.MessageType(frame[0]), "error", err)
+		return nil
+	}
+	return message
+}
@@ -0,0 +1,337 @@
+// Package drawable understands the page blobs produced by DrawableSerializer
+// (drawableSerializer.js in the web client, DrawableSerializer.cs in the
+// plugin). A page is laid out as:
+//
+//	[version int32][count int32][drawable]...
+//
+// and every drawable starts with a common header followed by a body whose
+// shape depends on its DrawMode. SplitPage keeps drawables as opaque bytes;
+// DecodePage decodes them into typed Drawables.
+package drawable
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	// SerializationVersion is the only page version the clients write.
+	SerializationVersion = 1
+	// MaxDrawablesPerPage mirrors MAX_DRAWABLES_PER_PAGE in the clients.
+	MaxDrawablesPerPage = 10000
+	// MaxPointsPerObject mirrors MAX_POINTS_PER_OBJECT in the clients.
+	MaxPointsPerObject = 50000
+	// MaxGuidsPerList mirrors the count check in PlanSerializer.deserializeGuids.
+	MaxGuidsPerList = 10000
+)
+
+// headerSize is mode + RGBA + thickness + isFilled + GUID.
+const headerSize = 1 + 4*4 + 4 + 1 + 16
+
+// Errors returned while walking serialized drawables.
+var (
+	ErrTruncated       = errors.New("drawable: data truncated")
+	ErrVersion         = errors.New("drawable: unsupported serialization version")
+	ErrTooManyObjects  = errors.New("drawable: too many drawables")
+	ErrTooManyPoints   = errors.New("drawable: too many points")
+	ErrStringTooLong   = errors.New("drawable: string length out of range")
+	ErrTrailingData    = errors.New("drawable: trailing data after last drawable")
+	ErrBadGuidListSize = errors.New("drawable: invalid GUID list length")
+)
+
+// DrawMode identifies the kind of drawable. Values match drawMode.js.
+type DrawMode byte
+
+const (
+	Pen DrawMode = iota
+	StraightLine
+	Rectangle
+	Circle
+	Arrow
+	Cone
+	Dash
+	Donut
+	Triangle
+	Select
+	Eraser
+	Image
+	EmojiImage
+	BossImage
+	CircleAoEImage
+	DonutAoEImage
+	FlareImage
+	LineStackImage
+	SpreadImage
+	StackImage
+	Waymark1Image
+	Waymark2Image
+	Waymark3Image
+	Waymark4Image
+	WaymarkAImage
+	WaymarkBImage
+	WaymarkCImage
+	WaymarkDImage
+	RoleTankImage
+	RoleHealerImage
+	RoleMeleeImage
+	RoleRangedImage
+	TriangleImage
+	SquareImage
+	PlusImage
+	CircleMarkImage
+	Party1Image
+	Party2Image
+	Party3Image
+	Party4Image
+	Party5Image
+	Party6Image
+	Party7Image
+	Party8Image
+	TextTool
+	StackIcon
+	SpreadIcon
+	TetherIcon
+	BossIconPlaceholder
+	AddMobIcon
+	Dot1Image
+	Dot2Image
+	Dot3Image
+	Dot4Image
+	Dot5Image
+	Dot6Image
+	Dot7Image
+	Dot8Image
+)
+
+// IsImage reports whether m is serialized with the image body
+// (pluginResourcePath, position, size, rotation).
+func (m DrawMode) IsImage() bool {
+	return m >= Image && m <= Dot8Image && m != TextTool
+}
+
+// hasBody reports whether the clients write (and read back) a body for m.
+func (m DrawMode) hasBody() bool {
+	return m <= Dot8Image && m != Select && m != Eraser
+}
+
+// Guid is a drawable's unique ID in canonical (string) byte order.
+type Guid [16]byte
+
+// String formats g as xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx.
+func (g Guid) String() string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", g[0:4], g[4:6], g[6:8], g[8:10], g[10:16])
+}
+
+// guidFromDotNet converts the mixed-endian layout written by .NET's
+// Guid.ToByteArray (and BufferHandler.writeGuid) into canonical order.
+func guidFromDotNet(b []byte) Guid {
+	return Guid{
+		b[3], b[2], b[1], b[0],
+		b[5], b[4],
+		b[7], b[6],
+		b[8], b[9], b[10], b[11], b[12], b[13], b[14], b[15],
+	}
+}
+
+// Raw is a single serialized drawable kept as opaque bytes.
+type Raw struct {
+	Mode DrawMode
+	ID   Guid
+	// Bytes holds the full encoding, header included.
+	Bytes []byte
+}
+
+// reader walks a little-endian buffer, failing with ErrTruncated on overrun.
+type reader struct {
+	buf []byte
+	off int
+}
+
+func (r *reader) need(n int) error {
+	if n < 0 || len(r.buf)-r.off < n {
+		return ErrTruncated
+	}
+	return nil
+}
+
+func (r *reader) skip(n int) error {
+	if err := r.need(n); err != nil {
+		return err
+	}
+	r.off += n
+	return nil
+}
+
+func (r *reader) readInt32() (int32, error) {
+	if err := r.need(4); err != nil {
+		return 0, err
+	}
+	v := int32(binary.LittleEndian.Uint32(r.buf[r.off:]))
+	r.off += 4
+	return v, nil
+}
+
+// read7BitEncodedInt mirrors BinaryReader.Read7BitEncodedInt.
+func (r *reader) read7BitEncodedInt() (int, error) {
+	result, shift := 0, 0
+	for {
+		if err := r.need(1); err != nil {
+			return 0, err
+		}
+		b := r.buf[r.off]
+		r.off++
+		result |= int(b&0x7F) << shift
+		if b&0x80 == 0 {
+			return result, nil
+		}
+		shift += 7
+		if shift >= 35 {
+			return 0, ErrStringTooLong
+		}
+	}
+}
+
+func (r *reader) skipString() error {
+	n, err := r.read7BitEncodedInt()
+	if err != nil {
+		return err
+	}
+	return r.skip(n)
+}
+
+// skipPoints skips an int32-prefixed list of float32 pairs.
+func (r *reader) skipPoints() error {
+	count, err := r.readInt32()
+	if err != nil {
+		return err
+	}
+	if count < 0 || count > MaxPointsPerObject {
+		return fmt.Errorf("%w: %d", ErrTooManyPoints, count)
+	}
+	return r.skip(int(count) * 8)
+}
+
+// skipBody advances past the mode-specific body of a drawable.
+func (r *reader) skipBody(mode DrawMode) error {
+	switch {
+	case mode == Pen:
+		return r.skipPoints()
+	case mode == StraightLine:
+		return r.skip(4 * 4)
+	case mode == Rectangle, mode == Cone:
+		return r.skip(5 * 4)
+	case mode == Arrow:
+		return r.skip(7 * 4)
+	case mode == Circle, mode == Donut:
+		return r.skip(3 * 4)
+	case mode == Dash:
+		if err := r.skipPoints(); err != nil {
+			return err
+		}
+		return r.skip(2 * 4)
+	case mode == Triangle:
+		return r.skip(6 * 4)
+	case mode == TextTool:
+		if err := r.skipString(); err != nil {
+			return err
+		}
+		return r.skip(4 * 4)
+	case mode.IsImage():
+		if err := r.skipString(); err != nil {
+			return err
+		}
+		return r.skip(5 * 4)
+	}
+	// Modes without a body (Select, Eraser, unknown) are written header-only.
+	return nil
+}
+
+// SplitPage splits a serialized page into its individual drawables. Drawables
+// whose mode has no body are consumed and dropped, as the clients do.
+func SplitPage(data []byte) ([]Raw, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	r := &reader{buf: data}
+	version, err := r.readInt32()
+	if err != nil {
+		return nil, err
+	}
+	if version != SerializationVersion {
+		return nil, fmt.Errorf("%w: %d", ErrVersion, version)
+	}
+	count, err := r.readInt32()
+	if err != nil {
+		return nil, err
+	}
+	if count < 0 || count > MaxDrawablesPerPage {
+		return nil, fmt.Errorf("%w: %d", ErrTooManyObjects, count)
+	}
+
+	raws := make([]Raw, 0, count)
+	for i := int32(0); i < count; i++ {
+		start := r.off
+		if err := r.need(headerSize); err != nil {
+			return nil, fmt.Errorf("drawable %d: %w", i, err)
+		}
+		mode := DrawMode(r.buf[start])
+		id := guidFromDotNet(r.buf[start+headerSize-16 : start+headerSize])
+		r.off += headerSize
+		if err := r.skipBody(mode); err != nil {
+			return nil, fmt.Errorf("drawable %d (mode %d): %w", i, mode, err)
+		}
+		if !mode.hasBody() {
+			continue
+		}
+		raws = append(raws, Raw{Mode: mode, ID: id, Bytes: append([]byte(nil), r.buf[start:r.off]...)})
+	}
+	if r.off != len(r.buf) {
+		return nil, fmt.Errorf("%w: %d bytes", ErrTrailingData, len(r.buf)-r.off)
+	}
+	return raws, nil
+}
+
+// EncodePage serializes raws back into a page blob.
+func EncodePage(raws []Raw) []byte {
+	size := 8
+	for _, raw := range raws {
+		size += len(raw.Bytes)
+	}
+	out := make([]byte, 0, size)
+	out = binary.LittleEndian.AppendUint32(out, SerializationVersion)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(raws)))
+	for _, raw := range raws {
+		out = append(out, raw.Bytes...)
+	}
+	return out
+}
+
+// DecodeGuidList parses the DeleteObjects payload written by
+// PlanSerializer.serializeGuids: an int32 count followed by 16-byte GUIDs in
+// canonical byte order.
+func DecodeGuidList(data []byte) ([]Guid, error) {
+	r := &reader{buf: data}
+	count, err := r.readInt32()
+	if err != nil {
+		return nil, err
+	}
+	if count < 0 || count > MaxGuidsPerList || int(count)*16 != len(data)-4 {
+		return nil, fmt.Errorf("%w: count %d in %d bytes", ErrBadGuidListSize, count, len(data))
+	}
+	ids := make([]Guid, count)
+	for i := range ids {
+		copy(ids[i][:], data[4+i*16:])
+	}
+	return ids, nil
+}
+
+// EncodeGuidList is the inverse of DecodeGuidList.
+func EncodeGuidList(ids []Guid) []byte {
+	out := make([]byte, 0, 4+len(ids)*16)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(ids)))
+	for _, id := range ids {
+		out = append(out, id[:]...)
+	}
+	return out
+}
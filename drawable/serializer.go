@@ -0,0 +1,385 @@
+package drawable
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Point is a position on the canvas.
+type Point struct {
+	X, Y float32
+}
+
+// Color is an RGBA color with components in [0, 1].
+type Color struct {
+	R, G, B, A float32
+}
+
+// Drawable is a decoded drawable: the common header every drawable starts
+// with, and the body for its mode.
+type Drawable struct {
+	Mode      DrawMode
+	Color     Color
+	Thickness float32
+	IsFilled  bool
+	ID        Guid
+	// Body is nil for modes the clients write header-only (Select, Eraser).
+	// Otherwise its type must match Mode, see the Body types.
+	Body Body
+}
+
+// Body is the mode-specific part of a drawable. Rotations are in radians,
+// as written on the wire; the web client converts them to degrees.
+type Body interface {
+	appendTo(dst []byte) []byte
+}
+
+// PathBody is the body of a Pen drawable.
+type PathBody struct {
+	Points []Point
+}
+
+// LineBody is the body of a StraightLine drawable.
+type LineBody struct {
+	Start, End Point
+}
+
+// RectangleBody is the body of a Rectangle drawable.
+type RectangleBody struct {
+	Start, End Point
+	Rotation   float32
+}
+
+// ArrowBody is the body of an Arrow drawable.
+type ArrowBody struct {
+	Start, End            Point
+	Rotation              float32
+	ArrowheadLengthOffset float32
+	ArrowheadWidthScale   float32
+}
+
+// CircleBody is the body of a Circle or Donut drawable.
+type CircleBody struct {
+	Center Point
+	Radius float32
+}
+
+// ConeBody is the body of a Cone drawable.
+type ConeBody struct {
+	Apex, BaseCenter Point
+	Rotation         float32
+}
+
+// DashBody is the body of a Dash drawable.
+type DashBody struct {
+	Points     []Point
+	DashLength float32
+	GapLength  float32
+}
+
+// TriangleBody is the body of a Triangle drawable.
+type TriangleBody struct {
+	Vertices [3]Point
+}
+
+// TextBody is the body of a TextTool drawable.
+type TextBody struct {
+	Text          string
+	Position      Point
+	FontSize      float32
+	WrappingWidth float32
+}
+
+// ImageBody is the body of the image modes, see DrawMode.IsImage. Position is
+// the image's center.
+type ImageBody struct {
+	PluginResourcePath string
+	Position           Point
+	Width, Height      float32
+	Rotation           float32
+}
+
+// DecodePage parses a page blob into typed drawables. Like the clients, it
+// drops drawables whose mode has no body.
+func DecodePage(data []byte) ([]*Drawable, error) {
+	raws, err := SplitPage(data)
+	if err != nil {
+		return nil, err
+	}
+	drawables := make([]*Drawable, len(raws))
+	for i, raw := range raws {
+		if drawables[i], err = raw.Decode(); err != nil {
+			return nil, fmt.Errorf("drawable %d (mode %d): %w", i, raw.Mode, err)
+		}
+	}
+	return drawables, nil
+}
+
+// EncodeDrawables serializes drawables into a page blob, the inverse of DecodePage.
+func EncodeDrawables(drawables []*Drawable) []byte {
+	out := binary.LittleEndian.AppendUint32(nil, SerializationVersion)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(drawables)))
+	for _, d := range drawables {
+		out = d.appendTo(out)
+	}
+	return out
+}
+
+// Decode parses raw into a typed drawable.
+func (raw Raw) Decode() (*Drawable, error) {
+	r := &reader{buf: raw.Bytes}
+	d, err := r.readDrawable()
+	if err != nil {
+		return nil, err
+	}
+	if r.off != len(r.buf) {
+		return nil, fmt.Errorf("%w: %d bytes", ErrTrailingData, len(r.buf)-r.off)
+	}
+	return d, nil
+}
+
+// Raw returns d serialized as a Raw.
+func (d *Drawable) Raw() Raw {
+	return Raw{Mode: d.Mode, ID: d.ID, Bytes: d.appendTo(nil)}
+}
+
+func (d *Drawable) appendTo(dst []byte) []byte {
+	dst = append(dst, byte(d.Mode))
+	dst = appendFloat32s(dst, d.Color.R, d.Color.G, d.Color.B, d.Color.A, d.Thickness)
+	if d.IsFilled {
+		dst = append(dst, 1)
+	} else {
+		dst = append(dst, 0)
+	}
+	dst = append(dst, guidToDotNet(d.ID)...)
+	if d.Body != nil {
+		dst = d.Body.appendTo(dst)
+	}
+	return dst
+}
+
+func (b *PathBody) appendTo(dst []byte) []byte {
+	return appendPoints(dst, b.Points)
+}
+
+func (b *LineBody) appendTo(dst []byte) []byte {
+	return appendFloat32s(dst, b.Start.X, b.Start.Y, b.End.X, b.End.Y)
+}
+
+func (b *RectangleBody) appendTo(dst []byte) []byte {
+	return appendFloat32s(dst, b.Start.X, b.Start.Y, b.End.X, b.End.Y, b.Rotation)
+}
+
+func (b *ArrowBody) appendTo(dst []byte) []byte {
+	return appendFloat32s(dst, b.Start.X, b.Start.Y, b.End.X, b.End.Y, b.Rotation, b.ArrowheadLengthOffset, b.ArrowheadWidthScale)
+}
+
+func (b *CircleBody) appendTo(dst []byte) []byte {
+	return appendFloat32s(dst, b.Center.X, b.Center.Y, b.Radius)
+}
+
+func (b *ConeBody) appendTo(dst []byte) []byte {
+	return appendFloat32s(dst, b.Apex.X, b.Apex.Y, b.BaseCenter.X, b.BaseCenter.Y, b.Rotation)
+}
+
+func (b *DashBody) appendTo(dst []byte) []byte {
+	dst = appendPoints(dst, b.Points)
+	return appendFloat32s(dst, b.DashLength, b.GapLength)
+}
+
+func (b *TriangleBody) appendTo(dst []byte) []byte {
+	v := b.Vertices
+	return appendFloat32s(dst, v[0].X, v[0].Y, v[1].X, v[1].Y, v[2].X, v[2].Y)
+}
+
+func (b *TextBody) appendTo(dst []byte) []byte {
+	dst = appendString(dst, b.Text)
+	return appendFloat32s(dst, b.Position.X, b.Position.Y, b.FontSize, b.WrappingWidth)
+}
+
+func (b *ImageBody) appendTo(dst []byte) []byte {
+	dst = appendString(dst, b.PluginResourcePath)
+	return appendFloat32s(dst, b.Position.X, b.Position.Y, b.Width, b.Height, b.Rotation)
+}
+
+// readDrawable mirrors DrawableSerializer._deserializeSingleDrawable.
+func (r *reader) readDrawable() (*Drawable, error) {
+	if err := r.need(headerSize); err != nil {
+		return nil, err
+	}
+	d := &Drawable{Mode: DrawMode(r.buf[r.off])}
+	r.off++
+	header, _ := r.readFloat32s(5)
+	d.Color = Color{header[0], header[1], header[2], header[3]}
+	d.Thickness = header[4]
+	d.IsFilled = r.buf[r.off] == 1
+	d.ID = guidFromDotNet(r.buf[r.off+1 : r.off+17])
+	r.off += 17
+
+	var err error
+	d.Body, err = r.readBody(d.Mode)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (r *reader) readBody(mode DrawMode) (Body, error) {
+	switch {
+	case mode == Pen:
+		points, err := r.readPoints()
+		if err != nil {
+			return nil, err
+		}
+		return &PathBody{Points: points}, nil
+	case mode == StraightLine:
+		f, err := r.readFloat32s(4)
+		if err != nil {
+			return nil, err
+		}
+		return &LineBody{Start: Point{f[0], f[1]}, End: Point{f[2], f[3]}}, nil
+	case mode == Rectangle:
+		f, err := r.readFloat32s(5)
+		if err != nil {
+			return nil, err
+		}
+		return &RectangleBody{Start: Point{f[0], f[1]}, End: Point{f[2], f[3]}, Rotation: f[4]}, nil
+	case mode == Arrow:
+		f, err := r.readFloat32s(7)
+		if err != nil {
+			return nil, err
+		}
+		return &ArrowBody{Start: Point{f[0], f[1]}, End: Point{f[2], f[3]}, Rotation: f[4], ArrowheadLengthOffset: f[5], ArrowheadWidthScale: f[6]}, nil
+	case mode == Circle, mode == Donut:
+		f, err := r.readFloat32s(3)
+		if err != nil {
+			return nil, err
+		}
+		return &CircleBody{Center: Point{f[0], f[1]}, Radius: f[2]}, nil
+	case mode == Cone:
+		f, err := r.readFloat32s(5)
+		if err != nil {
+			return nil, err
+		}
+		return &ConeBody{Apex: Point{f[0], f[1]}, BaseCenter: Point{f[2], f[3]}, Rotation: f[4]}, nil
+	case mode == Dash:
+		points, err := r.readPoints()
+		if err != nil {
+			return nil, err
+		}
+		f, err := r.readFloat32s(2)
+		if err != nil {
+			return nil, err
+		}
+		return &DashBody{Points: points, DashLength: f[0], GapLength: f[1]}, nil
+	case mode == Triangle:
+		f, err := r.readFloat32s(6)
+		if err != nil {
+			return nil, err
+		}
+		return &TriangleBody{Vertices: [3]Point{{f[0], f[1]}, {f[2], f[3]}, {f[4], f[5]}}}, nil
+	case mode == TextTool:
+		text, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		f, err := r.readFloat32s(4)
+		if err != nil {
+			return nil, err
+		}
+		return &TextBody{Text: text, Position: Point{f[0], f[1]}, FontSize: f[2], WrappingWidth: f[3]}, nil
+	case mode.IsImage():
+		path, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		f, err := r.readFloat32s(5)
+		if err != nil {
+			return nil, err
+		}
+		return &ImageBody{PluginResourcePath: path, Position: Point{f[0], f[1]}, Width: f[2], Height: f[3], Rotation: f[4]}, nil
+	}
+	return nil, nil
+}
+
+func (r *reader) readFloat32s(n int) ([]float32, error) {
+	if err := r.need(n * 4); err != nil {
+		return nil, err
+	}
+	f := make([]float32, n)
+	for i := range f {
+		f[i] = math.Float32frombits(binary.LittleEndian.Uint32(r.buf[r.off:]))
+		r.off += 4
+	}
+	return f, nil
+}
+
+// readPoints reads an int32-prefixed list of float32 pairs.
+func (r *reader) readPoints() ([]Point, error) {
+	count, err := r.readInt32()
+	if err != nil {
+		return nil, err
+	}
+	if count < 0 || count > MaxPointsPerObject {
+		return nil, fmt.Errorf("%w: %d", ErrTooManyPoints, count)
+	}
+	f, err := r.readFloat32s(int(count) * 2)
+	if err != nil {
+		return nil, err
+	}
+	points := make([]Point, count)
+	for i := range points {
+		points[i] = Point{f[2*i], f[2*i+1]}
+	}
+	return points, nil
+}
+
+func (r *reader) readString() (string, error) {
+	n, err := r.read7BitEncodedInt()
+	if err != nil {
+		return "", err
+	}
+	if err := r.need(n); err != nil {
+		return "", err
+	}
+	s := string(r.buf[r.off : r.off+n])
+	r.off += n
+	return s, nil
+}
+
+func appendFloat32s(dst []byte, values ...float32) []byte {
+	for _, v := range values {
+		dst = binary.LittleEndian.AppendUint32(dst, math.Float32bits(v))
+	}
+	return dst
+}
+
+func appendPoints(dst []byte, points []Point) []byte {
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(len(points)))
+	for _, p := range points {
+		dst = appendFloat32s(dst, p.X, p.Y)
+	}
+	return dst
+}
+
+// appendString mirrors BinaryWriter.Write(string).
+func appendString(dst []byte, s string) []byte {
+	n := uint32(len(s))
+	for n >= 0x80 {
+		dst = append(dst, byte(n)|0x80)
+		n >>= 7
+	}
+	dst = append(dst, byte(n))
+	return append(dst, s...)
+}
+
+// guidToDotNet is the inverse of guidFromDotNet.
+func guidToDotNet(g Guid) []byte {
+	return []byte{
+		g[3], g[2], g[1], g[0],
+		g[5], g[4],
+		g[7], g[6],
+		g[8], g[9], g[10], g[11], g[12], g[13], g[14], g[15],
+	}
+}
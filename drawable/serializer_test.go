@@ -0,0 +1,200 @@
+package drawable
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+// goldenPage is a page written by the web client's DrawableSerializer.
+type goldenPage struct {
+	name string
+	data []byte
+}
+
+// loadGoldenPages reads testdata/pages.golden, written by testdata/golden.js:
+// one page per draw mode, in DrawMode order.
+func loadGoldenPages(tb testing.TB) []goldenPage {
+	tb.Helper()
+	f, err := os.Open("testdata/pages.golden")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer f.Close()
+	var pages []goldenPage
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name, encoded, ok := strings.Cut(scanner.Text(), " ")
+		data, err := hex.DecodeString(encoded)
+		if !ok || err != nil {
+			tb.Fatalf("malformed golden line %q", scanner.Text())
+		}
+		pages = append(pages, goldenPage{name, data})
+	}
+	if err := scanner.Err(); err != nil {
+		tb.Fatal(err)
+	}
+	return pages
+}
+
+// pageHeader returns the start of a page blob holding count drawables.
+func pageHeader(count int) []byte {
+	out := binary.LittleEndian.AppendUint32(nil, SerializationVersion)
+	return binary.LittleEndian.AppendUint32(out, uint32(count))
+}
+
+func TestGoldenPages(t *testing.T) {
+	pages := loadGoldenPages(t)
+	if len(pages) != int(Dot8Image)+1 {
+		t.Fatalf("got %d golden pages, want one per draw mode", len(pages))
+	}
+	for i, page := range pages {
+		mode := DrawMode(i)
+		t.Run(page.name, func(t *testing.T) {
+			drawables, err := DecodePage(page.data)
+			if err != nil {
+				t.Fatalf("DecodePage() error = %v", err)
+			}
+			raws, err := SplitPage(page.data)
+			if err != nil {
+				t.Fatalf("SplitPage() error = %v", err)
+			}
+			want := page.data
+			if !mode.hasBody() {
+				// The clients drop these when reading, and so does the server.
+				want = pageHeader(0)
+			} else if len(drawables) != 1 || drawables[0].Mode != mode {
+				t.Fatalf("DecodePage() = %+v, want one drawable of mode %d", drawables, mode)
+			}
+			if got := EncodeDrawables(drawables); !bytes.Equal(got, want) {
+				t.Errorf("EncodeDrawables(DecodePage()) = %x, want %x", got, want)
+			}
+			if got := EncodePage(raws); !bytes.Equal(got, want) {
+				t.Errorf("EncodePage(SplitPage()) = %x, want %x", got, want)
+			}
+		})
+	}
+}
+
+// header returns the common header of a drawable of mode.
+func header(mode DrawMode) []byte {
+	d := &Drawable{Mode: mode, Color: Color{A: 1}, Thickness: 1}
+	return d.appendTo(nil)
+}
+
+// penPage returns a page holding one Pen drawable claiming points points.
+func penPage(points int) []byte {
+	out := append(pageHeader(1), header(Pen)...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(points))
+	return append(out, make([]byte, 8*points)...)
+}
+
+// textPage returns a page holding one TextTool drawable whose text has the
+// given 7-bit encoded length prefix followed by body.
+func textPage(prefix []byte, body string) []byte {
+	out := append(pageHeader(1), header(TextTool)...)
+	out = append(out, prefix...)
+	out = append(out, body...)
+	return append(out, make([]byte, 4*4)...)
+}
+
+func TestSplitPageLimits(t *testing.T) {
+	selects := func(count int) []byte {
+		out := pageHeader(count)
+		for range count {
+			out = append(out, header(Select)...)
+		}
+		return out
+	}
+	long := strings.Repeat("x", 300)
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"empty", nil, nil},
+		{"most drawables", selects(MaxDrawablesPerPage), nil},
+		{"too many drawables", pageHeader(MaxDrawablesPerPage + 1), ErrTooManyObjects},
+		{"negative count", pageHeader(-1), ErrTooManyObjects},
+		{"most points", penPage(MaxPointsPerObject), nil},
+		{"too many points", penPage(MaxPointsPerObject + 1), ErrTooManyPoints},
+		{"one-byte string length", textPage([]byte{5}, "hello"), nil},
+		{"two-byte string length", textPage([]byte{0xAC, 0x02}, long), nil},
+		{"string length too long", textPage([]byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x01}, ""), ErrStringTooLong},
+		{"string longer than page", textPage([]byte{0xAC, 0x02}, "short"), ErrTruncated},
+		{"wrong version", append([]byte{2, 0, 0, 0}, pageHeader(0)[4:]...), ErrVersion},
+		{"trailing data", append(pageHeader(0), 0), ErrTrailingData},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := SplitPage(tt.data); !errors.Is(err, tt.err) {
+				t.Errorf("SplitPage() error = %v, want %v", err, tt.err)
+			}
+			if _, err := DecodePage(tt.data); !errors.Is(err, tt.err) {
+				t.Errorf("DecodePage() error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+// addSeeds seeds f with the golden pages and pages at the parser's limits.
+func addSeeds(f *testing.F) {
+	for _, page := range loadGoldenPages(f) {
+		f.Add(page.data)
+	}
+	f.Add(pageHeader(MaxDrawablesPerPage + 1))
+	f.Add(penPage(MaxPointsPerObject))
+	f.Add(textPage([]byte{0xAC, 0x02}, strings.Repeat("x", 300)))
+	f.Add(textPage([]byte{0xFF, 0xFF, 0xFF, 0xFF, 0x0F}, ""))
+}
+
+func FuzzSplitPage(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		raws, err := SplitPage(data)
+		if err != nil {
+			return
+		}
+		if len(raws) > MaxDrawablesPerPage {
+			t.Fatalf("SplitPage() returned %d drawables", len(raws))
+		}
+		encoded := EncodePage(raws)
+		again, err := SplitPage(encoded)
+		if err != nil {
+			t.Fatalf("SplitPage(EncodePage()) error = %v", err)
+		}
+		if !bytes.Equal(EncodePage(again), encoded) {
+			t.Fatalf("EncodePage() is not stable")
+		}
+	})
+}
+
+func FuzzDecodePage(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		drawables, err := DecodePage(data)
+		if err != nil {
+			return
+		}
+		for _, d := range drawables {
+			if body, ok := d.Body.(*PathBody); ok && len(body.Points) > MaxPointsPerObject {
+				t.Fatalf("DecodePage() returned %d points", len(body.Points))
+			}
+		}
+		// Re-encoding normalizes what the clients would read differently,
+		// such as IsFilled bytes other than 0 and 1, and is stable after that.
+		encoded := EncodeDrawables(drawables)
+		again, err := DecodePage(encoded)
+		if err != nil {
+			t.Fatalf("DecodePage(EncodeDrawables()) error = %v", err)
+		}
+		if !bytes.Equal(EncodeDrawables(again), encoded) {
+			t.Fatalf("EncodeDrawables() is not stable")
+		}
+	})
+}
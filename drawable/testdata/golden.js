@@ -0,0 +1,54 @@
+// Writes pages.golden: one page per draw mode, serialized by the web
+// client's DrawableSerializer. Regenerate from the repository root with
+//
+//	node drawable/testdata/golden.js > drawable/testdata/pages.golden
+"use strict";
+const fs = require("fs");
+const path = require("path");
+const vm = require("vm");
+
+const root = path.join(__dirname, "..", "..", "public");
+const context = vm.createContext({ TextEncoder, TextDecoder, console });
+for (const file of ["drawinglogic/drawMode.js", "serialization/drawableSerializer.js"]) {
+    vm.runInContext(fs.readFileSync(path.join(root, file), "utf8"), context, { filename: file });
+}
+const { DrawMode, DrawableSerializer } = vm.runInContext("({ DrawMode, DrawableSerializer })", context);
+
+// Text long enough for a two-byte length prefix, with multi-byte characters.
+const longText = "Stack on ★ marker, then spread — ".repeat(6);
+
+function drawable(mode, index) {
+    const d = {
+        objectDrawMode: mode,
+        color: { r: 1, g: 0.5, b: 0.25, a: 0.75 },
+        thickness: 4,
+        isFilled: index % 2 === 0,
+        uniqueId: `00112233-4455-6677-8899-aabbccdd${index.toString(16).padStart(4, "0")}`,
+        points: [{ x: 10, y: 20 }, { x: 30.5, y: -40 }, { x: 50, y: 60.25 }],
+        startPoint: { x: 100, y: 150 },
+        endPoint: { x: 200.5, y: 250 },
+        rotation: 45,
+        arrowheadLengthOffset: 12,
+        arrowheadWidthScale: 1.5,
+        center: { x: 300, y: 320 },
+        radius: 64,
+        apex: { x: 400, y: 410 },
+        baseCenter: { x: 450, y: 480 },
+        dashLength: 8,
+        gapLength: 6,
+        vertices: [{ x: 1, y: 2 }, { x: 3, y: 4 }, { x: 5, y: 6 }],
+        text: longText,
+        position: { x: 512, y: 384 },
+        fontSize: 24,
+        wrappingWidth: 300,
+        pluginResourcePath: `PluginImages/svg/mode_${mode}.svg`,
+        width: 48,
+        height: 32,
+    };
+    return d;
+}
+
+for (const [name, mode] of Object.entries(DrawMode)) {
+    const page = DrawableSerializer.serializePageToBytes([drawable(mode, mode)]);
+    console.log(`${name} ${Buffer.from(page).toString("hex")}`);
+}
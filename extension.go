@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/binary"
+	"log/slog"
+	"time"
+
+	"github.com/rail2025/AetherDraw-Server/protocol"
+)
+
+const (
+	// maxExtensionStep is the most a single RoomExtension request adds to a room's lifetime.
+	maxExtensionStep = time.Hour
+	// minMembersToExtend is how many members, across all instances, must be
+	// in a room before it can be extended.
+	minMembersToExtend = 2
+)
+
+// deadlineAt returns when the room closes because of its lifetime.
+func (r *Room) deadlineAt() time.Time {
+	return time.Unix(0, r.deadline.Load())
+}
+
+// setDeadline moves the time the room closes because of its lifetime.
+func (r *Room) setDeadline(deadline time.Time) {
+	r.deadline.Store(deadline.UnixNano())
+}
+
+// secondsUntilDeadline is the time left until the room's deadline, rounded up.
+func (r *Room) secondsUntilDeadline() int32 {
+	remaining := time.Until(r.deadlineAt())
+	if remaining <= 0 {
+		return 0
+	}
+	return int32((remaining + time.Second - 1) / time.Second)
+}
+
+// handleExtension pushes back the room's deadline at the request of c. The
+// room never lives longer than maxRoomLifetime after it was created.
+// Only called from the room's loop.
+func (r *Room) handleExtension(c *Client, minutes int32) {
+	status := protocol.ExtensionGranted
+	if members, _ := r.countRoles(); c.role == roleSpectator {
+		status = protocol.ExtensionNotAllowed
+	} else if members < minMembersToExtend {
+		status = protocol.ExtensionNotEnoughClients
+	}
+	current := r.deadlineAt()
+	deadline := current.Add(min(time.Duration(minutes)*time.Minute, maxExtensionStep))
+	if limit := r.creationTime.Add(maxRoomLifetime); deadline.After(limit) {
+		deadline = limit
+	}
+	if status == protocol.ExtensionGranted && !deadline.After(current) {
+		status = protocol.ExtensionLimitReached
+	}
+	if status != protocol.ExtensionGranted {
+		slog.Info("Refused room extension", "room", roomLogID(r.name), "status", status)
+		c.enqueue(protocol.EncodeExtension(&protocol.Extension{Status: status, SecondsRemaining: r.secondsUntilDeadline()}))
+		return
+	}
+
+	r.setDeadline(deadline)
+	slog.Info("Extended room", "room", roomLogID(r.name), "deadline", deadline)
+	r.sendExtended(c)
+	r.publish(&envelope{kind: envelopeDeadline, body: binary.LittleEndian.AppendUint64(nil, uint64(deadline.UnixNano()))})
+}
+
+// sendExtended tells every client in the room that asked for notices about
+// its new deadline, which also calls off any lifetime closing countdown.
+// Only called from the room's loop.
+func (r *Room) sendExtended(requester *Client) {
+	frame := protocol.EncodeExtension(&protocol.Extension{Status: protocol.ExtensionGranted, SecondsRemaining: r.secondsUntilDeadline()})
+	for client := range r.clients {
+		// The requester gets its answer either way.
+		if client.notices || client == requester {
+			client.enqueue(frame)
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/rail2025/AetherDraw-Server/drawable"
+	"github.com/rail2025/AetherDraw-Server/protocol"
+)
+
+const (
+	// lockTimeout is how long an edit lock lasts unless its holder takes it again.
+	lockTimeout = 30 * time.Second
+	// maxLocksPerClient bounds how many drawables one client can lock at a time.
+	maxLocksPerClient = 256
+)
+
+// objectLock is an edit lock on a drawable.
+type objectLock struct {
+	// Client ID and display name of the holder.
+	holder  string
+	name    string
+	expires time.Time
+}
+
+// lockRequest is a client's request to take or release edit locks.
+type lockRequest struct {
+	locked bool
+	ids    []drawable.Guid
+}
+
+// newLockRequest converts the GUIDs of a decoded ObjectLock request.
+func newLockRequest(locked bool, ids [][16]byte) *lockRequest {
+	req := &lockRequest{locked: locked, ids: make([]drawable.Guid, len(ids))}
+	for i, id := range ids {
+		req.ids[i] = id
+	}
+	return req
+}
+
+// lockedBy returns the live lock on id, if someone other than author holds one.
+// Only called from the room's loop.
+func (r *Room) lockedBy(id drawable.Guid, author string) (objectLock, bool) {
+	lock, exists := r.locks[id]
+	if !exists || lock.holder == author || !time.Now().Before(lock.expires) {
+		return objectLock{}, false
+	}
+	return lock, true
+}
+
+// payloadLock returns a live lock held by someone other than author on a
+// drawable payload changes or deletes. Only UpdateObjects and DeleteObjects
+// respect locks.
+// Only called from the room's loop.
+func (r *Room) payloadLock(payload *protocol.NetworkPayload, author string) (objectLock, bool) {
+	if payload == nil || len(r.locks) == 0 {
+		return objectLock{}, false
+	}
+	var ids []drawable.Guid
+	switch payload.Action {
+	case protocol.UpdateObjects:
+		raws, err := drawable.SplitPage(payload.Data)
+		if err != nil {
+			return objectLock{}, false
+		}
+		for _, raw := range raws {
+			ids = append(ids, raw.ID)
+		}
+	case protocol.DeleteObjects:
+		ids, _ = drawable.DecodeGuidList(payload.Data)
+	}
+	for _, id := range ids {
+		if lock, locked := r.lockedBy(id, author); locked {
+			return lock, true
+		}
+	}
+	return objectLock{}, false
+}
+
+// rejectLocked drops a StateUpdate that touches drawables someone else has
+// locked, and puts the sender's copies of them back. It reports whether the
+// message was dropped.
+// Only called from the room's loop.
+func (r *Room) rejectLocked(message *Message) bool {
+	lock, locked := r.payloadLock(message.payload, message.source.id)
+	if !locked {
+		return false
+	}
+	slog.Debug("Dropped update to locked drawables", "room", roomLogID(r.name), "holder", lock.holder)
+	r.ack(message, 0, protocol.AckLocked)
+	if !r.policy.keepsBoard() {
+		return true
+	}
+	r.stateMux.RLock()
+	revert, _, ok := r.board.Inverse(message.payload)
+	r.stateMux.RUnlock()
+	if ok {
+		for _, payload := range revert {
+			message.source.enqueue(protocol.EncodeStateUpdate(payload))
+		}
+	}
+	return true
+}
+
+// handleLock takes or releases edit locks for c and tells the room. Locks on
+// drawables someone else holds are not granted; c is told who holds them instead.
+// Only called from the room's loop.
+func (r *Room) handleLock(c *Client, req *lockRequest) {
+	if c.role == roleSpectator {
+		return
+	}
+	if !req.locked {
+		if frame := r.releaseLocks(c.id, c.displayName, req.ids); frame != nil {
+			r.publish(&envelope{kind: envelopeLock, body: frame})
+		}
+		return
+	}
+	held := 0
+	for _, lock := range r.locks {
+		if lock.holder == c.id {
+			held++
+		}
+	}
+	expires := time.Now().Add(lockTimeout)
+	var granted []drawable.Guid
+	denied := make(map[string]*protocol.Lock)
+	for _, id := range req.ids {
+		if lock, locked := r.lockedBy(id, c.id); locked {
+			if denied[lock.holder] == nil {
+				denied[lock.holder] = &protocol.Lock{HolderID: lock.holder, HolderName: lock.name, Locked: true}
+			}
+			denied[lock.holder].IDs = append(denied[lock.holder].IDs, id)
+			continue
+		}
+		if lock, exists := r.locks[id]; !exists || lock.holder != c.id {
+			if held == maxLocksPerClient {
+				continue
+			}
+			held++
+		}
+		r.locks[id] = objectLock{holder: c.id, name: c.displayName, expires: expires}
+		granted = append(granted, id)
+	}
+	for _, lock := range denied {
+		c.enqueue(protocol.EncodeLock(lock))
+	}
+	if len(granted) > 0 {
+		frame := protocol.EncodeLock(&protocol.Lock{HolderID: c.id, HolderName: c.displayName, Locked: true, IDs: guidBytes(granted)})
+		r.sendLockFrame(frame)
+		r.publish(&envelope{kind: envelopeLock, body: frame})
+		r.scheduleLockExpiry()
+	}
+}
+
+// releaseLocks drops the locks holder has on ids, or on everything if ids is
+// nil, and tells the local clients. It returns the ObjectLock frame it sent,
+// or nil if holder had none of the locks.
+// Only called from the room's loop.
+func (r *Room) releaseLocks(holder, name string, ids []drawable.Guid) []byte {
+	var released []drawable.Guid
+	if ids == nil {
+		for id, lock := range r.locks {
+			if lock.holder == holder {
+				released = append(released, id)
+			}
+		}
+	} else {
+		for _, id := range ids {
+			if lock, exists := r.locks[id]; exists && lock.holder == holder {
+				released = append(released, id)
+			}
+		}
+	}
+	if len(released) == 0 {
+		return nil
+	}
+	for _, id := range released {
+		delete(r.locks, id)
+	}
+	frame := protocol.EncodeLock(&protocol.Lock{HolderID: holder, HolderName: name, IDs: guidBytes(released)})
+	r.sendLockFrame(frame)
+	r.scheduleLockExpiry()
+	return frame
+}
+
+// adoptLock applies a lock change published by another instance.
+// Only called from the room's loop.
+func (r *Room) adoptLock(frame []byte) {
+	var l *protocol.Lock
+	_, body, err := protocol.SplitFrame(frame)
+	if err == nil {
+		l, err = protocol.DecodeLock(body)
+	}
+	if err != nil {
+		slog.Warn("Dropping malformed lock from broker", "room", roomLogID(r.name), "error", err)
+		return
+	}
+	if !l.Locked {
+		ids := make([]drawable.Guid, len(l.IDs))
+		for i, id := range l.IDs {
+			ids[i] = id
+		}
+		r.releaseLocks(l.HolderID, l.HolderName, ids)
+		return
+	}
+	// The publishing instance already checked the request against its locks.
+	expires := time.Now().Add(lockTimeout)
+	for _, id := range l.IDs {
+		r.locks[id] = objectLock{holder: l.HolderID, name: l.HolderName, expires: expires}
+	}
+	r.sendLockFrame(frame)
+	r.scheduleLockExpiry()
+}
+
+// expireLocks drops the locks that ran out and tells the local clients.
+// Every instance expires its copy of a lock on its own.
+// Only called from the room's loop.
+func (r *Room) expireLocks() {
+	now := time.Now()
+	expired := make(map[string]*protocol.Lock)
+	for id, lock := range r.locks {
+		if now.Before(lock.expires) {
+			continue
+		}
+		delete(r.locks, id)
+		if expired[lock.holder] == nil {
+			expired[lock.holder] = &protocol.Lock{HolderID: lock.holder, HolderName: lock.name}
+		}
+		expired[lock.holder].IDs = append(expired[lock.holder].IDs, id)
+	}
+	for _, l := range expired {
+		slog.Debug("Edit locks expired", "room", roomLogID(r.name), "holder", l.HolderID, "count", len(l.IDs))
+		r.sendLockFrame(protocol.EncodeLock(l))
+	}
+	r.scheduleLockExpiry()
+}
+
+// scheduleLockExpiry arranges for the room's loop to expire locks when the
+// earliest one runs out, replacing any pending arrangement.
+// Only called from the room's loop.
+func (r *Room) scheduleLockExpiry() {
+	r.stopLockTimer()
+	var next time.Time
+	for _, lock := range r.locks {
+		if next.IsZero() || lock.expires.Before(next) {
+			next = lock.expires
+		}
+	}
+	if next.IsZero() {
+		return
+	}
+	r.lockTimer = time.AfterFunc(time.Until(next), func() {
+		select {
+		case r.lockExpiry <- struct{}{}:
+		default:
+			// An expiry is already pending.
+		}
+	})
+}
+
+// stopLockTimer cancels a scheduled lock expiry, if any.
+func (r *Room) stopLockTimer() {
+	if r.lockTimer != nil {
+		r.lockTimer.Stop()
+		r.lockTimer = nil
+	}
+}
+
+// sendLocks tells a client that just joined who holds which locks.
+// Only called from the room's loop.
+func (r *Room) sendLocks(c *Client) {
+	now := time.Now()
+	held := make(map[string]*protocol.Lock)
+	for id, lock := range r.locks {
+		if !now.Before(lock.expires) {
+			continue
+		}
+		if held[lock.holder] == nil {
+			held[lock.holder] = &protocol.Lock{HolderID: lock.holder, HolderName: lock.name, Locked: true}
+		}
+		held[lock.holder].IDs = append(held[lock.holder].IDs, id)
+	}
+	for _, l := range held {
+		c.enqueue(protocol.EncodeLock(l))
+	}
+}
+
+// sendLockFrame queues an ObjectLock frame for every client in the room that
+// asked for lock updates.
+func (r *Room) sendLockFrame(frame []byte) {
+	for client := range r.clients {
+		if client.lockUpdates {
+			client.enqueue(frame)
+		}
+	}
+}
+
+func guidBytes(ids []drawable.Guid) [][16]byte {
+	out := make([][16]byte, len(ids))
+	for i, id := range ids {
+		out[i] = id
+	}
+	return out
+}
@@ -11,6 +11,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -20,6 +21,9 @@ import (
 	"github.com/gorilla/websocket"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"golang.org/x/time/rate"
+
+	"github.com/rail2025/AetherDraw-Server/plan"
+	"github.com/rail2025/AetherDraw-Server/protocol"
 )
 
 var db *sql.DB
@@ -39,20 +43,34 @@ const (
 	pingPeriod = (pongWait * 9) / 10
 	// maxMessageSize is the maximum message size allowed from a peer.
 	maxMessageSize = 16 * 1024 // 16 KB
-	// historyCap is the maximum number of messages to store in a room's history.
-	historyCap = 5000
 	// maxUsersParty is the maximum number of users allowed in a party-based room.
 	maxUsersParty = 8
 	// maxUsersShared is the maximum number of users allowed in a passphrase-based room.
 	maxUsersShared = 48
 	// aetherBreakerMaxUsers is the maximum number of users in a 1v1 room.
 	aetherBreakerMaxUsers = 2
+	// maxSpectators is the maximum number of read-only spectators in a room.
+	// Spectators do not count against the user limits above.
+	maxSpectators = 100
 	// loneClientTimeout is the duration to wait before closing a room with only one client.
 	loneClientTimeout = 3 * time.Minute
 	// roomLifetime is the maximum duration a room can exist before being closed.
 	roomLifetime = 2 * time.Hour
+	// idleRoomTimeout is how long a room may go without anyone drawing or pointing before it is closed.
+	idleRoomTimeout = time.Hour
+	// closingNoticeLead is how long before a lifetime or idle closing clients start getting notices.
+	closingNoticeLead = 5 * time.Minute
 	// roomCheckInterval is the frequency at which to check for expired rooms.
-	roomCheckInterval = 5 * time.Minute
+	// It also paces the countdown notices sent during closingNoticeLead.
+	roomCheckInterval = time.Minute
+)
+
+// Client roles, selected with the "role" query parameter on /ws.
+const (
+	// roleMember clients can draw on the board.
+	roleMember = "member"
+	// roleSpectator clients only watch; their state updates are discarded.
+	roleSpectator = "spectator"
 )
 
 // warningMessage is the byte sequence sent to clients before the room is closed.
@@ -87,6 +105,9 @@ type UsageStats struct {
 	AetherDraw    atomic.Int64 `json:"aetherDraw"`
 	AetherBreaker atomic.Int64 `json:"aetherBreaker"`
 	BeastieBuddy  atomic.Int64 `json:"beastieBuddy"`
+	// Slow consumers disconnected, and frames dropped under the drop-oldest policy.
+	Evictions     atomic.Int64 `json:"evictions"`
+	DroppedFrames atomic.Int64 `json:"droppedFrames"`
 }
 
 var stats = &UsageStats{}
@@ -98,6 +119,30 @@ type Message struct {
 	room   string
 	data   []byte
 	source *Client // The client that sent the message
+	// The decoded STATE_UPDATE payload, set only for AetherDraw clients.
+	payload *protocol.NetworkPayload
+	// Ephemeral messages (pointer positions) are never stored and not echoed to the sender.
+	ephemeral bool
+	// Number of the frame on the sender's connection if it asked for acks, otherwise 0.
+	frameSeq int64
+	// Set for messages that only carry an ack for a frame dropped before reaching the hub.
+	ackOnly   bool
+	ackStatus protocol.AckStatus
+	// Minutes the sender asked to extend the room by, for RoomExtension requests.
+	extension int32
+	// Sequence number of the operation the sender asked to undo, for UndoOperation requests.
+	undo int64
+	// Set when the sender asked for the room's operation log.
+	opLog bool
+	// Locks the sender takes or releases, for ObjectLock requests.
+	lock *lockRequest
+	// Set for PageSubscription requests, with the pages the sender wants updates for.
+	subscribe    bool
+	subscription pageFilter
+	// Set for StateUpdates wrapped in BasedOn, with the room sequence number
+	// the update is based on.
+	versioned bool
+	baseSeq   int64
 }
 
 // Client is a middleman between the websocket connection and the hub.
@@ -111,38 +156,65 @@ type Client struct {
 	room string
 	// Type of client ("ad" or "ab").
 	clientType string
+	// Role of the client in the room (roleMember or roleSpectator).
+	role string
+	// Random ID identifying the client in presence frames.
+	id string
+	// ID of the join token the client connected with, if any.
+	tokenID string
+	// Optional display name shown to other room members.
+	displayName string
+	// The time the client joined the room.
+	joinedAt time.Time
 	// Rate limiter for this client.
 	limiter *rate.Limiter
+	// The room the hub routed the client to.
+	joinedRoom *Room
+	// Checkpoint to restore the room from if this client recreates it.
+	restore *roomCheckpoint
+	// Whether relayed frames are wrapped with their sequence number, see resume.go.
+	sequenced bool
+	// Session ID issued to a sequenced client.
+	session string
+	// Session and last seen sequence number the client asked to resume.
+	resumeSession string
+	resumeSeq     int64
+	// Whether the client wants Ack frames, and how many StateUpdate frames it
+	// has sent. frameSeq is only touched by readPump.
+	acks     bool
+	frameSeq int64
+	// Pages the client receives StateUpdates for, see pageFilter.
+	pages pageFilter
+	// Frame types the client opted into, which older clients do not know:
+	// Presence frames, ObjectLock frames, RoomClosing and unsolicited
+	// RoomExtension notices, and other clients' pointers.
+	presence    bool
+	lockUpdates bool
+	notices     bool
+	pointers    bool
+	// Set once the client has been disconnected for falling behind, see enqueue.
+	evicted atomic.Bool
+
+	// Pointer coalescing state, see handlePointer.
+	pointerMux      sync.Mutex
+	pendingPointer  []byte
+	lastPointerSent time.Time
+	pointerTimer    *time.Timer
 }
 
-// Room represents a single chat room.
-type Room struct {
-	// Registered clients.
-	clients map[*Client]bool
-	// In-memory message history for the room.
-	history [][]byte
-	// Mutex to protect access to the history slice.
-	historyMux sync.RWMutex
-	// Timer that triggers cleanup when only one client is left.
-	cleanupTimer *time.Timer
-	// The time the room was created.
-	creationTime time.Time
-}
-
-// Hub maintains the set of active rooms and broadcasts messages.
+// Hub maintains the set of active rooms and routes joining clients to them.
+// Everything else happens in the rooms' own loops, see Room.run.
 type Hub struct {
 	// Registered rooms.
 	rooms map[string]*Room
 	// Mutex to protect access to the rooms map.
 	roomsMux sync.RWMutex
-	// Inbound messages from the clients.
-	broadcast chan *Message
-	// Register requests from the clients.
-	register chan *Client
-	// Unregister requests from clients.
-	unregister chan *Client
-	// Room cleanup requests.
-	cleanupRoom chan string
+	// Set during graceful shutdown so closing rooms keep their checkpoints.
+	shuttingDown atomic.Bool
+	// Carries room traffic to and from other server instances.
+	broker Broker
+	// Identifies this instance in broker messages, so rooms ignore their own.
+	instanceID string
 }
 
 // upgrader upgrades HTTP connections to the WebSocket protocol.
@@ -167,21 +239,22 @@ type clientLimiter struct {
 
 var (
 	httpClients = make(map[string]*clientLimiter)
-	mu          sync.Mutex
+	// renderClients limits the plan render, export and share pages separately,
+	// since a shared plan is viewed far more often than the proxy is used.
+	renderClients = make(map[string]*clientLimiter)
+	mu            sync.Mutex
 )
 
-// getLimiter retrieves or creates a rate limiter for a given IP address.
-func getLimiter(ip string) *clientLimiter {
-	mu.Lock()
-	defer mu.Unlock()
-
-	limiter, exists := httpClients[ip]
+// getLimiter retrieves or creates a rate limiter for a given IP address in clients.
+// It must be called with mu held.
+func getLimiter(clients map[string]*clientLimiter, ip string) *clientLimiter {
+	limiter, exists := clients[ip]
 	if !exists {
 		limiter = &clientLimiter{
 			limiter:    rate.NewLimiter(2, 4), // 2 requests per second with a burst of 4
 			dailyReset: time.Now().Add(24 * time.Hour),
 		}
-		httpClients[ip] = limiter
+		clients[ip] = limiter
 	}
 
 	limiter.lastSeen = time.Now()
@@ -192,7 +265,8 @@ func getLimiter(ip string) *clientLimiter {
 func rateLimitMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ip := r.RemoteAddr
-		limiter := getLimiter(ip)
+		mu.Lock()
+		limiter := getLimiter(httpClients, ip)
 
 		// Reset the daily count if 24 hours have passed
 		if time.Now().After(limiter.dailyReset) {
@@ -202,21 +276,38 @@ func rateLimitMiddleware(next http.HandlerFunc) http.HandlerFunc {
 
 		// Check the daily cap (e.g., 200 requests per day)
 		if limiter.dailyCount >= 200 {
+			mu.Unlock()
 			http.Error(w, "Daily request limit exceeded", http.StatusTooManyRequests)
 			return
 		}
 
 		if !limiter.limiter.Allow() {
+			mu.Unlock()
 			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
 			return
 		}
 
 		limiter.dailyCount++
+		mu.Unlock()
+		next.ServeHTTP(w, r)
+	}
+}
+
+// renderLimitMiddleware applies rate limiting without a daily cap to an HTTP handler.
+func renderLimitMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		limiter := getLimiter(renderClients, r.RemoteAddr)
+		mu.Unlock()
+		if !limiter.limiter.Allow() {
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
 		next.ServeHTTP(w, r)
 	}
 }
 
-// cleanupLimiters periodically removes old entries from the httpClients map.
+// cleanupLimiters periodically removes old entries from the httpClients and renderClients maps.
 func cleanupLimiters() {
 	for {
 		time.Sleep(10 * time.Minute)
@@ -226,188 +317,190 @@ func cleanupLimiters() {
 				delete(httpClients, ip)
 			}
 		}
+		for ip, client := range renderClients {
+			if time.Since(client.lastSeen) > 15*time.Minute {
+				delete(renderClients, ip)
+			}
+		}
 		mu.Unlock()
 	}
 }
 
-// newHub creates a new Hub instance.
-func newHub() *Hub {
-	return &Hub{
-		broadcast:   make(chan *Message),
-		register:    make(chan *Client),
-		unregister:  make(chan *Client),
-		cleanupRoom: make(chan string),
-		rooms:       make(map[string]*Room),
+// newHub creates a new Hub instance that shares rooms with other instances through broker.
+func newHub(broker Broker) (*Hub, error) {
+	instanceID, err := generateShortID()
+	if err != nil {
+		return nil, err
 	}
+	return &Hub{
+		rooms:      make(map[string]*Room),
+		broker:     broker,
+		instanceID: instanceID,
+	}, nil
 }
 
-// run starts the Hub's message processing loop.
-func (h *Hub) run() {
+// join routes a client to its room, creating the room if it does not exist.
+// If the room closes before it accepts the client, a new room takes its place.
+func (h *Hub) join(client *Client) {
+	// Increment stats based on client type
+	if client.clientType == "ad" {
+		stats.AetherDraw.Add(1)
+	} else if client.clientType == "ab" {
+		stats.AetherBreaker.Add(1)
+	}
+	slog.Info("Client registered", "room", roomLogID(client.room), "clientType", client.clientType, "role", client.role)
+
 	for {
+		room := h.roomFor(client)
+		client.joinedRoom = room
 		select {
-		case client := <-h.register:
-			// Increment stats based on client type
-			if client.clientType == "ad" {
-				stats.AetherDraw.Add(1)
-			} else if client.clientType == "ab" {
-				stats.AetherBreaker.Add(1)
-			}
-			slog.Info("Client registered", "room", client.room, "clientType", client.clientType)
-
-			h.roomsMux.Lock()
-			room, ok := h.rooms[client.room]
-			if !ok {
-				room = &Room{
-					clients:      make(map[*Client]bool),
-					history:      make([][]byte, 0, historyCap),
-					creationTime: time.Now(),
-				}
-				h.rooms[client.room] = room
-				slog.Info("Created new room", "room", client.room)
-			}
-			room.clients[client] = true
-			if room.cleanupTimer != nil {
-				room.cleanupTimer.Stop()
-				room.cleanupTimer = nil
-				slog.Info("Stopped cleanup timer for room", "room", client.room)
-			}
-			if len(room.clients) == 1 {
-				slog.Info("First client in room, starting cleanup timer", "room", client.room, "timeout", loneClientTimeout)
-				room.cleanupTimer = time.AfterFunc(loneClientTimeout, func() {
-					h.cleanupRoom <- client.room
-				})
-			}
-			h.roomsMux.Unlock()
-
-			room.historyMux.RLock()
-			for _, msg := range room.history {
-				select {
-				case client.send <- msg:
-				default:
-					slog.Warn("Failed to send history message to client, send channel full", "room", client.room)
-				}
-			}
-			room.historyMux.RUnlock()
-			slog.Info("Client history sent", "room", client.room, "clients_in_room", len(room.clients))
-
-		case client := <-h.unregister:
-			h.roomsMux.Lock()
-			if room, ok := h.rooms[client.room]; ok {
-				if _, ok := room.clients[client]; ok {
-					delete(room.clients, client)
-					close(client.send)
-					slog.Info("Client unregistered", "room", client.room, "clients_in_room", len(room.clients))
-
-					if len(room.clients) == 0 {
-						if room.cleanupTimer != nil {
-							room.cleanupTimer.Stop()
-						}
-						delete(h.rooms, client.room)
-						slog.Info("Room is empty, deleting", "room", client.room)
-					} else if len(room.clients) == 1 {
-						slog.Info("Only one client left in room, starting cleanup timer", "room", client.room, "timeout", loneClientTimeout)
-						room.cleanupTimer = time.AfterFunc(loneClientTimeout, func() {
-							h.cleanupRoom <- client.room
-						})
-					}
-				}
-			}
-			h.roomsMux.Unlock()
-
-		case message := <-h.broadcast:
-			h.roomsMux.RLock()
-			if room, ok := h.rooms[message.room]; ok {
-				room.historyMux.Lock()
-				// If the client is an AetherDraw client, handle history with special logic.
-				if message.source.clientType == "ad" || message.source.clientType == "ad-web" {
-					// Check if this is the very first message for a new room.
-					if len(room.history) == 0 {
-						// The first message for a new room MUST be a ReplacePage action.
-						// The PayloadActionType is the 5th byte (index 4). ReplacePage is 4.
-						if len(message.data) > 5 && message.data[4] == 4 {
-							room.history = append(room.history, message.data)
-							slog.Info("Initial state set for room", "room", message.room)
-						} else {
-							// Ignore any other message type if the initial state is not set.
-							slog.Warn("Ignoring non-ReplacePage message for new room", "room", message.room)
-						}
-					} else {
-						// If history already exists, just add the new message.
-						room.history = append(room.history, message.data)
-						if len(room.history) > historyCap {
-							room.history = room.history[1:]
-						}
-					}
-				}
-				room.historyMux.Unlock()
-
-				// If the message is from an "ab" client, send only to the other player.
-				if message.source.clientType == "ab" {
-					for client := range room.clients {
-						if client != message.source {
-							select {
-							case client.send <- message.data:
-							default:
-								close(client.send)
-								delete(room.clients, client)
-							}
-						}
-					}
-				} else {
-					// Otherwise (for "ad" and "ad-web" clients), broadcast to everyone.
-					for client := range room.clients {
-						select {
-						case client.send <- message.data:
-						default:
-							close(client.send)
-							delete(room.clients, client)
-						}
-					}
-				}
-			}
-			h.roomsMux.RUnlock()
-
-		case roomName := <-h.cleanupRoom:
-			h.roomsMux.Lock()
-			if room, ok := h.rooms[roomName]; ok {
-				slog.Info("Sending closing warning to room", "room", roomName)
-				for client := range room.clients {
-					client.send <- warningMessage
-				}
+		case room.join <- client:
+			return
+		case <-room.done:
+		}
+	}
+}
 
-				time.Sleep(100 * time.Millisecond)
+// roomFor returns the client's room, creating it, or restoring it from the
+// client's checkpoint, if needed.
+func (h *Hub) roomFor(client *Client) *Room {
+	h.roomsMux.Lock()
+	defer h.roomsMux.Unlock()
+	if room, ok := h.rooms[client.room]; ok {
+		return room
+	}
+	room := h.openRoom(client.room, client.clientType, client.restore)
+	// A checkpoint is only used once.
+	client.restore = nil
+	return room
+}
 
-				for client := range room.clients {
-					close(client.send)
-				}
-				delete(h.rooms, roomName)
-				slog.Info("Closed room due to timeout", "room", roomName)
-			}
-			h.roomsMux.Unlock()
+// openRoom creates and registers a room, restoring it from a checkpoint if
+// restore is not nil. The caller must hold roomsMux for writing.
+func (h *Hub) openRoom(name, clientType string, restore *roomCheckpoint) *Room {
+	room := newRoom(h, name, defaultRoomPolicy(name, clientType), clientType)
+	if restore != nil {
+		if restore.board.Initialized() {
+			// The room may be live on another instance with a newer board;
+			// the checkpoint is only used if none arrives, see useCheckpoint.
+			room.checkpoint = restore.board
+		}
+		room.creationTime = restore.creationTime
+		room.clientType = restore.clientType
+		room.policy = restore.policy
+		room.access = restore.access
+		if room.access != nil && room.access.RecordingID != "" {
+			room.recorder = newRecorder(room.access.RecordingID)
 		}
+		room.setDeadline(room.creationTime.Add(room.policy.Lifetime))
+		slog.Info("Restoring room from checkpoint", "room", roomLogID(name), "pages", restore.board.PageCount())
+	}
+	h.addRoom(room)
+	slog.Info("Created new room", "room", roomLogID(name))
+	return room
+}
+
+// managedRoom returns the room created through /room/create with the given
+// ID, or nil if there is none. A room that is only open on other instances
+// is opened here from its access record, so that its manager can reach any
+// instance.
+func (h *Hub) managedRoom(roomID string) *Room {
+	h.roomsMux.RLock()
+	room, ok := h.rooms[roomID]
+	h.roomsMux.RUnlock()
+	if ok {
+		return room
+	}
+	restore := loadRoomAccess(roomID)
+	if restore == nil {
+		return nil
 	}
+	h.roomsMux.Lock()
+	defer h.roomsMux.Unlock()
+	if room, ok := h.rooms[roomID]; ok {
+		return room
+	}
+	return h.openRoom(roomID, restore.clientType, restore)
+}
+
+// addRoom registers a room and starts its loop. The caller must hold roomsMux.
+func (h *Hub) addRoom(room *Room) {
+	h.rooms[room.name] = room
+	go room.run()
+}
+
+// removeRoom unregisters a room whose loop is exiting and forgets its checkpoint.
+func (h *Hub) removeRoom(room *Room) {
+	h.roomsMux.Lock()
+	if h.rooms[room.name] == room {
+		delete(h.rooms, room.name)
+	}
+	h.roomsMux.Unlock()
+	h.forgetRoom(room.name)
+	close(room.done)
 }
 
-// cleanupExpiredRooms iterates through rooms and schedules them for cleanup if they are past their lifetime.
+// forgetRoom drops the checkpoint of a room that closed normally. During
+// shutdown rooms are kept so they can be restored after the restart.
+func (h *Hub) forgetRoom(roomName string) {
+	if h.shuttingDown.Load() {
+		return
+	}
+	go deleteRoomCheckpoint(roomName)
+}
+
+// cleanupExpiredRooms iterates through rooms and asks those past their
+// lifetime, or idle for too long, to close. Rooms that will close soon are
+// warned so their clients can save their work.
 func (h *Hub) cleanupExpiredRooms() {
 	h.roomsMux.RLock()
-	var expiredRooms []string
+	defer h.roomsMux.RUnlock()
+	now := time.Now()
 	for name, room := range h.rooms {
-		if time.Since(room.creationTime) > roomLifetime {
-			expiredRooms = append(expiredRooms, name)
+		reason, remaining := closeLifetime, room.deadlineAt().Sub(now)
+		if idle := room.idleSince().Add(idleRoomTimeout).Sub(now); idle < remaining {
+			reason, remaining = closeIdle, idle
+		}
+		switch {
+		case remaining <= 0:
+			slog.Info("Room has expired, scheduling for cleanup", "room", roomLogID(name), "reason", reason)
+			room.requestClose(reason)
+		case remaining <= closingNoticeLead:
+			room.warnClosing(reason, remaining)
 		}
 	}
-	h.roomsMux.RUnlock()
+}
 
-	for _, roomName := range expiredRooms {
-		slog.Info("Room has expired, scheduling for cleanup", "room", roomName, "lifetime", roomLifetime)
-		h.cleanupRoom <- roomName
+// closeRooms asks every room to close for server shutdown and waits until
+// they have, or until ctx is done.
+func (h *Hub) closeRooms(ctx context.Context) {
+	h.roomsMux.RLock()
+	rooms := make([]*Room, 0, len(h.rooms))
+	for _, room := range h.rooms {
+		rooms = append(rooms, room)
+	}
+	h.roomsMux.RUnlock()
+	for _, room := range rooms {
+		room.requestClose(closeShutdown)
+	}
+	for _, room := range rooms {
+		select {
+		case <-room.done:
+		case <-ctx.Done():
+			return
+		}
 	}
 }
 
+// isAetherDraw reports whether the client speaks the AetherDraw protocol ("ad" or "ad-web").
+func (c *Client) isAetherDraw() bool {
+	return c.clientType == "ad" || c.clientType == "ad-web"
+}
+
 func (c *Client) readPump() {
 	defer func() {
-		c.hub.unregister <- c
+		c.stopPointer()
+		c.leaveRoom()
 		c.conn.Close()
 	}()
 	c.conn.SetReadLimit(maxMessageSize)
@@ -415,12 +508,6 @@ func (c *Client) readPump() {
 	c.conn.SetPongHandler(func(string) error { c.conn.SetReadDeadline(time.Now().Add(pongWait)); return nil })
 
 	for {
-		// Check the rate limiter after reading a message.
-		if !c.limiter.Allow() {
-			slog.Warn("Rate limit exceeded, ignoring message", "room", c.room)
-			continue // Ignore the message and continue the loop.
-		}
-
 		_, msgData, err := c.conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
@@ -428,9 +515,64 @@ func (c *Client) readPump() {
 			}
 			break
 		}
+
+		// Pointer positions have their own coalescing and do not use the drawing budget.
+		if c.isAetherDraw() && len(msgData) > 0 && protocol.MessageType(msgData[0]) == protocol.PointerUpdate {
+			c.handlePointer(msgData[1:])
+			continue
+		}
+
+		// Versioned updates name the room sequence number they are based on.
+		var baseSeq int64
+		versioned := c.isAetherDraw() && len(msgData) > 0 && protocol.MessageType(msgData[0]) == protocol.BasedOn
+		if versioned {
+			baseSeq, msgData, err = protocol.DecodeBasedOn(msgData)
+			if err != nil {
+				slog.Warn("Dropping malformed versioned frame", "room", roomLogID(c.room), "error", err)
+				continue
+			}
+		}
+
+		isStateUpdate := len(msgData) > 0 && protocol.MessageType(msgData[0]) == protocol.StateUpdate
+		var frameSeq int64
+		if c.acks && isStateUpdate {
+			c.frameSeq++
+			frameSeq = c.frameSeq
+		}
+
+		// Check the rate limiter after reading a message.
+		if !c.limiter.Allow() {
+			slog.Warn("Rate limit exceeded, ignoring message", "room", roomLogID(c.room))
+			c.sendAck(frameSeq, protocol.AckRateLimited)
+			continue // Ignore the message and continue the loop.
+		}
+		// Requests for the room itself are answered by the room and never relayed.
+		if c.isAetherDraw() && isControlFrame(msgData) {
+			if message := c.controlMessage(msgData); message != nil {
+				c.deliver(message)
+			}
+			continue
+		}
+		// Spectators are read-only: their board updates never reach the room.
+		if c.role == roleSpectator && isStateUpdate {
+			slog.Debug("Discarding state update from spectator", "room", roomLogID(c.room))
+			c.sendAck(frameSeq, protocol.AckRejected)
+			continue
+		}
+
 		// Include the client 'c' as the source of the message.
-		message := &Message{room: c.room, data: msgData, source: c}
-		c.hub.broadcast <- message
+		message := &Message{room: c.room, data: msgData, source: c, frameSeq: frameSeq, versioned: versioned, baseSeq: baseSeq}
+		if c.isAetherDraw() {
+			// AetherDraw frames are parsed up front so malformed data is never relayed.
+			payload, err := protocol.DecodeStateUpdate(msgData)
+			if err != nil {
+				slog.Warn("Dropping malformed frame", "room", roomLogID(c.room), "clientType", c.clientType, "size", len(msgData), "error", err)
+				c.sendAck(frameSeq, protocol.AckMalformed)
+				continue
+			}
+			message.payload = payload
+		}
+		c.deliver(message)
 	}
 }
 
@@ -461,35 +603,148 @@ func (c *Client) writePump() {
 }
 
 func serveWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
-	passphrase := r.URL.Query().Get("passphrase")
-	if passphrase == "" {
-		http.Error(w, "Passphrase is required", http.StatusBadRequest)
-		return
-	}
+	query := r.URL.Query()
+	passphrase := query.Get("passphrase")
 	// Get the client type from the query parameters.
-	clientType := r.URL.Query().Get("client")
-
-	isPartyRoom := len(passphrase) == 64
-	maxUsers := maxUsersShared
-	if isPartyRoom {
-		maxUsers = maxUsersParty
-	}
-	// If the client is AetherBreaker, enforce the 2-player limit.
-	if clientType == "ab" {
-		maxUsers = aetherBreakerMaxUsers
+	clientType := query.Get("client")
+	displayName := query.Get("name")
+
+	role := roleMember
+	// Rooms created through /room/create are joined with a signed token that
+	// names the room, the role and optionally the display name.
+	var claims *joinClaims
+	var tokenID string
+	if token := query.Get("token"); token != "" {
+		var err error
+		claims, err = verifyJoinToken(token)
+		if err != nil {
+			http.Error(w, "Invalid join token", http.StatusUnauthorized)
+			slog.Warn("Rejected join token", "error", err)
+			return
+		}
+		if clientType != "ad" && clientType != "ad-web" {
+			http.Error(w, "Join tokens are only supported for AetherDraw rooms", http.StatusBadRequest)
+			return
+		}
+		passphrase = claims.Room
+		role = claims.Role
+		tokenID = claims.ID
+		if claims.Name != "" {
+			displayName = claims.Name
+		}
+	} else {
+		if passphrase == "" {
+			http.Error(w, "Passphrase is required", http.StatusBadRequest)
+			return
+		}
+		switch query.Get("role") {
+		case "", roleMember:
+		case roleSpectator:
+			// Spectating only makes sense for the shared AetherDraw board.
+			if clientType != "ad" && clientType != "ad-web" {
+				http.Error(w, "Spectating is only supported for AetherDraw rooms", http.StatusBadRequest)
+				return
+			}
+			role = roleSpectator
+		default:
+			http.Error(w, "Unknown role", http.StatusBadRequest)
+			return
+		}
 	}
 
 	hub.roomsMux.Lock()
-	if room, ok := hub.rooms[passphrase]; ok {
-		if len(room.clients) >= maxUsers {
+	// Rooms created through /room/create carry their own policy; otherwise the
+	// room kind is inferred from the passphrase and client type.
+	policy := defaultRoomPolicy(passphrase, clientType)
+	members, spectators := 0, 0
+	room, roomExists := hub.rooms[passphrase]
+	if roomExists {
+		if problem := checkRoomAccess(room.access, claims); problem != "" {
+			hub.roomsMux.Unlock()
+			http.Error(w, problem, http.StatusUnauthorized)
+			return
+		}
+		policy = room.policy
+		members, spectators = room.countRoles()
+	}
+	current, limit := members, policy.MaxUsers
+	if role == roleSpectator {
+		if policy.MaxSpectators == 0 {
 			hub.roomsMux.Unlock()
-			http.Error(w, "Room is full", http.StatusForbidden)
-			slog.Warn("Rejected connection to full room", "room", passphrase, "current", len(room.clients), "max", maxUsers, "clientType", clientType)
+			http.Error(w, "Spectators are not allowed in this room", http.StatusForbidden)
 			return
 		}
+		current, limit = spectators, policy.MaxSpectators
+	}
+	if current >= limit {
+		hub.roomsMux.Unlock()
+		http.Error(w, "Room is full", http.StatusForbidden)
+		slog.Warn("Rejected connection to full room", "room", roomLogID(passphrase), "current", current, "max", limit, "clientType", clientType, "role", role)
+		return
 	}
 	hub.roomsMux.Unlock()
 
+	// A room that is not live may have been checkpointed before a restart.
+	var restore *roomCheckpoint
+	if !roomExists && (clientType == "ad" || clientType == "ad-web") {
+		restore = loadRoomCheckpoint(passphrase)
+		if restore == nil && claims != nil {
+			// The room may be open on another instance, with nothing to restore yet.
+			restore = loadRoomAccess(passphrase)
+		}
+	}
+	if !roomExists {
+		var access *roomAccess
+		if restore != nil {
+			access = restore.access
+		}
+		if problem := checkRoomAccess(access, claims); problem != "" {
+			http.Error(w, problem, http.StatusUnauthorized)
+			return
+		}
+	}
+
+	clientID, err := generateShortID()
+	if err != nil {
+		slog.Error("Failed to generate client ID", "error", err)
+		http.Error(w, "Failed to join room", http.StatusInternalServerError)
+		return
+	}
+
+	// AetherDraw clients may opt into sequence numbers, which lets them resume
+	// a session with only the frames they missed after a reconnect.
+	var session, resumeSession string
+	var resumeSeq int64
+	sequenced := (clientType == "ad" || clientType == "ad-web") && (query.Get("sequenced") == "1" || query.Has("session"))
+	acks := (clientType == "ad" || clientType == "ad-web") && query.Get("acks") == "1"
+	// Likewise for the frames the server sends on its own. Clients that do not
+	// ask for them, like older plugin versions, never see their frame types.
+	aetherDraw := clientType == "ad" || clientType == "ad-web"
+	presence := aetherDraw && query.Get("presence") == "1"
+	lockUpdates := aetherDraw && query.Get("locks") == "1"
+	notices := aetherDraw && query.Get("notices") == "1"
+	pointers := aetherDraw && query.Get("pointers") == "1"
+	var pages pageFilter
+	if clientType == "ad" || clientType == "ad-web" {
+		if pages, err = parsePageFilter(query.Get("pages")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if sequenced {
+		if session, err = generateRoomID(); err != nil {
+			slog.Error("Failed to generate session ID", "error", err)
+			http.Error(w, "Failed to join room", http.StatusInternalServerError)
+			return
+		}
+		if resumeSession = query.Get("session"); resumeSession != "" {
+			if resumeSeq, err = strconv.ParseInt(query.Get("lastSeq"), 10, 64); err != nil {
+				http.Error(w, "lastSeq is required to resume a session", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		slog.Error("Failed to upgrade connection", "error", err)
@@ -499,14 +754,31 @@ func serveWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
 	limiter := rate.NewLimiter(rateLimit, burstSize)
 
 	client := &Client{
-		hub:        hub,
-		conn:       conn,
-		send:       make(chan []byte, 256),
-		room:       passphrase,
-		clientType: clientType, // Store the client type.
-		limiter:    limiter,
+		hub:         hub,
+		conn:        conn,
+		send:        make(chan []byte, clientQueueDepth),
+		room:        passphrase,
+		clientType:  clientType, // Store the client type.
+		role:        role,
+		id:          clientID,
+		displayName: sanitizeDisplayName(displayName),
+		tokenID:     tokenID,
+		joinedAt:    time.Now(),
+		limiter:     limiter,
+		restore:     restore,
+
+		sequenced:     sequenced,
+		session:       session,
+		resumeSession: resumeSession,
+		resumeSeq:     resumeSeq,
+		acks:          acks,
+		pages:         pages,
+		presence:      presence,
+		lockUpdates:   lockUpdates,
+		notices:       notices,
+		pointers:      pointers,
 	}
-	client.hub.register <- client
+	hub.join(client)
 
 	go client.writePump()
 	go client.readPump()
@@ -546,10 +818,14 @@ func handleStats(w http.ResponseWriter, r *http.Request) {
 		AetherDraw    int64 `json:"aetherDraw"`
 		AetherBreaker int64 `json:"aetherBreaker"`
 		BeastieBuddy  int64 `json:"beastieBuddy"`
+		Evictions     int64 `json:"evictions"`
+		DroppedFrames int64 `json:"droppedFrames"`
 	}{
 		AetherDraw:    stats.AetherDraw.Load(),
 		AetherBreaker: stats.AetherBreaker.Load(),
 		BeastieBuddy:  stats.BeastieBuddy.Load(),
+		Evictions:     stats.Evictions.Load(),
+		DroppedFrames: stats.DroppedFrames.Load(),
 	}
 	json.NewEncoder(w).Encode(data)
 }
@@ -689,7 +965,7 @@ func handleImageProxy(w http.ResponseWriter, r *http.Request) {
 	// Step 2: Validate the URL to prevent abuse.
 	// This is a crucial security step. We only allow proxying from approved domains.
 	allowedDomains := []string{
-		"https://i.imgur.com/", 
+		"https://i.imgur.com/",
 		"https://i.ibb.co/",
 		"https://live.staticflickr.com/",
 		"https://i.postimg.cc/",
@@ -751,6 +1027,13 @@ func handlePlanSave(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Could not read plan data", http.StatusBadRequest)
 		return
 	}
+	// Only store actual plans the clients can load again.
+	p, err := plan.Decode(planData)
+	if err != nil {
+		slog.Warn("Rejected invalid plan", "size", len(planData), "error", err)
+		http.Error(w, "Invalid plan: "+err.Error(), http.StatusBadRequest)
+		return
+	}
 	uniqueID, err := generateShortID()
 	if err != nil {
 		slog.Error("Failed to generate short ID", "error", err)
@@ -765,7 +1048,7 @@ func handlePlanSave(w http.ResponseWriter, r *http.Request) {
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"id": uniqueID})
-	slog.Info("Successfully saved plan", "id", uniqueID)
+	slog.Info("Successfully saved plan", "id", uniqueID, "pages", len(p.Pages), "appVersion", p.AppVersion)
 }
 
 // HTTP handler for loading a plan
@@ -775,8 +1058,7 @@ func handlePlanLoad(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Plan ID is required", http.StatusBadRequest)
 		return
 	}
-	var planData []byte
-	err := db.QueryRow("SELECT data FROM plans WHERE id = $1", id).Scan(&planData)
+	planData, err := loadPlanData(r.Context(), id)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			http.Error(w, "Plan not found", http.StatusNotFound)
@@ -823,7 +1105,43 @@ func main() {
 		slog.Error("Failed to create plans table", "error", err)
 		os.Exit(1)
 	}
-	slog.Info("Successfully connected to the database and ensured table exists.")
+	if _, err := db.Exec(createRoomsTableSQL); err != nil {
+		slog.Error("Failed to create room_checkpoints table", "error", err)
+		os.Exit(1)
+	}
+	if _, err := db.Exec(keyRoomCheckpointsSQL); err != nil {
+		slog.Error("Failed to migrate room_checkpoints table", "error", err)
+		os.Exit(1)
+	}
+	if _, err := db.Exec(addRoomPolicyColumnSQL); err != nil {
+		slog.Error("Failed to migrate room_checkpoints table", "error", err)
+		os.Exit(1)
+	}
+	if _, err := db.Exec(addRoomAccessColumnSQL); err != nil {
+		slog.Error("Failed to migrate room_checkpoints table", "error", err)
+		os.Exit(1)
+	}
+	if _, err := db.Exec(createRecordingsTableSQL); err != nil {
+		slog.Error("Failed to create room_recording_chunks table", "error", err)
+		os.Exit(1)
+	}
+	if _, err := db.Exec(createRecordingsIndexSQL); err != nil {
+		slog.Error("Failed to index room_recording_chunks table", "error", err)
+		os.Exit(1)
+	}
+	if _, err := db.Exec(createServerSecretsTableSQL); err != nil {
+		slog.Error("Failed to create server_secrets table", "error", err)
+		os.Exit(1)
+	}
+	if _, err := db.Exec(createRoomAccessTableSQL); err != nil {
+		slog.Error("Failed to create room_access table", "error", err)
+		os.Exit(1)
+	}
+	slog.Info("Successfully connected to the database and ensured tables exist.")
+	roomRestoreWindow = loadRoomRestoreWindow()
+	publicOrigin = loadPublicOrigin()
+	joinTokenSecret = loadJoinTokenSecret()
+	clientQueueDepth, slowConsumerPolicy = loadSlowConsumerConfig()
 
 	// Load and process mob data.
 	loadAndTransformMobData()
@@ -831,9 +1149,18 @@ func main() {
 	// Start the limiter cleanup goroutine
 	go cleanupLimiters()
 
-	// Create and run the central hub in a separate goroutine.
-	hub := newHub()
-	go hub.run()
+	// Create the central hub. Rooms are shared with other instances through the broker.
+	broker, err := loadBroker()
+	if err != nil {
+		slog.Error("Failed to connect to broker", "error", err)
+		os.Exit(1)
+	}
+	defer broker.Close()
+	hub, err := newHub(broker)
+	if err != nil {
+		slog.Error("Failed to create hub", "error", err)
+		os.Exit(1)
+	}
 
 	// Start a goroutine for periodically cleaning up old rooms.
 	go func() {
@@ -844,6 +1171,24 @@ func main() {
 		}
 	}()
 
+	// Start a goroutine for periodically checkpointing live rooms.
+	go func() {
+		ticker := time.NewTicker(roomCheckpointInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			hub.checkpointRooms()
+		}
+	}()
+
+	// Start a goroutine for periodically saving room recordings.
+	go func() {
+		ticker := time.NewTicker(recordingFlushInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			hub.flushRecordings()
+		}
+	}()
+
 	// Goroutine to ping itself to prevent the Render free tier from sleeping.
 	go func() {
 		// Wait a moment for the server to start before the first ping.
@@ -890,10 +1235,26 @@ func main() {
 	})
 	mux.HandleFunc("/beastiebuddy/search", rateLimitMiddleware(handleBeastieBuddySearch))
 	mux.HandleFunc("/stats", handleStats)
+	mux.HandleFunc("/room/create", rateLimitMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		handleRoomCreate(hub, w, r)
+	}))
+	mux.HandleFunc("/room/invite", rateLimitMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		handleRoomInvite(hub, w, r)
+	}))
+	mux.HandleFunc("/room/revoke", rateLimitMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		handleRoomRevoke(hub, w, r)
+	}))
+	mux.HandleFunc("/room/close", rateLimitMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		handleRoomClose(hub, w, r)
+	}))
+	mux.HandleFunc("/room/replay/", rateLimitMiddleware(handleRoomReplay))
 
 	// Register the new handlers for saving and loading plans
 	mux.HandleFunc("/plan/save", handlePlanSave)
 	mux.HandleFunc("/plan/load/", handlePlanLoad) // The trailing slash is important here
+	mux.HandleFunc("/plan/render/", renderLimitMiddleware(handlePlanRender))
+	mux.HandleFunc("/plan/export/", renderLimitMiddleware(handlePlanExport))
+	mux.HandleFunc("/p/", renderLimitMiddleware(handlePlanShare))
 	mux.HandleFunc("/proxy-image", handleImageProxy)
 
 	server := &http.Server{
@@ -923,12 +1284,19 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
+	// Checkpoint live rooms so they can be restored once the new instance is up.
+	hub.shuttingDown.Store(true)
+	hub.checkpointRooms()
+
 	// Attempt to gracefully shut down the server.
 	if err := server.Shutdown(ctx); err != nil {
 		slog.Error("Server forced to shutdown", "error", err)
 		os.Exit(1)
 	}
 
+	// WebSocket connections outlive server.Shutdown; tell their rooms why they
+	// are closing before the process exits.
+	hub.closeRooms(ctx)
+
 	slog.Info("Server gracefully stopped")
 }
-
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"log/slog"
+
+	"github.com/rail2025/AetherDraw-Server/drawable"
+	"github.com/rail2025/AetherDraw-Server/protocol"
+)
+
+// opLogSize is how many operations a room keeps in its log.
+const opLogSize = 256
+
+// operation is a state update recorded in a room's operation log.
+type operation struct {
+	// Room sequence number the update was relayed with.
+	seq        int64
+	authorID   string
+	authorName string
+	action     protocol.PayloadActionType
+	page       int32
+	ids        []drawable.Guid
+	// Payloads that undo the update, nil if it cannot be undone.
+	undo   []*protocol.NetworkPayload
+	undone bool
+	// removedPage is set for a DeletePage that actually removed a page.
+	removedPage bool
+}
+
+// logOperation appends op, relayed with seq, to the room's log.
+// Only called from the room's loop.
+func (r *Room) logOperation(op *operation, seq int64, authorID, authorName string) {
+	if op == nil {
+		return
+	}
+	op.seq, op.authorID, op.authorName = seq, authorID, authorName
+	r.recordVersions(op)
+	if op.removedPage {
+		r.shiftOperations(op.page)
+	}
+	if len(r.ops) == opLogSize {
+		copy(r.ops, r.ops[1:])
+		r.ops = r.ops[:opLogSize-1]
+	}
+	r.ops = append(r.ops, op)
+}
+
+// shiftOperations follows the removal of a page: logged operations on later
+// pages move down by one, and those that would touch the removed page can no
+// longer be undone.
+func (r *Room) shiftOperations(removed int32) {
+	for _, op := range r.ops {
+		if op.page > removed {
+			op.page--
+		}
+		for _, payload := range op.undo {
+			if payload.PageIndex == removed {
+				op.undo = nil
+				break
+			}
+		}
+		for _, payload := range op.undo {
+			if payload.PageIndex > removed {
+				payload.PageIndex--
+			}
+		}
+	}
+}
+
+// findOperation returns the logged operation relayed with seq, or nil.
+func (r *Room) findOperation(seq int64) *operation {
+	for _, op := range r.ops {
+		if op.seq == seq {
+			return op
+		}
+	}
+	return nil
+}
+
+// sendOperationLog answers c's request for the room's operation log.
+// Only called from the room's loop.
+func (r *Room) sendOperationLog(c *Client) {
+	ops := make([]*protocol.Operation, len(r.ops))
+	for i, op := range r.ops {
+		ids := make([][16]byte, len(op.ids))
+		for j, id := range op.ids {
+			ids[j] = id
+		}
+		ops[i] = &protocol.Operation{
+			Seq:        op.seq,
+			AuthorID:   op.authorID,
+			AuthorName: op.authorName,
+			Action:     op.action,
+			PageIndex:  op.page,
+			IDs:        ids,
+			Undoable:   op.undo != nil,
+			Undone:     op.undone,
+		}
+	}
+	c.enqueue(protocol.EncodeOperationLog(ops))
+}
+
+// handleUndo relays the inverse of the logged operation seq to the whole
+// room on behalf of c and tells c how it went. The inverse is logged like any
+// other update, so undoing it again redoes the original.
+// Only called from the room's loop.
+func (r *Room) handleUndo(c *Client, seq int64) {
+	status := r.undo(c, seq)
+	slog.Info("Handled undo request", "room", roomLogID(r.name), "operation", seq, "status", status)
+	c.enqueue(protocol.EncodeUndoResult(seq, status))
+}
+
+func (r *Room) undo(c *Client, seq int64) protocol.UndoStatus {
+	if c.role == roleSpectator {
+		return protocol.UndoNotAllowed
+	}
+	op := r.findOperation(seq)
+	switch {
+	case op == nil:
+		return protocol.UndoUnknownOperation
+	case op.undone:
+		return protocol.UndoAlreadyUndone
+	case op.undo == nil:
+		return protocol.UndoNotUndoable
+	}
+	for _, payload := range op.undo {
+		if _, locked := r.payloadLock(payload, c.id); locked {
+			return protocol.UndoLocked
+		}
+	}
+	r.touch()
+	undo := op.undo
+	// An inverse of several payloads is relayed all or nothing.
+	if !r.undoApplies(undo) {
+		return protocol.UndoFailed
+	}
+	for _, payload := range undo {
+		redo, _, ok := r.apply(payload)
+		if !ok {
+			return protocol.UndoFailed
+		}
+		// Mark the operation before logging the inverse, which may shift pages.
+		op.undone = true
+		frame := protocol.EncodeStateUpdate(payload)
+		r.logOperation(redo, r.fanOut(frame, redo, nil, c.clientType), c.id, c.displayName)
+		r.recorder.add(frame)
+		r.publish(&envelope{kind: envelopeFrame, sourceType: c.clientType, author: c.id, body: frame})
+	}
+	return protocol.UndoApplied
+}
+
+// undoApplies reports whether every payload of an inverse applies to the
+// board, trying them in order on a copy of it. Only called from the room's loop.
+func (r *Room) undoApplies(undo []*protocol.NetworkPayload) bool {
+	if !r.policy.keepsBoard() {
+		return true
+	}
+	r.stateMux.RLock()
+	trial := r.board.Clone()
+	r.stateMux.RUnlock()
+	for _, payload := range undo {
+		if err := trial.Apply(payload); err != nil {
+			return false
+		}
+	}
+	return true
+}
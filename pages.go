@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/rail2025/AetherDraw-Server/protocol"
+)
+
+// pageFilter is the set of pages a client receives StateUpdates for, so
+// people looking at different pages of a big plan do not pay for each
+// other's edits. Page structure changes reach everyone. A nil filter
+// receives every page. Once the client has joined, only the room's loop
+// touches its filter.
+type pageFilter map[int32]bool
+
+// newPageFilter returns the filter for a list of pages. An empty list means every page.
+func newPageFilter(pages []int32) pageFilter {
+	if len(pages) == 0 {
+		return nil
+	}
+	f := make(pageFilter, len(pages))
+	for _, page := range pages {
+		f[page] = true
+	}
+	return f
+}
+
+// parsePageFilter parses the pages query parameter, a comma-separated list of page indices.
+func parsePageFilter(s string) (pageFilter, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var pages []int32
+	for _, field := range strings.Split(s, ",") {
+		page, err := strconv.ParseInt(strings.TrimSpace(field), 10, 32)
+		if err != nil || page < 0 {
+			return nil, fmt.Errorf("invalid page index %q", field)
+		}
+		pages = append(pages, int32(page))
+	}
+	return newPageFilter(pages), nil
+}
+
+// has reports whether f receives updates for page.
+func (f pageFilter) has(page int32) bool {
+	return f == nil || f[page]
+}
+
+// payload returns what a client with filter f receives for p: p itself, a
+// stand-in that only keeps the page structure in step, or nil.
+func (f pageFilter) payload(p *protocol.NetworkPayload) *protocol.NetworkPayload {
+	if f.has(p.PageIndex) {
+		return p
+	}
+	return standIn(p)
+}
+
+// standIn returns what a client that does not follow p's page receives for
+// it: p itself for page structure changes, an AddNewPage for a ReplacePage,
+// or nil.
+func standIn(p *protocol.NetworkPayload) *protocol.NetworkPayload {
+	switch p.Action {
+	case protocol.AddNewPage, protocol.DeletePage:
+		return p
+	case protocol.ReplacePage:
+		// The clients create any missing pages up to a replaced one.
+		return &protocol.NetworkPayload{PageIndex: p.PageIndex, Action: protocol.AddNewPage}
+	}
+	return nil
+}
+
+// relay is a frame relayed by a room, decoded once for all the clients that
+// follow only some pages.
+type relay struct {
+	frame []byte
+	// Set for StateUpdates, the only frames page filters apply to.
+	filtered bool
+	page     int32
+	// What clients that do not follow page receive, nil for nothing.
+	standIn []byte
+	// Set if the frame is a DeletePage that removed page from the board.
+	removedPage bool
+}
+
+// newRelay decodes a relayed frame. removedPage says whether the room's
+// board removed a page for it.
+func newRelay(frame []byte, removedPage bool) *relay {
+	rel := &relay{frame: frame}
+	p, err := protocol.DecodeStateUpdate(frame)
+	if err != nil {
+		return rel
+	}
+	rel.filtered, rel.page = true, p.PageIndex
+	rel.removedPage = removedPage && p.Action == protocol.DeletePage
+	switch stand := standIn(p); stand {
+	case nil:
+	case p:
+		rel.standIn = frame
+	default:
+		rel.standIn = protocol.EncodeStateUpdate(stand)
+	}
+	return rel
+}
+
+// sequenced returns rel with its frames wrapped in a Sequenced frame.
+func (rel *relay) sequenced(seq int64) *relay {
+	s := *rel
+	s.frame = protocol.EncodeSequenced(seq, rel.frame)
+	if rel.standIn != nil {
+		s.standIn = protocol.EncodeSequenced(seq, rel.standIn)
+	}
+	return &s
+}
+
+// relayed returns what a client with filter f receives of rel, nil for nothing.
+func (f pageFilter) relayed(rel *relay) []byte {
+	if !rel.filtered || f.has(rel.page) {
+		return rel.frame
+	}
+	return rel.standIn
+}
+
+// follow keeps f on the same pages after rel removed a page. A client
+// subscribed to the removed page receives only structure changes until it
+// subscribes again. A DeletePage the board refused, such as one for the
+// only page, changes nothing.
+func (f pageFilter) follow(rel *relay) {
+	if f == nil || !rel.removedPage {
+		return
+	}
+	pages := make([]int32, 0, len(f))
+	for page := range f {
+		pages = append(pages, page)
+	}
+	clear(f)
+	for _, page := range pages {
+		switch {
+		case page < rel.page:
+			f[page] = true
+		case page > rel.page:
+			f[page-1] = true
+		}
+	}
+}
+
+// handleSubscription replaces the pages c receives updates for, and sends it
+// the current state of the pages it did not follow before.
+// Only called from the room's loop.
+func (r *Room) handleSubscription(c *Client, pages pageFilter) {
+	previous := c.pages
+	c.pages = pages
+	slog.Debug("Client changed page subscription", "room", roomLogID(r.name), "pages", len(pages))
+	if !r.policy.keepsBoard() {
+		return
+	}
+	r.stateMux.RLock()
+	defer r.stateMux.RUnlock()
+	for index := 0; index < r.board.PageCount(); index++ {
+		if previous.has(int32(index)) || !pages.has(int32(index)) {
+			continue
+		}
+		if p, ok := r.board.PageState(index); ok {
+			c.enqueue(protocol.EncodeStateUpdate(p))
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"maps"
+	"testing"
+
+	"github.com/rail2025/AetherDraw-Server/protocol"
+)
+
+func TestPageFilterRelayed(t *testing.T) {
+	f := newPageFilter([]int32{0, 2})
+	followed := protocol.EncodeStateUpdate(&protocol.NetworkPayload{PageIndex: 2, Action: protocol.UpdateObjects, Data: []byte{1}})
+	skipped := protocol.EncodeStateUpdate(&protocol.NetworkPayload{PageIndex: 1, Action: protocol.UpdateObjects, Data: []byte{1}})
+	newPage := protocol.EncodeStateUpdate(&protocol.NetworkPayload{PageIndex: 3, Action: protocol.AddNewPage})
+	replaced := protocol.EncodeStateUpdate(&protocol.NetworkPayload{PageIndex: 1, Action: protocol.ReplacePage, Data: []byte{1}})
+	pointer := []byte{byte(protocol.PointerUpdate), 1, 2}
+	tests := []struct {
+		name  string
+		frame []byte
+		want  []byte
+	}{
+		{"followed page", followed, followed},
+		{"other page", skipped, nil},
+		{"new page", newPage, newPage},
+		{"replaced page", replaced, protocol.EncodeStateUpdate(&protocol.NetworkPayload{PageIndex: 1, Action: protocol.AddNewPage})},
+		{"not a StateUpdate", pointer, pointer},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := f.relayed(newRelay(tt.frame, false)); !bytes.Equal(got, tt.want) {
+				t.Errorf("relayed() = %x, want %x", got, tt.want)
+			}
+			sequenced := newRelay(tt.frame, false).sequenced(7)
+			want := tt.want
+			if want != nil {
+				want = protocol.EncodeSequenced(7, want)
+			}
+			if got := f.relayed(sequenced); !bytes.Equal(got, want) {
+				t.Errorf("relayed(sequenced) = %x, want %x", got, want)
+			}
+		})
+	}
+}
+
+func TestPageFilterFollow(t *testing.T) {
+	deletePage := protocol.EncodeStateUpdate(&protocol.NetworkPayload{PageIndex: 1, Action: protocol.DeletePage})
+	tests := []struct {
+		name    string
+		frame   []byte
+		removed bool
+		want    pageFilter
+	}{
+		{"page removed", deletePage, true, newPageFilter([]int32{0, 1})},
+		{"delete refused", deletePage, false, newPageFilter([]int32{0, 1, 2})},
+		{"not a DeletePage", protocol.EncodeStateUpdate(&protocol.NetworkPayload{PageIndex: 1, Action: protocol.ClearPage}), true, newPageFilter([]int32{0, 1, 2})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newPageFilter([]int32{0, 1, 2})
+			f.follow(newRelay(tt.frame, tt.removed))
+			if !maps.Equal(f, tt.want) {
+				t.Errorf("after follow, filter = %v, want %v", f, tt.want)
+			}
+		})
+	}
+}
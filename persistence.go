@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/rail2025/AetherDraw-Server/board"
+)
+
+const (
+	// roomCheckpointInterval is how often live rooms are saved to the database.
+	roomCheckpointInterval = 1 * time.Minute
+	// defaultRoomRestoreWindow is how long after its last checkpoint a room can be restored.
+	// Override with the ROOM_RESTORE_WINDOW environment variable (e.g. "45m").
+	defaultRoomRestoreWindow = 30 * time.Minute
+	// checkpointQueryTimeout bounds every checkpoint database call.
+	checkpointQueryTimeout = 5 * time.Second
+)
+
+// roomRestoreWindow is the configured restore window, set in main.
+var roomRestoreWindow = defaultRoomRestoreWindow
+
+const createRoomsTableSQL = `CREATE TABLE IF NOT EXISTS room_checkpoints (
+	room_key TEXT PRIMARY KEY,
+	client_type TEXT NOT NULL,
+	state BYTEA NOT NULL,
+	room_created_at TIMESTAMPTZ NOT NULL,
+	saved_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);`
+
+// keyRoomCheckpointsSQL migrates tables that stored rooms under their
+// passphrase to checkpointKey. The old rows are dropped rather than kept in
+// plaintext; at worst rooms live during the upgrade are not restored.
+const keyRoomCheckpointsSQL = `DO $$ BEGIN
+	IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'room_checkpoints' AND column_name = 'passphrase') THEN
+		DELETE FROM room_checkpoints;
+		ALTER TABLE room_checkpoints RENAME COLUMN passphrase TO room_key;
+	END IF;
+END $$`
+
+// addRoomPolicyColumnSQL adds the room policy, stored as the JSON accepted by /room/create.
+const addRoomPolicyColumnSQL = `ALTER TABLE room_checkpoints ADD COLUMN IF NOT EXISTS policy TEXT NOT NULL DEFAULT ''`
+
+// addRoomAccessColumnSQL adds the manage key hash and revoked tokens of rooms created through /room/create.
+const addRoomAccessColumnSQL = `ALTER TABLE room_checkpoints ADD COLUMN IF NOT EXISTS access TEXT NOT NULL DEFAULT ''`
+
+// createRoomAccessTableSQL holds the access records of rooms created through
+// /room/create from the moment they are created, so that every instance
+// behind the broker admits their tokens, not just the one that created them.
+const createRoomAccessTableSQL = `CREATE TABLE IF NOT EXISTS room_access (
+	room_key TEXT PRIMARY KEY,
+	access TEXT NOT NULL,
+	policy TEXT NOT NULL,
+	room_created_at TIMESTAMPTZ NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL
+);`
+
+// roomCheckpoint is a room as stored in the database.
+type roomCheckpoint struct {
+	clientType   string
+	creationTime time.Time
+	policy       roomPolicy
+	access       *roomAccess
+	board        *board.Board
+}
+
+// loadRoomRestoreWindow reads ROOM_RESTORE_WINDOW, falling back to the default.
+func loadRoomRestoreWindow() time.Duration {
+	value := os.Getenv("ROOM_RESTORE_WINDOW")
+	if value == "" {
+		return defaultRoomRestoreWindow
+	}
+	window, err := time.ParseDuration(value)
+	if err != nil || window < 0 {
+		slog.Warn("Invalid ROOM_RESTORE_WINDOW, using default", "value", value, "default", defaultRoomRestoreWindow)
+		return defaultRoomRestoreWindow
+	}
+	return window
+}
+
+// checkpointKey is the key a room's checkpoint is stored under. Passphrases
+// double as secrets, so only an HMAC of them reaches the database.
+func checkpointKey(passphrase string) string {
+	mac := hmac.New(sha256.New, joinTokenSecret)
+	mac.Write([]byte("room-checkpoint:" + passphrase))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// loadRoomCheckpoint fetches a restorable checkpoint for the given room, or nil if there is none.
+func loadRoomCheckpoint(passphrase string) *roomCheckpoint {
+	if db == nil || roomRestoreWindow == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), checkpointQueryTimeout)
+	defer cancel()
+
+	var (
+		clientType string
+		state      []byte
+		createdAt  time.Time
+		savedAt    time.Time
+		policyJSON string
+		accessJSON string
+	)
+	err := db.QueryRowContext(ctx,
+		"SELECT client_type, state, room_created_at, saved_at, policy, access FROM room_checkpoints WHERE room_key = $1",
+		checkpointKey(passphrase)).Scan(&clientType, &state, &createdAt, &savedAt, &policyJSON, &accessJSON)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			slog.Error("Failed to load room checkpoint", "room", roomLogID(passphrase), "error", err)
+		}
+		return nil
+	}
+	policy := decodeRoomPolicy(passphrase, clientType, policyJSON)
+	if time.Since(savedAt) > roomRestoreWindow || time.Since(createdAt) > policy.Lifetime {
+		slog.Info("Ignoring stale room checkpoint", "room", roomLogID(passphrase), "savedAt", savedAt)
+		return nil
+	}
+	var access *roomAccess
+	if accessJSON != "" {
+		access = &roomAccess{}
+		if err := json.Unmarshal([]byte(accessJSON), access); err != nil {
+			// Without its manage key the room cannot be restored safely.
+			slog.Error("Failed to decode room access", "room", roomLogID(passphrase), "error", err)
+			return nil
+		}
+	}
+
+	b := board.New()
+	if err := b.UnmarshalBinary(state); err != nil {
+		slog.Error("Failed to decode room checkpoint", "room", roomLogID(passphrase), "error", err)
+		return nil
+	}
+	return &roomCheckpoint{clientType: clientType, creationTime: createdAt, policy: policy, access: access, board: b}
+}
+
+// decodeRoomPolicy decodes a policy stored as the JSON accepted by
+// /room/create, falling back to the default policy of the room.
+func decodeRoomPolicy(passphrase, clientType, policyJSON string) roomPolicy {
+	if policyJSON != "" {
+		var req createRoomRequest
+		if err := json.Unmarshal([]byte(policyJSON), &req); err == nil {
+			if policy, problem := req.policy(); problem == "" {
+				return policy
+			}
+		}
+	}
+	return defaultRoomPolicy(passphrase, clientType)
+}
+
+// loadRoomAccess fetches the access record of a room created through
+// /room/create, for opening the room on an instance it is not live on. The
+// result has an empty board, which the room fills from the other instances.
+// It returns nil if there is no such room.
+func loadRoomAccess(roomID string) *roomCheckpoint {
+	if db == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), checkpointQueryTimeout)
+	defer cancel()
+
+	var (
+		accessJSON string
+		policyJSON string
+		createdAt  time.Time
+	)
+	err := db.QueryRowContext(ctx,
+		"SELECT access, policy, room_created_at FROM room_access WHERE room_key = $1 AND expires_at > NOW()",
+		checkpointKey(roomID)).Scan(&accessJSON, &policyJSON, &createdAt)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			slog.Error("Failed to load room access", "room", roomLogID(roomID), "error", err)
+		}
+		return nil
+	}
+	access := &roomAccess{}
+	if err := json.Unmarshal([]byte(accessJSON), access); err != nil {
+		slog.Error("Failed to decode room access", "room", roomLogID(roomID), "error", err)
+		return nil
+	}
+	return &roomCheckpoint{
+		clientType:   "ad",
+		creationTime: createdAt,
+		policy:       decodeRoomPolicy(roomID, "ad", policyJSON),
+		access:       access,
+		board:        board.New(),
+	}
+}
+
+// saveRoomAccess upserts the access record of a room created through
+// /room/create. It is kept until the room could no longer be open anywhere.
+func saveRoomAccess(roomID string, created time.Time, policy roomPolicy, access string) {
+	if db == nil {
+		return
+	}
+	policyJSON, err := json.Marshal(policy.request())
+	if err != nil {
+		slog.Error("Failed to encode room policy", "room", roomLogID(roomID), "error", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), checkpointQueryTimeout)
+	defer cancel()
+	_, err = db.ExecContext(ctx, `INSERT INTO room_access (room_key, access, policy, room_created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (room_key) DO UPDATE SET access = $2, policy = $3`,
+		checkpointKey(roomID), access, string(policyJSON), created, created.Add(unclaimedRoomTimeout+maxRoomLifetime))
+	if err != nil {
+		slog.Error("Failed to save room access", "room", roomLogID(roomID), "error", err)
+	}
+}
+
+// deleteRoomAccess removes the access record of a room closed by its creator.
+func deleteRoomAccess(roomID string) {
+	if db == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), checkpointQueryTimeout)
+	defer cancel()
+	if _, err := db.ExecContext(ctx, "DELETE FROM room_access WHERE room_key = $1", checkpointKey(roomID)); err != nil {
+		slog.Error("Failed to delete room access", "room", roomLogID(roomID), "error", err)
+	}
+}
+
+// deleteRoomCheckpoint removes a room's checkpoint once the room has closed normally.
+func deleteRoomCheckpoint(passphrase string) {
+	if db == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), checkpointQueryTimeout)
+	defer cancel()
+	if _, err := db.ExecContext(ctx, "DELETE FROM room_checkpoints WHERE room_key = $1", checkpointKey(passphrase)); err != nil {
+		slog.Error("Failed to delete room checkpoint", "room", roomLogID(passphrase), "error", err)
+	}
+}
+
+// saveRoomCheckpoint upserts the checkpoint for a single room.
+func saveRoomCheckpoint(passphrase, clientType string, created time.Time, policy roomPolicy, access string, state []byte) {
+	policyJSON, err := json.Marshal(policy.request())
+	if err != nil {
+		slog.Error("Failed to encode room policy", "room", roomLogID(passphrase), "error", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), checkpointQueryTimeout)
+	defer cancel()
+	_, err = db.ExecContext(ctx, `INSERT INTO room_checkpoints (room_key, client_type, state, room_created_at, saved_at, policy, access)
+		VALUES ($1, $2, $3, $4, NOW(), $5, $6)
+		ON CONFLICT (room_key) DO UPDATE SET client_type = $2, state = $3, room_created_at = $4, saved_at = NOW(), policy = $5, access = $6`,
+		checkpointKey(passphrase), clientType, state, created, string(policyJSON), access)
+	if err != nil {
+		slog.Error("Failed to checkpoint room", "room", roomLogID(passphrase), "error", err)
+	}
+}
+
+// checkpointRooms saves every live AetherDraw room and prunes checkpoints
+// that can no longer be restored. Rooms created through /room/create are
+// saved before anyone draws in them, so the tokens issued for them keep
+// working after a restart.
+func (h *Hub) checkpointRooms() {
+	if db == nil {
+		return
+	}
+	type pending struct {
+		name       string
+		clientType string
+		created    time.Time
+		policy     roomPolicy
+		access     string
+		state      []byte
+	}
+	var rooms []pending
+
+	h.roomsMux.RLock()
+	for name, room := range h.rooms {
+		room.stateMux.RLock()
+		// A room still deciding between its checkpoint and another
+		// instance's board leaves the checkpoint as it is.
+		if room.checkpoint == nil && (room.board.Initialized() || room.access != nil) {
+			state, err := room.board.MarshalBinary()
+			if state == nil {
+				// An empty board; the column does not take NULL.
+				state = []byte{}
+			}
+			if err == nil {
+				// Keep any extension the clients asked for.
+				policy := room.policy
+				policy.Lifetime = room.deadlineAt().Sub(room.creationTime)
+				rooms = append(rooms, pending{name, room.clientType, room.creationTime, policy, room.access.marshal(), state})
+			}
+		}
+		room.stateMux.RUnlock()
+	}
+	h.roomsMux.RUnlock()
+
+	for _, r := range rooms {
+		saveRoomCheckpoint(r.name, r.clientType, r.created, r.policy, r.access, r.state)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), checkpointQueryTimeout)
+	defer cancel()
+	if _, err := db.ExecContext(ctx, "DELETE FROM room_checkpoints WHERE saved_at < $1", time.Now().Add(-roomRestoreWindow)); err != nil {
+		slog.Error("Failed to prune room checkpoints", "error", err)
+	}
+	if _, err := db.ExecContext(ctx, "DELETE FROM room_access WHERE expires_at < NOW()"); err != nil {
+		slog.Error("Failed to prune room access records", "error", err)
+	}
+	if len(rooms) > 0 {
+		slog.Info("Checkpointed rooms", "count", len(rooms))
+	}
+}
@@ -0,0 +1,191 @@
+// Package plan reads and writes AetherDraw plan files, the format written by
+// PlanSerializer (planSerializer.js in the web client, PlanSerializer.cs in
+// the plugin). A plan is laid out as:
+//
+//	["ADPN"][formatVersion int32][appMajor uint16][appMinor uint16][appPatch uint16]
+//	[name string][pageCount int32]
+//
+// followed by, per page,
+//
+//	[name string][dataLength int32][data]
+//
+// where data is a page blob as understood by the drawable package. Integers
+// are little-endian and strings carry a 7-bit encoded length prefix, as
+// written by .NET's BinaryWriter.
+package plan
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	// Signature opens every plan file.
+	Signature = "ADPN"
+	// FormatVersion is the newest plan format the clients write and read.
+	FormatVersion = 1
+	// MaxPages mirrors the page count check in PlanSerializer.deserializePlanFromBytes.
+	MaxPages = 1000
+)
+
+// minPlanSize mirrors the length check in PlanSerializer.deserializePlanFromBytes.
+const minPlanSize = 16
+
+// Errors returned by Decode.
+var (
+	ErrSignature     = errors.New("plan: not an AetherDraw plan")
+	ErrVersion       = errors.New("plan: unsupported plan format version")
+	ErrTooManyPages  = errors.New("plan: page count out of range")
+	ErrTruncated     = errors.New("plan: data truncated")
+	ErrStringTooLong = errors.New("plan: string length out of range")
+)
+
+// AppVersion is the version of the application that wrote a plan.
+type AppVersion struct {
+	Major, Minor, Patch uint16
+}
+
+func (v AppVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// Page is a page of a plan. Drawables is the page blob, kept as written.
+type Page struct {
+	Name      string
+	Drawables []byte
+}
+
+// Plan is a decoded plan file.
+type Plan struct {
+	FormatVersion int32
+	AppVersion    AppVersion
+	Name          string
+	Pages         []Page
+}
+
+// Decode parses a plan file. Page blobs alias data. It is as tolerant as
+// PlanSerializer.deserializePlanFromBytes, so every plan the clients open is
+// accepted: format versions up to FormatVersion, including ones below 1, are
+// read as the current format, and bytes after the last page are ignored.
+func Decode(data []byte) (*Plan, error) {
+	if len(data) < minPlanSize {
+		return nil, ErrTruncated
+	}
+	if string(data[:len(Signature)]) != Signature {
+		return nil, ErrSignature
+	}
+	r := &reader{buf: data, off: len(Signature)}
+	p := &Plan{FormatVersion: r.int32()}
+	if p.FormatVersion > FormatVersion {
+		return nil, fmt.Errorf("%w: %d", ErrVersion, p.FormatVersion)
+	}
+	p.AppVersion = AppVersion{r.uint16(), r.uint16(), r.uint16()}
+	p.Name = r.string()
+	count := r.int32()
+	if r.err != nil {
+		return nil, r.err
+	}
+	if count < 0 || count > MaxPages {
+		return nil, fmt.Errorf("%w: %d", ErrTooManyPages, count)
+	}
+	p.Pages = make([]Page, 0, count)
+	for i := int32(0); i < count && r.err == nil; i++ {
+		name := r.string()
+		p.Pages = append(p.Pages, Page{Name: name, Drawables: r.take(int(r.int32()))})
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return p, nil
+}
+
+// Encode returns p as a plan file.
+func (p *Plan) Encode() []byte {
+	size := len(Signature) + 4 + 3*2 + 5 + len(p.Name) + 4
+	for _, page := range p.Pages {
+		size += 5 + len(page.Name) + 4 + len(page.Drawables)
+	}
+	out := make([]byte, 0, size)
+	out = append(out, Signature...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(p.FormatVersion))
+	out = binary.LittleEndian.AppendUint16(out, p.AppVersion.Major)
+	out = binary.LittleEndian.AppendUint16(out, p.AppVersion.Minor)
+	out = binary.LittleEndian.AppendUint16(out, p.AppVersion.Patch)
+	out = appendString(out, p.Name)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(p.Pages)))
+	for _, page := range p.Pages {
+		out = appendString(out, page.Name)
+		out = binary.LittleEndian.AppendUint32(out, uint32(len(page.Drawables)))
+		out = append(out, page.Drawables...)
+	}
+	return out
+}
+
+// reader walks a plan file. The first failure sticks.
+type reader struct {
+	buf []byte
+	off int
+	err error
+}
+
+func (r *reader) take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || len(r.buf)-r.off < n {
+		r.err = ErrTruncated
+		return nil
+	}
+	b := r.buf[r.off : r.off+n]
+	r.off += n
+	return b
+}
+
+func (r *reader) uint16() uint16 {
+	if b := r.take(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *reader) int32() int32 {
+	if b := r.take(4); b != nil {
+		return int32(binary.LittleEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (r *reader) string() string {
+	length, shift := 0, 0
+	for {
+		b := r.take(1)
+		if b == nil {
+			return ""
+		}
+		length |= int(b[0]&0x7F) << shift
+		if b[0]&0x80 == 0 {
+			break
+		}
+		shift += 7
+		if shift >= 35 {
+			r.err = ErrStringTooLong
+			return ""
+		}
+	}
+	if length > len(r.buf)-r.off {
+		r.err = ErrStringTooLong
+		return ""
+	}
+	return string(r.take(length))
+}
+
+func appendString(dst []byte, s string) []byte {
+	n := uint32(len(s))
+	for n >= 0x80 {
+		dst = append(dst, byte(n)|0x80)
+		n >>= 7
+	}
+	dst = append(dst, byte(n))
+	return append(dst, s...)
+}
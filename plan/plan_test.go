@@ -0,0 +1,66 @@
+package plan
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	valid := (&Plan{
+		FormatVersion: FormatVersion,
+		AppVersion:    AppVersion{1, 2, 3},
+		Name:          "Raid plan",
+		Pages:         []Page{{Name: "Phase 1", Drawables: []byte{1, 2, 3}}, {Name: "Phase 2"}},
+	}).Encode()
+	withVersion := func(version int32) []byte {
+		data := bytes.Clone(valid)
+		binary.LittleEndian.PutUint32(data[len(Signature):], uint32(version))
+		return data
+	}
+	// withPageCount returns a plan without pages that claims count of them.
+	withPageCount := func(count int32) []byte {
+		data := (&Plan{FormatVersion: FormatVersion, Name: "Raid plan"}).Encode()
+		binary.LittleEndian.PutUint32(data[len(data)-4:], uint32(count))
+		return data
+	}
+	tests := []struct {
+		name  string
+		data  []byte
+		err   error
+		pages int
+	}{
+		{"current version", valid, nil, 2},
+		// The clients read anything up to their own version.
+		{"version 0", withVersion(0), nil, 2},
+		{"negative version", withVersion(-1), nil, 2},
+		{"trailing data", append(bytes.Clone(valid), 0xFF, 0xFF), nil, 2},
+		{"newer version", withVersion(FormatVersion + 1), ErrVersion, 0},
+		{"short", valid[:minPlanSize-1], ErrTruncated, 0},
+		{"truncated page", valid[:len(valid)-2], ErrTruncated, 0},
+		{"bad signature", append([]byte("ADPX"), valid[len(Signature):]...), ErrSignature, 0},
+		{"most pages", (&Plan{FormatVersion: FormatVersion, Pages: make([]Page, MaxPages)}).Encode(), nil, MaxPages},
+		{"too many pages", withPageCount(MaxPages + 1), ErrTooManyPages, 0},
+		{"negative page count", withPageCount(-1), ErrTooManyPages, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Decode(tt.data)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Decode() error = %v, want %v", err, tt.err)
+			}
+			if err == nil && len(p.Pages) != tt.pages {
+				t.Errorf("Decode() has %d pages, want %d", len(p.Pages), tt.pages)
+			}
+		})
+	}
+
+	p, err := Decode(valid)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if !bytes.Equal(p.Encode(), valid) {
+		t.Errorf("Encode() does not round-trip")
+	}
+}
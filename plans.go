@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"image/png"
+	"log/slog"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rail2025/AetherDraw-Server/drawable"
+	"github.com/rail2025/AetherDraw-Server/plan"
+	"github.com/rail2025/AetherDraw-Server/render"
+)
+
+// maxPlanRenderCacheBytes bounds the memory held by rendered plan pages.
+const maxPlanRenderCacheBytes = 64 << 20
+
+// planIcons are the icons of the web client, used to render image drawables.
+var planIcons = render.NewIconSet(os.DirFS("public/icons"))
+
+// planRenders caches rendered plan pages. Saved plans never change, so
+// entries stay valid until they are evicted.
+var planRenders = newRenderCache(maxPlanRenderCacheBytes)
+
+// planRenderWidths are the image widths plan pages are rendered at. Requested
+// widths are rounded up to one of them, so few renders of a page are cached.
+var planRenderWidths = []int{200, 400, render.DefaultWidth, 1200, render.MaxWidth}
+
+// planRenderSlots bounds how many plan pages are rendered at once.
+var planRenderSlots = make(chan struct{}, runtime.GOMAXPROCS(0))
+
+// renderWidth returns the smallest of planRenderWidths at least width wide.
+func renderWidth(width int) int {
+	for _, bucket := range planRenderWidths {
+		if width <= bucket {
+			return bucket
+		}
+	}
+	return render.MaxWidth
+}
+
+// renderKey identifies a rendered plan page.
+type renderKey struct {
+	planID string
+	page   int
+	width  int
+}
+
+// renderCache holds encoded images up to a total size, evicting the oldest first.
+type renderCache struct {
+	mu      sync.Mutex
+	maxSize int
+	size    int
+	entries map[renderKey][]byte
+	order   []renderKey
+}
+
+func newRenderCache(maxSize int) *renderCache {
+	return &renderCache{maxSize: maxSize, entries: make(map[renderKey][]byte)}
+}
+
+func (c *renderCache) get(key renderKey) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, ok := c.entries[key]
+	return data, ok
+}
+
+func (c *renderCache) put(key renderKey, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, exists := c.entries[key]; exists || len(data) > c.maxSize {
+		return
+	}
+	for c.size+len(data) > c.maxSize {
+		oldest := c.order[0]
+		c.order = c.order[1:]
+		c.size -= len(c.entries[oldest])
+		delete(c.entries, oldest)
+	}
+	c.entries[key] = data
+	c.order = append(c.order, key)
+	c.size += len(data)
+}
+
+// loadPlanData returns a saved plan file, or sql.ErrNoRows if there is none.
+func loadPlanData(ctx context.Context, id string) ([]byte, error) {
+	var planData []byte
+	err := db.QueryRowContext(ctx, "SELECT data FROM plans WHERE id = $1", id).Scan(&planData)
+	return planData, err
+}
+
+// handlePlanRender serves /plan/render/{id}.png, a PNG of one page of a saved
+// plan. The page query parameter selects the page (default 0) and width the
+// image width in pixels (default render.DefaultWidth), rounded up to one of
+// planRenderWidths.
+func handlePlanRender(w http.ResponseWriter, r *http.Request) {
+	id, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/plan/render/"), ".png")
+	if !ok || id == "" || strings.Contains(id, "/") || db == nil {
+		http.Error(w, "Plan not found", http.StatusNotFound)
+		return
+	}
+	query := r.URL.Query()
+	page := 0
+	if value := query.Get("page"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			http.Error(w, "page must be a page index", http.StatusBadRequest)
+			return
+		}
+		page = parsed
+	}
+	width := render.DefaultWidth
+	if value := query.Get("width"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < render.MinWidth || parsed > render.MaxWidth {
+			http.Error(w, "width must be between "+strconv.Itoa(render.MinWidth)+" and "+strconv.Itoa(render.MaxWidth), http.StatusBadRequest)
+			return
+		}
+		width = renderWidth(parsed)
+	}
+
+	key := renderKey{planID: id, page: page, width: width}
+	rendered, cached := planRenders.get(key)
+	if !cached {
+		if rendered = renderPlanPage(r.Context(), w, key); rendered == nil {
+			return
+		}
+		planRenders.put(key, rendered)
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write(rendered)
+	slog.Info("Served plan render", "id", id, "page", page, "width", width, "cached", cached)
+}
+
+// renderPlanPage loads and renders a plan page as a PNG. On failure it
+// answers the request itself and returns nil.
+func renderPlanPage(ctx context.Context, w http.ResponseWriter, key renderKey) []byte {
+	p := loadPlan(ctx, w, key.planID)
+	if p == nil {
+		return nil
+	}
+	if key.page >= len(p.Pages) {
+		http.Error(w, "Page not found", http.StatusNotFound)
+		return nil
+	}
+	drawables, ok := decodePlanPage(w, key.planID, p, key.page)
+	if !ok {
+		return nil
+	}
+	select {
+	case planRenderSlots <- struct{}{}:
+		defer func() { <-planRenderSlots }()
+	case <-ctx.Done():
+		http.Error(w, "Plan render canceled", http.StatusServiceUnavailable)
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, render.Page(drawables, key.width, planIcons)); err != nil {
+		slog.Error("Failed to encode plan render", "id", key.planID, "error", err)
+		http.Error(w, "Failed to render plan", http.StatusInternalServerError)
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// handlePlanExport serves /plan/export/{id}.svg, a saved plan as SVG: the
+// page selected by the page query parameter, or all pages stacked.
+func handlePlanExport(w http.ResponseWriter, r *http.Request) {
+	id, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/plan/export/"), ".svg")
+	if !ok || id == "" || strings.Contains(id, "/") || db == nil {
+		http.Error(w, "Plan not found", http.StatusNotFound)
+		return
+	}
+	first, last := 0, -1
+	if value := r.URL.Query().Get("page"); value != "" {
+		page, err := strconv.Atoi(value)
+		if err != nil || page < 0 {
+			http.Error(w, "page must be a page index", http.StatusBadRequest)
+			return
+		}
+		first, last = page, page
+	}
+
+	p := loadPlan(r.Context(), w, id)
+	if p == nil {
+		return
+	}
+	if last < 0 {
+		last = len(p.Pages) - 1
+	} else if last >= len(p.Pages) {
+		http.Error(w, "Page not found", http.StatusNotFound)
+		return
+	}
+	var pages []render.SVGPage
+	for index := first; index <= last; index++ {
+		drawables, ok := decodePlanPage(w, id, p, index)
+		if !ok {
+			return
+		}
+		pages = append(pages, render.SVGPage{Name: p.Pages[index].Name, Drawables: drawables})
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	// The document is only ever an image; keep anything embedded in it inert.
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; img-src data: http: https:")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write(render.SVG(pages, planIcons))
+	slog.Info("Served plan export", "id", id, "pages", len(pages))
+}
+
+// loadPlan loads and decodes a saved plan. On failure it answers the request
+// itself and returns nil.
+func loadPlan(ctx context.Context, w http.ResponseWriter, id string) *plan.Plan {
+	_, p := loadPlanFile(ctx, w, id)
+	return p
+}
+
+// loadPlanFile loads a saved plan file and decodes it. On failure it answers
+// the request itself and returns a nil plan.
+func loadPlanFile(ctx context.Context, w http.ResponseWriter, id string) ([]byte, *plan.Plan) {
+	planData, err := loadPlanData(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Plan not found", http.StatusNotFound)
+		return nil, nil
+	}
+	if err != nil {
+		slog.Error("Failed to load plan from database", "id", id, "error", err)
+		http.Error(w, "Failed to load plan", http.StatusInternalServerError)
+		return nil, nil
+	}
+	p, err := plan.Decode(planData)
+	if err != nil {
+		// Plans saved before save-time validation may not decode.
+		slog.Warn("Cannot read invalid plan", "id", id, "error", err)
+		http.Error(w, "Plan cannot be read", http.StatusUnprocessableEntity)
+		return nil, nil
+	}
+	return planData, p
+}
+
+// decodePlanPage decodes the drawables of a plan page. On failure it answers
+// the request itself.
+func decodePlanPage(w http.ResponseWriter, id string, p *plan.Plan, index int) ([]*drawable.Drawable, bool) {
+	drawables, err := drawable.DecodePage(p.Pages[index].Drawables)
+	if err != nil {
+		slog.Warn("Cannot read invalid plan page", "id", id, "page", index, "error", err)
+		http.Error(w, "Plan cannot be read", http.StatusUnprocessableEntity)
+		return nil, false
+	}
+	return drawables, true
+}
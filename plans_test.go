@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rail2025/AetherDraw-Server/plan"
+	"github.com/rail2025/AetherDraw-Server/render"
+)
+
+func TestRenderWidth(t *testing.T) {
+	tests := []struct {
+		width, want int
+	}{
+		{render.MinWidth, 200},
+		{1, 200},
+		{200, 200},
+		{201, 400},
+		{401, render.DefaultWidth},
+		{render.DefaultWidth, render.DefaultWidth},
+		{1201, render.MaxWidth},
+		{render.MaxWidth, render.MaxWidth},
+	}
+	for _, tt := range tests {
+		if got := renderWidth(tt.width); got != tt.want {
+			t.Errorf("renderWidth(%d) = %d, want %d", tt.width, got, tt.want)
+		}
+	}
+}
+
+func TestRenderCache(t *testing.T) {
+	c := newRenderCache(10)
+	key := func(page int) renderKey { return renderKey{planID: "plan", page: page, width: render.DefaultWidth} }
+	c.put(key(0), make([]byte, 4))
+	c.put(key(1), make([]byte, 4))
+	// Putting a key again neither replaces it nor counts it twice.
+	c.put(key(1), make([]byte, 2))
+	if got, _ := c.get(key(1)); len(got) != 4 || c.size != 8 {
+		t.Fatalf("after putting a key twice: entry of %d bytes, size %d, want 4 and 8", len(got), c.size)
+	}
+	// The oldest entry makes room for a new one.
+	c.put(key(2), make([]byte, 4))
+	if _, ok := c.get(key(0)); ok {
+		t.Errorf("oldest entry kept past the size limit")
+	}
+	for _, page := range []int{1, 2} {
+		if _, ok := c.get(key(page)); !ok {
+			t.Errorf("entry for page %d evicted, want it kept", page)
+		}
+	}
+	if c.size > c.maxSize {
+		t.Errorf("size = %d, want at most %d", c.size, c.maxSize)
+	}
+	// An entry larger than the cache is not stored and evicts nothing.
+	c.put(key(3), make([]byte, 11))
+	if _, ok := c.get(key(3)); ok || c.size != 8 || len(c.entries) != 2 {
+		t.Errorf("after an oversized entry: stored %v, size %d, %d entries, want not stored, 8 and 2", ok, c.size, len(c.entries))
+	}
+}
+
+func TestPlanSaveRejectsPageCounts(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	for _, count := range []int32{plan.MaxPages + 1, -1} {
+		data := (&plan.Plan{FormatVersion: plan.FormatVersion, Name: "Raid plan"}).Encode()
+		binary.LittleEndian.PutUint32(data[len(data)-4:], uint32(count))
+		w := httptest.NewRecorder()
+		handlePlanSave(w, httptest.NewRequest(http.MethodPost, "/plan/save", bytes.NewReader(data)))
+		// Rejected before the database is reached; there is none in tests.
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "page count") {
+			t.Errorf("saving a plan with %d pages: %d %q, want 400 naming the page count", count, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/rail2025/AetherDraw-Server/protocol"
+)
+
+// pointerInterval is the minimum time between two pointer frames relayed for
+// the same client. Faster updates are coalesced and only the latest is sent.
+const pointerInterval = 50 * time.Millisecond
+
+// handlePointer validates a pointer frame body and queues it for relaying.
+// Pointer frames bypass the client's rate limiter and never touch the board.
+func (c *Client) handlePointer(body []byte) {
+	if c.role == roleSpectator {
+		return
+	}
+	pointer, err := protocol.DecodePointerRequest(body)
+	if err != nil {
+		slog.Warn("Dropping malformed pointer frame", "room", roomLogID(c.room), "error", err)
+		return
+	}
+	// Stamp the sender so clients cannot impersonate each other.
+	pointer.ClientID = c.id
+	frame := protocol.EncodePointer(pointer)
+
+	c.pointerMux.Lock()
+	c.pendingPointer = frame
+	if c.pointerTimer != nil {
+		// A flush is already scheduled and will pick up the latest position.
+		c.pointerMux.Unlock()
+		return
+	}
+	wait := pointerInterval - time.Since(c.lastPointerSent)
+	if wait > 0 {
+		c.pointerTimer = time.AfterFunc(wait, c.flushPointer)
+		c.pointerMux.Unlock()
+		return
+	}
+	c.pointerMux.Unlock()
+	c.flushPointer()
+}
+
+// flushPointer relays the latest pending pointer frame, if any.
+func (c *Client) flushPointer() {
+	c.pointerMux.Lock()
+	frame := c.pendingPointer
+	c.pendingPointer = nil
+	c.pointerTimer = nil
+	if frame != nil {
+		c.lastPointerSent = time.Now()
+	}
+	c.pointerMux.Unlock()
+
+	if frame != nil {
+		c.deliver(&Message{room: c.room, data: frame, source: c, ephemeral: true})
+	}
+}
+
+// stopPointer cancels any scheduled pointer flush once the client disconnects.
+func (c *Client) stopPointer() {
+	c.pointerMux.Lock()
+	if c.pointerTimer != nil {
+		c.pointerTimer.Stop()
+		c.pointerTimer = nil
+	}
+	c.pendingPointer = nil
+	c.pointerMux.Unlock()
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// History modes for a room's board.
+const (
+	// historySnapshot keeps the server-side board and sends late joiners a snapshot.
+	historySnapshot = "snapshot"
+	// historyNone only relays updates; late joiners start from an empty board.
+	historyNone = "none"
+)
+
+const (
+	// maxRoomLifetime is the longest lifetime a created room may ask for.
+	maxRoomLifetime = 8 * time.Hour
+	// minLoneClientTimeout and maxLoneClientTimeout bound a created room's lone-client timeout.
+	minLoneClientTimeout = 30 * time.Second
+	maxLoneClientTimeout = 30 * time.Minute
+	// unclaimedRoomTimeout is how long a created room waits for its first client.
+	unclaimedRoomTimeout = 15 * time.Minute
+)
+
+// roomPolicy holds the limits the hub enforces for a room.
+type roomPolicy struct {
+	MaxUsers          int
+	MaxSpectators     int
+	Lifetime          time.Duration
+	LoneClientTimeout time.Duration
+	HistoryMode       string
+	// Record keeps the frames relayed in the room so the session can be replayed.
+	Record bool
+}
+
+// defaultRoomPolicy is the policy of rooms created implicitly by the first client
+// to join. Party rooms use a 64 character passphrase.
+func defaultRoomPolicy(passphrase, clientType string) roomPolicy {
+	policy := roomPolicy{
+		MaxUsers:          maxUsersShared,
+		MaxSpectators:     maxSpectators,
+		Lifetime:          roomLifetime,
+		LoneClientTimeout: loneClientTimeout,
+		HistoryMode:       historySnapshot,
+	}
+	if len(passphrase) == 64 {
+		policy.MaxUsers = maxUsersParty
+	}
+	// If the client is AetherBreaker, enforce the 2-player limit.
+	if clientType == "ab" {
+		policy.MaxUsers = aetherBreakerMaxUsers
+		policy.MaxSpectators = 0
+	}
+	return policy
+}
+
+// keepsBoard reports whether the room maintains a server-side board.
+func (p roomPolicy) keepsBoard() bool {
+	return p.HistoryMode != historyNone
+}
+
+// createRoomRequest is the JSON body accepted by /room/create. Zero values
+// fall back to the defaults of an implicitly created shared room. The same
+// shape is used to report a room's policy back to the caller.
+type createRoomRequest struct {
+	MaxUsers                 int    `json:"maxUsers"`
+	LifetimeMinutes          int    `json:"lifetimeMinutes"`
+	LoneClientTimeoutSeconds int    `json:"loneClientTimeoutSeconds"`
+	HistoryMode              string `json:"historyMode"`
+	AllowSpectators          *bool  `json:"allowSpectators"`
+	MaxSpectators            int    `json:"maxSpectators"`
+	Record                   bool   `json:"record,omitempty"`
+}
+
+// policy validates the request and converts it to a roomPolicy.
+func (req *createRoomRequest) policy() (roomPolicy, string) {
+	policy := defaultRoomPolicy("", "ad")
+	if req.MaxUsers != 0 {
+		if req.MaxUsers < 1 || req.MaxUsers > maxUsersShared {
+			return policy, "maxUsers must be between 1 and 48"
+		}
+		policy.MaxUsers = req.MaxUsers
+	}
+	if req.LifetimeMinutes != 0 {
+		lifetime := time.Duration(req.LifetimeMinutes) * time.Minute
+		if lifetime < time.Minute || lifetime > maxRoomLifetime {
+			return policy, "lifetimeMinutes must be between 1 and 480"
+		}
+		policy.Lifetime = lifetime
+	}
+	if req.LoneClientTimeoutSeconds != 0 {
+		timeout := time.Duration(req.LoneClientTimeoutSeconds) * time.Second
+		if timeout < minLoneClientTimeout || timeout > maxLoneClientTimeout {
+			return policy, "loneClientTimeoutSeconds must be between 30 and 1800"
+		}
+		policy.LoneClientTimeout = timeout
+	}
+	switch req.HistoryMode {
+	case "":
+	case historySnapshot, historyNone:
+		policy.HistoryMode = req.HistoryMode
+	default:
+		return policy, `historyMode must be "snapshot" or "none"`
+	}
+	if req.MaxSpectators != 0 {
+		if req.MaxSpectators < 0 || req.MaxSpectators > maxSpectators {
+			return policy, "maxSpectators must be between 0 and 100"
+		}
+		policy.MaxSpectators = req.MaxSpectators
+	}
+	if req.AllowSpectators != nil && !*req.AllowSpectators {
+		policy.MaxSpectators = 0
+	}
+	policy.Record = req.Record
+	return policy, ""
+}
+
+// request converts the policy back into its JSON form.
+func (p roomPolicy) request() createRoomRequest {
+	allowSpectators := p.MaxSpectators > 0
+	return createRoomRequest{
+		MaxUsers:                 p.MaxUsers,
+		LifetimeMinutes:          int(p.Lifetime / time.Minute),
+		LoneClientTimeoutSeconds: int(p.LoneClientTimeout / time.Second),
+		HistoryMode:              p.HistoryMode,
+		AllowSpectators:          &allowSpectators,
+		MaxSpectators:            p.MaxSpectators,
+		Record:                   p.Record,
+	}
+}
+
+// generateRoomID returns a random 32 character hex string, used both for
+// room IDs and for manage keys.
+func generateRoomID() (string, error) {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+// createRoomResponse is returned by /room/create. The manage key is only ever
+// shown here; it is needed to issue further invites through /room/invite.
+// Recorded rooms also get the ID to replay the session with through /room/replay.
+type createRoomResponse struct {
+	RoomID      string            `json:"roomId"`
+	ManageKey   string            `json:"manageKey"`
+	Invite      *inviteResponse   `json:"invite"`
+	Policy      createRoomRequest `json:"policy"`
+	RecordingID string            `json:"recordingId,omitempty"`
+}
+
+// handleRoomCreate creates an AetherDraw room with an explicit policy. The
+// room ID is not a secret: clients join through /ws with a signed token, and
+// the response carries a member token for the creator.
+func handleRoomCreate(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	var req createRoomRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+			http.Error(w, "Invalid room request", http.StatusBadRequest)
+			return
+		}
+	}
+	policy, problem := req.policy()
+	if problem != "" {
+		http.Error(w, problem, http.StatusBadRequest)
+		return
+	}
+	roomID, err := generateRoomID()
+	if err != nil {
+		slog.Error("Failed to generate room ID", "error", err)
+		http.Error(w, "Failed to create room", http.StatusInternalServerError)
+		return
+	}
+	manageKey, err := generateRoomID()
+	if err != nil {
+		slog.Error("Failed to generate manage key", "error", err)
+		http.Error(w, "Failed to create room", http.StatusInternalServerError)
+		return
+	}
+	// The creator's token lasts as long as the room can.
+	invite, err := issueJoinToken(roomID, roleMember, "", unclaimedRoomTimeout+policy.Lifetime)
+	if err != nil {
+		slog.Error("Failed to issue join token", "room", roomLogID(roomID), "error", err)
+		http.Error(w, "Failed to create room", http.StatusInternalServerError)
+		return
+	}
+
+	var recordingID string
+	if policy.Record {
+		if recordingID, err = generateRoomID(); err != nil {
+			slog.Error("Failed to generate recording ID", "error", err)
+			http.Error(w, "Failed to create room", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// The room closes itself if nobody joins it within unclaimedRoomTimeout.
+	room := newRoom(hub, roomID, policy, "ad")
+	room.access = &roomAccess{ManageKeyHash: hashManageKey(manageKey), RecordingID: recordingID}
+	if policy.Record {
+		room.recorder = newRecorder(recordingID)
+	}
+	record := room.access.marshal()
+	hub.roomsMux.Lock()
+	hub.addRoom(room)
+	hub.roomsMux.Unlock()
+	// Other instances open the room from this record when its clients reach them.
+	saveRoomAccess(roomID, room.creationTime, policy, record)
+
+	slog.Info("Created room via API", "room", roomLogID(roomID), "maxUsers", policy.MaxUsers, "lifetime", policy.Lifetime, "historyMode", policy.HistoryMode, "record", policy.Record)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(createRoomResponse{roomID, manageKey, invite, policy.request(), recordingID})
+}
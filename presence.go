@@ -0,0 +1,73 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/rail2025/AetherDraw-Server/protocol"
+)
+
+// maxDisplayNameLength is the maximum number of characters kept from a client's display name.
+const maxDisplayNameLength = 32
+
+// sanitizeDisplayName trims a user supplied display name, drops control
+// characters and bounds its length.
+func sanitizeDisplayName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(name))
+	if runes := []rune(name); len(runes) > maxDisplayNameLength {
+		name = string(runes[:maxDisplayNameLength])
+	}
+	return name
+}
+
+// presenceEntry describes the client in presence frames.
+func (c *Client) presenceEntry() protocol.PresenceEntry {
+	return protocol.PresenceEntry{
+		ID:          c.id,
+		DisplayName: c.displayName,
+		ClientType:  c.clientType,
+		Spectator:   c.role == roleSpectator,
+		JoinedAt:    c.joinedAt,
+	}
+}
+
+// roster returns presence entries for everyone in the room, including
+// clients connected to other instances, oldest first.
+// Only called from the room's loop.
+func (r *Room) roster() []protocol.PresenceEntry {
+	entries := r.localRoster()
+	for _, entry := range r.remoteClients {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].JoinedAt.Before(entries[j].JoinedAt) })
+	return entries
+}
+
+// localRoster returns presence entries for the clients connected to this instance.
+// Only called from the room's loop.
+func (r *Room) localRoster() []protocol.PresenceEntry {
+	entries := make([]protocol.PresenceEntry, 0, len(r.clients)+len(r.remoteClients))
+	for client := range r.clients {
+		entries = append(entries, client.presenceEntry())
+	}
+	return entries
+}
+
+// sendPresence queues a presence frame for every client in the room that
+// asked for presence, except skip.
+// Only called from the room's loop.
+func (r *Room) sendPresence(p *protocol.Presence, skip *Client) {
+	frame := protocol.EncodePresence(p)
+	for client := range r.clients {
+		if client == skip || !client.presence {
+			continue
+		}
+		client.enqueue(frame)
+	}
+}
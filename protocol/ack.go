@@ -0,0 +1,64 @@
+package protocol
+
+import "fmt"
+
+// AckStatus is the outcome of a StateUpdate frame reported back to its sender.
+type AckStatus byte
+
+const (
+	// AckRelayed means the frame was relayed to the room.
+	AckRelayed AckStatus = iota
+	// AckRateLimited means the frame was dropped by the sender's rate limiter.
+	AckRateLimited
+	// AckNoInitialState means the room has no initial state yet. The frame was
+	// relayed to connected clients but not recorded, so late joiners will not
+	// see it until someone sends a ReplacePage.
+	AckNoInitialState
+	// AckMalformed means the frame could not be decoded and was dropped.
+	AckMalformed
+	// AckRejected means the frame was well formed but not allowed, e.g. it
+	// came from a spectator or targeted a page that does not exist.
+	AckRejected
+	// AckConflict means some drawables in the frame were changed by someone
+	// else after the version it was based on. Those were dropped; the rest,
+	// if any, was relayed. A Conflict frame with the details follows.
+	AckConflict
+	// AckLocked means the frame changed or deleted drawables someone else
+	// holds the edit lock on. It was dropped, and the sender's copies of the
+	// drawables were put back.
+	AckLocked
+)
+
+func (s AckStatus) String() string {
+	switch s {
+	case AckRelayed:
+		return "Relayed"
+	case AckRateLimited:
+		return "RateLimited"
+	case AckNoInitialState:
+		return "NoInitialState"
+	case AckMalformed:
+		return "Malformed"
+	case AckRejected:
+		return "Rejected"
+	case AckConflict:
+		return "Conflict"
+	case AckLocked:
+		return "Locked"
+	}
+	return fmt.Sprintf("AckStatus(%d)", byte(s))
+}
+
+// EncodeAck returns a complete Ack frame:
+//
+//	[6][frameSeq int64][roomSeq int64][status byte]
+//
+// FrameSeq counts the StateUpdate frames the client sent on this connection,
+// starting at 1. RoomSeq is the room sequence number the frame was relayed
+// with, or 0 if it was not sequenced.
+func EncodeAck(frameSeq, roomSeq int64, status AckStatus) []byte {
+	frame := []byte{byte(Ack)}
+	frame = appendInt64(frame, frameSeq)
+	frame = appendInt64(frame, roomSeq)
+	return append(frame, byte(status))
+}
@@ -0,0 +1,55 @@
+package protocol
+
+import "fmt"
+
+// ClosingReason says why the server is closing a room.
+type ClosingReason byte
+
+const (
+	// ClosingLifetime means the room reached the end of its lifetime.
+	ClosingLifetime ClosingReason = iota
+	// ClosingLoneClient means the room has had a single client for too long.
+	ClosingLoneClient
+	// ClosingAdmin means the room's creator closed it.
+	ClosingAdmin
+	// ClosingShutdown means the server is shutting down. Clients may reconnect
+	// once it is back; the room is restored from its checkpoint.
+	ClosingShutdown
+	// ClosingIdle means nobody has drawn or pointed at anything for too long.
+	// Any activity before the deadline keeps the room open.
+	ClosingIdle
+)
+
+func (r ClosingReason) String() string {
+	switch r {
+	case ClosingLifetime:
+		return "Lifetime"
+	case ClosingLoneClient:
+		return "LoneClient"
+	case ClosingAdmin:
+		return "Admin"
+	case ClosingShutdown:
+		return "Shutdown"
+	case ClosingIdle:
+		return "Idle"
+	}
+	return fmt.Sprintf("ClosingReason(%d)", byte(r))
+}
+
+// Closing is the body of a RoomClosing frame:
+//
+//	[reason byte][secondsRemaining int32]
+//
+// Notices with SecondsRemaining above zero are sent ahead of time and may be
+// repeated as the deadline approaches. A notice with zero seconds is sent
+// right before the room is torn down.
+type Closing struct {
+	Reason           ClosingReason
+	SecondsRemaining int32
+}
+
+// EncodeClosing returns a complete RoomClosing frame.
+func EncodeClosing(c *Closing) []byte {
+	frame := []byte{byte(RoomClosing), byte(c.Reason)}
+	return appendInt32(frame, c.SecondsRemaining)
+}
@@ -0,0 +1,118 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Errors returned while decoding frame bodies.
+var (
+	ErrTruncated    = errors.New("protocol: frame body truncated")
+	ErrTrailingData = errors.New("protocol: trailing data in frame body")
+)
+
+// reader walks a frame body. The first failure sticks, so callers can read a
+// whole structure and check err once at the end.
+type reader struct {
+	buf []byte
+	off int
+	err error
+}
+
+func (r *reader) take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || len(r.buf)-r.off < n {
+		r.err = ErrTruncated
+		return nil
+	}
+	b := r.buf[r.off : r.off+n]
+	r.off += n
+	return b
+}
+
+func (r *reader) byte() byte {
+	if b := r.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *reader) int32() int32 {
+	if b := r.take(4); b != nil {
+		return int32(binary.LittleEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (r *reader) int64() int64 {
+	if b := r.take(8); b != nil {
+		return int64(binary.LittleEndian.Uint64(b))
+	}
+	return 0
+}
+
+func (r *reader) float32() float32 {
+	if b := r.take(4); b != nil {
+		return math.Float32frombits(binary.LittleEndian.Uint32(b))
+	}
+	return 0
+}
+
+// string reads a UTF-8 string with a 7-bit encoded length prefix, the format
+// written by .NET's BinaryWriter and the web client's BufferHandler.
+func (r *reader) string() string {
+	length, shift := 0, 0
+	for {
+		b := r.take(1)
+		if b == nil {
+			return ""
+		}
+		length |= int(b[0]&0x7F) << shift
+		if b[0]&0x80 == 0 {
+			break
+		}
+		shift += 7
+		if shift >= 35 {
+			r.err = ErrTruncated
+			return ""
+		}
+	}
+	return string(r.take(length))
+}
+
+// done reports the sticky error, or ErrTrailingData if bytes are left over.
+func (r *reader) done() error {
+	if r.err != nil {
+		return r.err
+	}
+	if r.off != len(r.buf) {
+		return ErrTrailingData
+	}
+	return nil
+}
+
+func appendInt32(dst []byte, v int32) []byte {
+	return binary.LittleEndian.AppendUint32(dst, uint32(v))
+}
+
+func appendInt64(dst []byte, v int64) []byte {
+	return binary.LittleEndian.AppendUint64(dst, uint64(v))
+}
+
+func appendFloat32(dst []byte, v float32) []byte {
+	return binary.LittleEndian.AppendUint32(dst, math.Float32bits(v))
+}
+
+// appendString writes s with a 7-bit encoded length prefix.
+func appendString(dst []byte, s string) []byte {
+	n := uint32(len(s))
+	for n >= 0x80 {
+		dst = append(dst, byte(n)|0x80)
+		n >>= 7
+	}
+	dst = append(dst, byte(n))
+	return append(dst, s...)
+}
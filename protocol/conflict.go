@@ -0,0 +1,43 @@
+package protocol
+
+import "fmt"
+
+// DecodeBasedOn unwraps a BasedOn frame sent by a client:
+//
+//	[11][baseSeq int64][frame]
+//
+// BaseSeq is the room sequence number of the last frame the client had
+// applied when it made the change, so the server can tell whether someone
+// else changed the same drawables in the meantime. The wrapped frame is
+// returned as is.
+func DecodeBasedOn(frame []byte) (int64, []byte, error) {
+	if len(frame) == 0 || MessageType(frame[0]) != BasedOn {
+		return 0, nil, fmt.Errorf("%w: expected %s", ErrUnexpectedType, BasedOn)
+	}
+	r := &reader{buf: frame[1:]}
+	baseSeq := r.int64()
+	if r.err != nil {
+		return 0, nil, r.err
+	}
+	if baseSeq < 0 {
+		return 0, nil, fmt.Errorf("protocol: invalid base sequence number %d", baseSeq)
+	}
+	return baseSeq, frame[1+r.off:], nil
+}
+
+// EncodeConflict returns the Conflict frame telling a client that its update
+// to some drawables lost against a newer one:
+//
+//	[12][pageIndex int32][guidCount int32][guid 16 bytes]...
+//
+// GUIDs are in canonical byte order. The server follows it with the current
+// state of those drawables so the client can converge.
+func EncodeConflict(pageIndex int32, ids [][16]byte) []byte {
+	frame := []byte{byte(Conflict)}
+	frame = appendInt32(frame, pageIndex)
+	frame = appendInt32(frame, int32(len(ids)))
+	for _, id := range ids {
+		frame = append(frame, id[:]...)
+	}
+	return frame
+}
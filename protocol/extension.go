@@ -0,0 +1,69 @@
+package protocol
+
+import "fmt"
+
+// ExtensionStatus is the outcome of a RoomExtension request.
+type ExtensionStatus byte
+
+const (
+	// ExtensionGranted means the room's deadline was pushed back. The reply
+	// goes to every AetherDraw client in the room.
+	ExtensionGranted ExtensionStatus = iota
+	// ExtensionLimitReached means the room already lives as long as the
+	// server allows.
+	ExtensionLimitReached
+	// ExtensionNotEnoughClients means too few members are in the room to extend it.
+	ExtensionNotEnoughClients
+	// ExtensionNotAllowed means the sender may not extend the room, e.g. it is a spectator.
+	ExtensionNotAllowed
+)
+
+func (s ExtensionStatus) String() string {
+	switch s {
+	case ExtensionGranted:
+		return "Granted"
+	case ExtensionLimitReached:
+		return "LimitReached"
+	case ExtensionNotEnoughClients:
+		return "NotEnoughClients"
+	case ExtensionNotAllowed:
+		return "NotAllowed"
+	}
+	return fmt.Sprintf("ExtensionStatus(%d)", byte(s))
+}
+
+// Extension answers a request to extend a room. Clients send
+//
+//	[minutes int32]
+//
+// asking for the room's lifetime to be extended by that many minutes, and
+// the server replies with
+//
+//	[status byte][secondsRemaining int32]
+//
+// where SecondsRemaining is the time left until the room's deadline after
+// the request was handled.
+type Extension struct {
+	Status           ExtensionStatus
+	SecondsRemaining int32
+}
+
+// DecodeExtensionRequest parses the body of a RoomExtension frame sent by a
+// client and returns the requested number of minutes.
+func DecodeExtensionRequest(body []byte) (int32, error) {
+	r := &reader{buf: body}
+	minutes := r.int32()
+	if err := r.done(); err != nil {
+		return 0, err
+	}
+	if minutes <= 0 {
+		return 0, fmt.Errorf("protocol: invalid extension of %d minutes", minutes)
+	}
+	return minutes, nil
+}
+
+// EncodeExtension returns the RoomExtension frame sent by the server.
+func EncodeExtension(e *Extension) []byte {
+	frame := []byte{byte(RoomExtension), byte(e.Status)}
+	return appendInt32(frame, e.SecondsRemaining)
+}
@@ -0,0 +1,82 @@
+package protocol
+
+import "fmt"
+
+// maxLockIDs bounds the GUID count of an ObjectLock frame.
+const maxLockIDs = 256
+
+// Lock announces that a client took or released the edit locks on
+// some drawables. On the wire it is
+//
+//	[13][holderId string][holderName string][locked byte][guidCount int32][guid 16 bytes]...
+//
+// The server broadcasts one whenever locks change, sends the current ones to
+// clients when they join, and answers a request for drawables someone else
+// holds with the other holder's locks.
+type Lock struct {
+	HolderID   string
+	HolderName string
+	Locked     bool
+	IDs        [][16]byte
+}
+
+// EncodeLock returns a complete ObjectLock frame.
+func EncodeLock(l *Lock) []byte {
+	frame := []byte{byte(ObjectLock)}
+	frame = appendString(frame, l.HolderID)
+	frame = appendString(frame, l.HolderName)
+	frame = append(frame, boolByte(l.Locked))
+	return appendGuids(frame, l.IDs)
+}
+
+// DecodeLock parses the body of an ObjectLock frame written by EncodeLock.
+func DecodeLock(body []byte) (*Lock, error) {
+	r := &reader{buf: body}
+	l := &Lock{HolderID: r.string(), HolderName: r.string(), Locked: r.byte() != 0}
+	l.IDs = r.guids()
+	if err := r.done(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// DecodeLockRequest parses the body of an ObjectLock frame sent by a client,
+//
+//	[locked byte][guidCount int32][guid 16 bytes]...
+//
+// and returns whether the client takes or releases the locks, and on which drawables.
+func DecodeLockRequest(body []byte) (locked bool, ids [][16]byte, err error) {
+	r := &reader{buf: body}
+	locked = r.byte() != 0
+	ids = r.guids()
+	if err := r.done(); err != nil {
+		return false, nil, err
+	}
+	if len(ids) == 0 {
+		return false, nil, fmt.Errorf("protocol: lock request without drawables")
+	}
+	return locked, ids, nil
+}
+
+// guids reads a GUID list with an int32 count prefix, at most maxLockIDs long.
+func (r *reader) guids() [][16]byte {
+	count := r.int32()
+	if r.err == nil && (count < 0 || count > maxLockIDs) {
+		r.err = fmt.Errorf("protocol: invalid GUID count %d", count)
+	}
+	var ids [][16]byte
+	for i := int32(0); i < count && r.err == nil; i++ {
+		if b := r.take(16); b != nil {
+			ids = append(ids, [16]byte(b))
+		}
+	}
+	return ids
+}
+
+func appendGuids(dst []byte, ids [][16]byte) []byte {
+	dst = appendInt32(dst, int32(len(ids)))
+	for _, id := range ids {
+		dst = append(dst, id[:]...)
+	}
+	return dst
+}
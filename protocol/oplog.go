@@ -0,0 +1,118 @@
+package protocol
+
+import "fmt"
+
+// Operation is an entry of a room's operation log: a StateUpdate relayed to
+// the room, identified by the room sequence number it was relayed with.
+type Operation struct {
+	Seq        int64
+	AuthorID   string
+	AuthorName string
+	Action     PayloadActionType
+	PageIndex  int32
+	// IDs are the GUIDs of the drawables the update touched, in canonical byte order.
+	IDs [][16]byte
+	// Undoable is set if the server can emit the inverse of the update.
+	Undoable bool
+	// Undone is set once someone has undone the update.
+	Undone bool
+}
+
+// EncodeOperationLog returns the OperationLog frame sent in reply to a
+// client's request, which is a bare [9] frame. The body is
+//
+//	[count int32]
+//
+// followed by, per operation,
+//
+//	[seq int64][authorId string][authorName string][action byte][pageIndex int32]
+//	[undoable byte][undone byte][guidCount int32][guid 16 bytes]...
+func EncodeOperationLog(ops []*Operation) []byte {
+	frame := []byte{byte(OperationLog)}
+	frame = appendInt32(frame, int32(len(ops)))
+	for _, op := range ops {
+		frame = appendInt64(frame, op.Seq)
+		frame = appendString(frame, op.AuthorID)
+		frame = appendString(frame, op.AuthorName)
+		frame = append(frame, byte(op.Action))
+		frame = appendInt32(frame, op.PageIndex)
+		frame = append(frame, boolByte(op.Undoable), boolByte(op.Undone))
+		frame = appendInt32(frame, int32(len(op.IDs)))
+		for _, id := range op.IDs {
+			frame = append(frame, id[:]...)
+		}
+	}
+	return frame
+}
+
+// UndoStatus is the outcome of an UndoOperation request.
+type UndoStatus byte
+
+const (
+	// UndoApplied means the inverse of the operation was relayed to the room.
+	UndoApplied UndoStatus = iota
+	// UndoUnknownOperation means the operation is not, or no longer, in the room's log.
+	UndoUnknownOperation
+	// UndoAlreadyUndone means someone already undid the operation.
+	UndoAlreadyUndone
+	// UndoNotUndoable means the server cannot invert the operation, or a
+	// later page deletion moved it out of reach.
+	UndoNotUndoable
+	// UndoNotAllowed means the sender may not undo operations, e.g. it is a spectator.
+	UndoNotAllowed
+	// UndoFailed means the board no longer accepts the inverse.
+	UndoFailed
+	// UndoLocked means someone else holds the edit lock on a drawable the
+	// inverse would change.
+	UndoLocked
+)
+
+func (s UndoStatus) String() string {
+	switch s {
+	case UndoApplied:
+		return "Applied"
+	case UndoUnknownOperation:
+		return "UnknownOperation"
+	case UndoAlreadyUndone:
+		return "AlreadyUndone"
+	case UndoNotUndoable:
+		return "NotUndoable"
+	case UndoNotAllowed:
+		return "NotAllowed"
+	case UndoFailed:
+		return "Failed"
+	case UndoLocked:
+		return "Locked"
+	}
+	return fmt.Sprintf("UndoStatus(%d)", byte(s))
+}
+
+// DecodeUndoRequest parses the body of an UndoOperation frame sent by a
+// client, [seq int64], and returns the sequence number of the operation to undo.
+func DecodeUndoRequest(body []byte) (int64, error) {
+	r := &reader{buf: body}
+	seq := r.int64()
+	if err := r.done(); err != nil {
+		return 0, err
+	}
+	if seq <= 0 {
+		return 0, fmt.Errorf("protocol: invalid operation %d", seq)
+	}
+	return seq, nil
+}
+
+// EncodeUndoResult returns the UndoOperation frame answering a request:
+//
+//	[10][seq int64][status byte]
+func EncodeUndoResult(seq int64, status UndoStatus) []byte {
+	frame := []byte{byte(UndoOperation)}
+	frame = appendInt64(frame, seq)
+	return append(frame, byte(status))
+}
+
+func boolByte(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,71 @@
+package protocol
+
+import (
+	"fmt"
+	"math"
+)
+
+// PointerKind is how a pointer should be drawn by the receiving clients.
+type PointerKind byte
+
+const (
+	// PointerCursor is a plain cursor position.
+	PointerCursor PointerKind = iota
+	// PointerLaser is a highlighted laser-pointer position.
+	PointerLaser
+	// PointerHidden tells receivers to stop showing the sender's pointer.
+	PointerHidden
+)
+
+// Pointer is an ephemeral pointer position. Clients send
+//
+//	[pageIndex int32][x float32][y float32][kind byte]
+//
+// and the server relays it to the rest of the room prefixed with the
+// sender's client ID:
+//
+//	[clientID string][pageIndex int32][x float32][y float32][kind byte]
+type Pointer struct {
+	ClientID  string
+	PageIndex int32
+	X, Y      float32
+	Kind      PointerKind
+}
+
+// DecodePointerRequest parses the body of a PointerUpdate frame sent by a client.
+func DecodePointerRequest(body []byte) (*Pointer, error) {
+	r := &reader{buf: body}
+	p := &Pointer{
+		PageIndex: r.int32(),
+		X:         r.float32(),
+		Y:         r.float32(),
+		Kind:      PointerKind(r.byte()),
+	}
+	if err := r.done(); err != nil {
+		return nil, err
+	}
+	if p.PageIndex < 0 {
+		return nil, fmt.Errorf("%w: %d", ErrNegativePage, p.PageIndex)
+	}
+	if p.Kind > PointerHidden {
+		return nil, fmt.Errorf("protocol: unknown pointer kind %d", p.Kind)
+	}
+	if !isFinite(p.X) || !isFinite(p.Y) {
+		return nil, fmt.Errorf("protocol: non-finite pointer position")
+	}
+	return p, nil
+}
+
+// EncodePointer returns the PointerUpdate frame relayed to the room.
+func EncodePointer(p *Pointer) []byte {
+	frame := []byte{byte(PointerUpdate)}
+	frame = appendString(frame, p.ClientID)
+	frame = appendInt32(frame, p.PageIndex)
+	frame = appendFloat32(frame, p.X)
+	frame = appendFloat32(frame, p.Y)
+	return append(frame, byte(p.Kind))
+}
+
+func isFinite(f float32) bool {
+	return !math.IsNaN(float64(f)) && !math.IsInf(float64(f), 0)
+}
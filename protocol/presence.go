@@ -0,0 +1,82 @@
+package protocol
+
+import (
+	"fmt"
+	"time"
+)
+
+// PresenceKind says whether a Presence frame is a full roster or a delta.
+type PresenceKind byte
+
+const (
+	// PresenceRoster lists everyone in the room. Sent to a client when it joins.
+	PresenceRoster PresenceKind = iota
+	// PresenceJoined lists clients that just joined.
+	PresenceJoined
+	// PresenceLeft lists clients that just left.
+	PresenceLeft
+)
+
+// maxPresenceEntries bounds the entry count accepted by DecodePresence.
+const maxPresenceEntries = 1024
+
+// PresenceEntry describes one connected client.
+//
+// On the wire an entry is:
+//
+//	[id string][displayName string][clientType string][spectator byte][joinedAt int64 unix ms]
+type PresenceEntry struct {
+	ID          string
+	DisplayName string
+	ClientType  string
+	Spectator   bool
+	JoinedAt    time.Time
+}
+
+// Presence is the body of a Presence frame: [kind byte][count int32][entries].
+type Presence struct {
+	Kind    PresenceKind
+	Entries []PresenceEntry
+}
+
+// EncodePresence returns a complete Presence frame.
+func EncodePresence(p *Presence) []byte {
+	frame := []byte{byte(PresenceUpdate), byte(p.Kind)}
+	frame = appendInt32(frame, int32(len(p.Entries)))
+	for _, e := range p.Entries {
+		frame = appendString(frame, e.ID)
+		frame = appendString(frame, e.DisplayName)
+		frame = appendString(frame, e.ClientType)
+		spectator := byte(0)
+		if e.Spectator {
+			spectator = 1
+		}
+		frame = append(frame, spectator)
+		frame = appendInt64(frame, e.JoinedAt.UnixMilli())
+	}
+	return frame
+}
+
+// DecodePresence parses the body of a Presence frame.
+func DecodePresence(body []byte) (*Presence, error) {
+	r := &reader{buf: body}
+	p := &Presence{Kind: PresenceKind(r.byte())}
+	count := r.int32()
+	if r.err == nil && (count < 0 || count > maxPresenceEntries) {
+		return nil, fmt.Errorf("protocol: invalid presence entry count %d", count)
+	}
+	for i := int32(0); i < count && r.err == nil; i++ {
+		e := PresenceEntry{
+			ID:          r.string(),
+			DisplayName: r.string(),
+			ClientType:  r.string(),
+			Spectator:   r.byte() != 0,
+		}
+		e.JoinedAt = time.UnixMilli(r.int64())
+		p.Entries = append(p.Entries, e)
+	}
+	if err := r.done(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
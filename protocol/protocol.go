@@ -0,0 +1,211 @@
+// Package protocol implements the binary frame format spoken by AetherDraw
+// clients over the relay WebSocket. It mirrors messageContracts.js and
+// payloadSerializer.js in the web client.
+//
+// Every frame starts with a one byte MessageType. Strings inside frame bodies
+// are UTF-8 with a 7-bit encoded length prefix, as written by .NET's
+// BinaryWriter. STATE_UPDATE frames carry a
+// NetworkPayload laid out as:
+//
+//	[pageIndex int32][action byte][dataLength int32][data]
+//
+// with all integers little-endian.
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// MessageType identifies the kind of frame, stored in the first byte.
+type MessageType byte
+
+const (
+	// StateUpdate frames carry a NetworkPayload describing a board change.
+	StateUpdate MessageType = 0
+	// RoomClosingImminently is sent by the server just before a room is torn down.
+	RoomClosingImminently MessageType = 1
+	// PresenceUpdate frames carry a Presence roster or join/leave delta.
+	PresenceUpdate MessageType = 2
+	// PointerUpdate frames carry an ephemeral cursor or laser-pointer position.
+	PointerUpdate MessageType = 3
+	// SessionStarted is sent to clients that opted into sequencing when they join.
+	SessionStarted MessageType = 4
+	// Sequenced wraps a relayed frame with its room sequence number.
+	Sequenced MessageType = 5
+	// Ack tells the sender what happened to one of its StateUpdate frames.
+	Ack MessageType = 6
+	// RoomClosing tells AetherDraw clients why and when their room will close.
+	RoomClosing MessageType = 7
+	// RoomExtension asks the server to extend the room's lifetime, and carries its answer.
+	RoomExtension MessageType = 8
+	// OperationLog asks for the room's operation log, and carries it.
+	OperationLog MessageType = 9
+	// UndoOperation asks the server to undo an operation from the log, and carries the outcome.
+	UndoOperation MessageType = 10
+	// BasedOn wraps a client's StateUpdate with the room sequence number it is based on.
+	BasedOn MessageType = 11
+	// Conflict tells a client that some of its changes lost against newer ones.
+	Conflict MessageType = 12
+	// ObjectLock takes or releases edit locks on drawables, and announces who holds them.
+	ObjectLock MessageType = 13
+	// PageSubscription tells the server which pages a client wants StateUpdates for.
+	PageSubscription MessageType = 14
+)
+
+func (t MessageType) String() string {
+	switch t {
+	case StateUpdate:
+		return "StateUpdate"
+	case RoomClosingImminently:
+		return "RoomClosingImminently"
+	case PresenceUpdate:
+		return "PresenceUpdate"
+	case PointerUpdate:
+		return "PointerUpdate"
+	case SessionStarted:
+		return "SessionStarted"
+	case Sequenced:
+		return "Sequenced"
+	case Ack:
+		return "Ack"
+	case RoomClosing:
+		return "RoomClosing"
+	case RoomExtension:
+		return "RoomExtension"
+	case OperationLog:
+		return "OperationLog"
+	case UndoOperation:
+		return "UndoOperation"
+	case BasedOn:
+		return "BasedOn"
+	case Conflict:
+		return "Conflict"
+	case ObjectLock:
+		return "ObjectLock"
+	case PageSubscription:
+		return "PageSubscription"
+	}
+	return fmt.Sprintf("MessageType(%d)", byte(t))
+}
+
+// PayloadActionType is the operation a StateUpdate applies to a page.
+type PayloadActionType byte
+
+const (
+	AddObjects PayloadActionType = iota
+	DeleteObjects
+	UpdateObjects
+	ClearPage
+	ReplacePage
+	AddNewPage
+	DeletePage
+)
+
+func (a PayloadActionType) String() string {
+	switch a {
+	case AddObjects:
+		return "AddObjects"
+	case DeleteObjects:
+		return "DeleteObjects"
+	case UpdateObjects:
+		return "UpdateObjects"
+	case ClearPage:
+		return "ClearPage"
+	case ReplacePage:
+		return "ReplacePage"
+	case AddNewPage:
+		return "AddNewPage"
+	case DeletePage:
+		return "DeletePage"
+	}
+	return fmt.Sprintf("PayloadActionType(%d)", byte(a))
+}
+
+// Valid reports whether a is one of the known action types.
+func (a PayloadActionType) Valid() bool {
+	return a <= DeletePage
+}
+
+// payloadHeaderSize is the size of pageIndex + action + dataLength.
+const payloadHeaderSize = 4 + 1 + 4
+
+// Errors returned when a frame cannot be decoded.
+var (
+	ErrEmptyFrame     = errors.New("protocol: empty frame")
+	ErrShortPayload   = errors.New("protocol: payload shorter than header")
+	ErrUnknownAction  = errors.New("protocol: unknown payload action")
+	ErrNegativePage   = errors.New("protocol: negative page index")
+	ErrBadDataLength  = errors.New("protocol: data length does not match frame")
+	ErrUnexpectedType = errors.New("protocol: unexpected message type")
+)
+
+// NetworkPayload is the body of a StateUpdate frame.
+type NetworkPayload struct {
+	PageIndex int32
+	Action    PayloadActionType
+	Data      []byte
+}
+
+// SplitFrame returns the message type and the remaining body of a frame.
+// The body aliases frame.
+func SplitFrame(frame []byte) (MessageType, []byte, error) {
+	if len(frame) == 0 {
+		return 0, nil, ErrEmptyFrame
+	}
+	return MessageType(frame[0]), frame[1:], nil
+}
+
+// DecodePayload parses a NetworkPayload from b. The returned Data aliases b.
+func DecodePayload(b []byte) (*NetworkPayload, error) {
+	if len(b) < payloadHeaderSize {
+		return nil, fmt.Errorf("%w: got %d bytes", ErrShortPayload, len(b))
+	}
+	p := &NetworkPayload{
+		PageIndex: int32(binary.LittleEndian.Uint32(b[0:4])),
+		Action:    PayloadActionType(b[4]),
+	}
+	if p.PageIndex < 0 {
+		return nil, fmt.Errorf("%w: %d", ErrNegativePage, p.PageIndex)
+	}
+	if !p.Action.Valid() {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownAction, byte(p.Action))
+	}
+	dataLength := int32(binary.LittleEndian.Uint32(b[5:9]))
+	rest := b[payloadHeaderSize:]
+	if dataLength < 0 || int(dataLength) != len(rest) {
+		return nil, fmt.Errorf("%w: header says %d, %d bytes remain", ErrBadDataLength, dataLength, len(rest))
+	}
+	if dataLength > 0 {
+		p.Data = rest
+	}
+	return p, nil
+}
+
+// DecodeStateUpdate parses a complete STATE_UPDATE frame.
+func DecodeStateUpdate(frame []byte) (*NetworkPayload, error) {
+	msgType, body, err := SplitFrame(frame)
+	if err != nil {
+		return nil, err
+	}
+	if msgType != StateUpdate {
+		return nil, fmt.Errorf("%w: %s", ErrUnexpectedType, msgType)
+	}
+	return DecodePayload(body)
+}
+
+// AppendPayload appends the wire encoding of p to dst.
+func (p *NetworkPayload) AppendPayload(dst []byte) []byte {
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(p.PageIndex))
+	dst = append(dst, byte(p.Action))
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(len(p.Data)))
+	return append(dst, p.Data...)
+}
+
+// EncodeStateUpdate returns a complete STATE_UPDATE frame carrying p.
+func EncodeStateUpdate(p *NetworkPayload) []byte {
+	frame := make([]byte, 0, 1+payloadHeaderSize+len(p.Data))
+	frame = append(frame, byte(StateUpdate))
+	return p.AppendPayload(frame)
+}
@@ -0,0 +1,94 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestDecodePayload(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []byte
+		want *NetworkPayload
+		err  error
+	}{
+		{
+			name: "empty data",
+			in:   []byte{2, 0, 0, 0, byte(AddNewPage), 0, 0, 0, 0},
+			want: &NetworkPayload{PageIndex: 2, Action: AddNewPage},
+		},
+		{
+			name: "with data",
+			in:   []byte{1, 0, 0, 0, byte(DeleteObjects), 3, 0, 0, 0, 0xAA, 0xBB, 0xCC},
+			want: &NetworkPayload{PageIndex: 1, Action: DeleteObjects, Data: []byte{0xAA, 0xBB, 0xCC}},
+		},
+		{
+			name: "short header",
+			in:   []byte{0, 0, 0, 0, byte(ClearPage), 0, 0, 0},
+			err:  ErrShortPayload,
+		},
+		{
+			name: "negative page",
+			in:   []byte{0xFF, 0xFF, 0xFF, 0xFF, byte(ClearPage), 0, 0, 0, 0},
+			err:  ErrNegativePage,
+		},
+		{
+			name: "unknown action",
+			in:   []byte{0, 0, 0, 0, byte(DeletePage) + 1, 0, 0, 0, 0},
+			err:  ErrUnknownAction,
+		},
+		{
+			name: "data shorter than length",
+			in:   []byte{0, 0, 0, 0, byte(AddObjects), 4, 0, 0, 0, 1, 2},
+			err:  ErrBadDataLength,
+		},
+		{
+			name: "trailing data",
+			in:   []byte{0, 0, 0, 0, byte(AddObjects), 1, 0, 0, 0, 1, 2},
+			err:  ErrBadDataLength,
+		},
+		{
+			name: "negative length",
+			in:   []byte{0, 0, 0, 0, byte(AddObjects), 0xFF, 0xFF, 0xFF, 0xFF},
+			err:  ErrBadDataLength,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodePayload(tt.in)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("DecodePayload() error = %v, want %v", err, tt.err)
+			}
+			if tt.err != nil {
+				return
+			}
+			if got.PageIndex != tt.want.PageIndex || got.Action != tt.want.Action || !bytes.Equal(got.Data, tt.want.Data) {
+				t.Fatalf("DecodePayload() = %+v, want %+v", got, tt.want)
+			}
+			if encoded := got.AppendPayload(nil); !bytes.Equal(encoded, tt.in) {
+				t.Errorf("AppendPayload() = %x, want %x", encoded, tt.in)
+			}
+		})
+	}
+}
+
+func TestDecodeStateUpdate(t *testing.T) {
+	p := &NetworkPayload{PageIndex: 3, Action: ReplacePage, Data: []byte{1, 2, 3}}
+	frame := EncodeStateUpdate(p)
+	got, err := DecodeStateUpdate(frame)
+	if err != nil {
+		t.Fatalf("DecodeStateUpdate() error = %v", err)
+	}
+	if got.PageIndex != p.PageIndex || got.Action != p.Action || !bytes.Equal(got.Data, p.Data) {
+		t.Fatalf("DecodeStateUpdate() = %+v, want %+v", got, p)
+	}
+
+	if _, err := DecodeStateUpdate(nil); !errors.Is(err, ErrEmptyFrame) {
+		t.Errorf("DecodeStateUpdate(nil) error = %v, want %v", err, ErrEmptyFrame)
+	}
+	frame[0] = byte(PresenceUpdate)
+	if _, err := DecodeStateUpdate(frame); !errors.Is(err, ErrUnexpectedType) {
+		t.Errorf("DecodeStateUpdate(presence) error = %v, want %v", err, ErrUnexpectedType)
+	}
+}
@@ -0,0 +1,36 @@
+package protocol
+
+// Session is the body of a SessionStarted frame:
+//
+//	[sessionID string][seq int64][resumed byte]
+//
+// Seq is the sequence number of the last frame relayed in the room before
+// the client joined. When Resumed is set the client kept its board and only
+// the frames it missed follow; otherwise a full snapshot follows.
+type Session struct {
+	ID      string
+	Seq     int64
+	Resumed bool
+}
+
+// EncodeSession returns a complete SessionStarted frame.
+func EncodeSession(s *Session) []byte {
+	frame := []byte{byte(SessionStarted)}
+	frame = appendString(frame, s.ID)
+	frame = appendInt64(frame, s.Seq)
+	resumed := byte(0)
+	if s.Resumed {
+		resumed = 1
+	}
+	return append(frame, resumed)
+}
+
+// EncodeSequenced wraps a relayed frame with its room sequence number:
+//
+//	[5][seq int64][frame]
+func EncodeSequenced(seq int64, frame []byte) []byte {
+	out := make([]byte, 0, 1+8+len(frame))
+	out = append(out, byte(Sequenced))
+	out = appendInt64(out, seq)
+	return append(out, frame...)
+}
@@ -0,0 +1,34 @@
+package protocol
+
+import "fmt"
+
+// maxSubscribedPages bounds the page count of a PageSubscription frame.
+const maxSubscribedPages = 256
+
+// DecodePageSubscription parses the body of a PageSubscription frame sent by
+// a client,
+//
+//	[count int32][pageIndex int32]...
+//
+// and returns the pages the client wants StateUpdates for. An empty list
+// subscribes the client to every page again.
+func DecodePageSubscription(body []byte) ([]int32, error) {
+	r := &reader{buf: body}
+	count := r.int32()
+	if r.err == nil && (count < 0 || count > maxSubscribedPages) {
+		return nil, fmt.Errorf("protocol: invalid page count %d", count)
+	}
+	pages := make([]int32, 0, max(count, 0))
+	for i := int32(0); i < count && r.err == nil; i++ {
+		pages = append(pages, r.int32())
+	}
+	if err := r.done(); err != nil {
+		return nil, err
+	}
+	for _, page := range pages {
+		if page < 0 {
+			return nil, fmt.Errorf("%w: %d", ErrNegativePage, page)
+		}
+	}
+	return pages, nil
+}
@@ -221,7 +221,9 @@ document.addEventListener('DOMContentLoaded', () => {
                 const newUrl = `${window.location.origin}${window.location.pathname}?plan=${planId}`;
                 window.history.pushState({ path: newUrl }, '', newUrl);
                 
-                navigator.clipboard.writeText(newUrl);
+                // The share page previews the plan in chat apps and links back here.
+                const shareUrl = `https://aetherdraw-server.onrender.com/p/${planId}`;
+                navigator.clipboard.writeText(shareUrl);
                 uiManager.showSavingStatus("Saved! Link copied to clipboard.");
 
             } catch (error) {
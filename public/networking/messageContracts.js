@@ -1,6 +1,25 @@
 const MessageType = Object.freeze({
     STATE_UPDATE: 0,
     ROOM_CLOSING_IMMINENTLY: 1,
+    PRESENCE_UPDATE: 2,
+    POINTER_UPDATE: 3,
+    SESSION_STARTED: 4,
+    SEQUENCED: 5,
+    ACK: 6,
+    ROOM_CLOSING: 7,
+    ROOM_EXTENSION: 8,
+    OPERATION_LOG: 9,
+    UNDO_OPERATION: 10,
+    BASED_ON: 11,
+    CONFLICT: 12,
+    OBJECT_LOCK: 13,
+    PAGE_SUBSCRIPTION: 14,
+});
+
+const PresenceKind = Object.freeze({
+    Roster: 0,
+    Joined: 1,
+    Left: 2,
 });
 
 const PayloadActionType = Object.freeze({
@@ -11,4 +30,51 @@ const PayloadActionType = Object.freeze({
     ReplacePage: 4,
     AddNewPage: 5,
     DeletePage: 6,
+});
+
+// Application WebSocket close codes sent by the server.
+const CloseCode = Object.freeze({
+    SlowConsumer: 4001,
+});
+
+const AckStatus = Object.freeze({
+    Relayed: 0,
+    RateLimited: 1,
+    NoInitialState: 2,
+    Malformed: 3,
+    Rejected: 4,
+    Conflict: 5,
+    Locked: 6,
+});
+
+// Why the server is closing a room, carried by ROOM_CLOSING frames.
+const ClosingReason = Object.freeze({
+    Lifetime: 0,
+    LoneClient: 1,
+    Admin: 2,
+    Shutdown: 3,
+    Idle: 4,
+});
+
+const ExtensionStatus = Object.freeze({
+    Granted: 0,
+    LimitReached: 1,
+    NotEnoughClients: 2,
+    NotAllowed: 3,
+});
+
+const UndoStatus = Object.freeze({
+    Applied: 0,
+    UnknownOperation: 1,
+    AlreadyUndone: 2,
+    NotUndoable: 3,
+    NotAllowed: 4,
+    Failed: 5,
+    Locked: 6,
+});
+
+const PointerKind = Object.freeze({
+    Cursor: 0,
+    Laser: 1,
+    Hidden: 2,
 });
\ No newline at end of file
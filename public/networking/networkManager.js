@@ -6,17 +6,44 @@ class NetworkManager {
         this.onError = (err) => {};
         this.onStateUpdateReceived = (payload) => {};
         this.onRoomClosingWarning = () => {};
+        this.onRoomClosing = (notice) => {};
+        this.onRoomExtension = (extension) => {};
+        this.onOperationLogReceived = (operations) => {};
+        this.onUndoResult = (result) => {};
+        this.onConflict = (conflict) => {};
+        this.onLockChanged = (lock) => {};
+        this.onPresenceReceived = (presence) => {};
+        this.onPointerReceived = (pointer) => {};
+        this.onSessionStarted = (session) => {};
+        this.onAckReceived = (ack) => {};
+
+        // Session issued by the server and the last relayed frame seen, used to resume.
+        this.sessionId = null;
+        this.lastSeq = 0;
     }
 
     get isConnected() {
         return this.webSocket?.readyState === WebSocket.OPEN;
     }
 
-    async connectAsync(serverUri, passphrase) {
+    // Pass resume = true when reconnecting after a dropped connection to receive
+    // only the updates missed in the meantime instead of the whole board. Pass the
+    // indices of the pages being viewed as pages to receive only their updates, see
+    // subscribeToPages.
+    async connectAsync(serverUri, passphrase, displayName = "", resume = false, pages = null) {
         if (this.isConnected) return;
 
         try {
-            const connectUri = `${serverUri}?passphrase=${encodeURIComponent(passphrase)}&client=ad-web`;
+            let connectUri = `${serverUri}?passphrase=${encodeURIComponent(passphrase)}&client=ad-web&sequenced=1&acks=1&presence=1&locks=1&notices=1&pointers=1`;
+            if (displayName) {
+                connectUri += `&name=${encodeURIComponent(displayName)}`;
+            }
+            if (resume && this.sessionId) {
+                connectUri += `&session=${encodeURIComponent(this.sessionId)}&lastSeq=${this.lastSeq}`;
+            }
+            if (pages && pages.length > 0) {
+                connectUri += `&pages=${pages.join(',')}`;
+            }
             this.webSocket = new WebSocket(connectUri);
             this.webSocket.binaryType = 'arraybuffer';
 
@@ -24,8 +51,11 @@ class NetworkManager {
                 this.onConnected();
             };
 
-            this.webSocket.onclose = () => {
+            this.webSocket.onclose = (event) => {
                 this.webSocket = null;
+                if (event.code === CloseCode.SlowConsumer) {
+                    this.onError("Disconnected by the server: the connection could not keep up.");
+                }
                 this.onDisconnected();
             };
 
@@ -76,6 +106,231 @@ class NetworkManager {
             case MessageType.ROOM_CLOSING_IMMINENTLY:
                 this.onRoomClosingWarning();
                 break;
+
+            // Closing body: [reason byte][secondsRemaining int32]. Notices arrive ahead of
+            // time as a countdown; zero seconds means the room is closing now.
+            case MessageType.ROOM_CLOSING:
+                if (payloadBytes.byteLength < 5) return;
+                const closingView = new DataView(payloadBytes);
+                this.onRoomClosing({
+                    reason: closingView.getUint8(0),
+                    secondsRemaining: closingView.getInt32(1, true),
+                });
+                break;
+
+            // Extension body: [status byte][secondsRemaining int32]. Granted extensions go to
+            // the whole room and call off any lifetime closing countdown.
+            case MessageType.ROOM_EXTENSION:
+                if (payloadBytes.byteLength < 5) return;
+                const extensionView = new DataView(payloadBytes);
+                this.onRoomExtension({
+                    status: extensionView.getUint8(0),
+                    secondsRemaining: extensionView.getInt32(1, true),
+                });
+                break;
+
+            case MessageType.OPERATION_LOG:
+                const operations = this._deserializeOperationLog(payloadBytes);
+                if (operations) {
+                    this.onOperationLogReceived(operations);
+                }
+                break;
+
+            // Conflict body: [pageIndex int32][guidCount int32][guid 16 bytes]... Our changes to
+            // these drawables lost; their current state follows as ordinary state updates.
+            case MessageType.CONFLICT:
+                const conflict = this._deserializeConflict(payloadBytes);
+                if (conflict) {
+                    this.onConflict(conflict);
+                }
+                break;
+
+            // Lock body: [holderId string][holderName string][locked byte][guidCount int32]
+            // [guid 16 bytes]... Sent when someone takes or releases edit locks, and with the
+            // current holder when we ask for a drawable someone else has locked.
+            case MessageType.OBJECT_LOCK:
+                const lock = this._deserializeLock(payloadBytes);
+                if (lock) {
+                    this.onLockChanged(lock);
+                }
+                break;
+
+            // Undo body: [seq int64][status byte]. On success the inverse arrives as
+            // ordinary state updates.
+            case MessageType.UNDO_OPERATION:
+                if (payloadBytes.byteLength < 9) return;
+                const undoView = new DataView(payloadBytes);
+                this.onUndoResult({
+                    seq: Number(undoView.getBigInt64(0, true)),
+                    status: undoView.getUint8(8),
+                });
+                break;
+
+            case MessageType.PRESENCE_UPDATE:
+                const presence = this._deserializePresence(payloadBytes);
+                if (presence) {
+                    this.onPresenceReceived(presence);
+                }
+                break;
+
+            case MessageType.POINTER_UPDATE:
+                const pointer = this._deserializePointer(payloadBytes);
+                if (pointer) {
+                    this.onPointerReceived(pointer);
+                }
+                break;
+
+            case MessageType.SESSION_STARTED:
+                const session = this._deserializeSession(payloadBytes);
+                if (session) {
+                    this.sessionId = session.sessionId;
+                    this.lastSeq = session.seq;
+                    this.onSessionStarted(session);
+                }
+                break;
+
+            // Ack body: [frameSeq int64][roomSeq int64][status byte]. frameSeq counts the
+            // state updates sent on this connection, starting at 1.
+            case MessageType.ACK:
+                if (payloadBytes.byteLength < 17) return;
+                const ackView = new DataView(payloadBytes);
+                this.onAckReceived({
+                    frameSeq: Number(ackView.getBigInt64(0, true)),
+                    roomSeq: Number(ackView.getBigInt64(8, true)),
+                    status: ackView.getUint8(16),
+                });
+                break;
+
+            // Sequenced body: [seq int64][wrapped frame].
+            case MessageType.SEQUENCED:
+                if (payloadBytes.byteLength < 9) return;
+                this.lastSeq = Number(new DataView(payloadBytes).getBigInt64(0, true));
+                this._handleReceivedMessage(payloadBytes.slice(8));
+                break;
+        }
+    }
+
+    // Operation log body: [count int32] then per operation [seq int64][authorId string]
+    // [authorName string][action byte][pageIndex int32][undoable byte][undone byte]
+    // [guidCount int32][guid 16 bytes]... Operations are numbered by room sequence number.
+    _deserializeOperationLog(data) {
+        try {
+            const reader = new BufferHandler(data);
+            const count = reader.readInt32();
+            const operations = [];
+            for (let i = 0; i < count; i++) {
+                const seq = Number(reader.dataView.getBigInt64(reader.offset, true));
+                reader.offset += 8;
+                const authorId = reader.readString();
+                const authorName = reader.readString();
+                const action = reader.readUint8();
+                const pageIndex = reader.readInt32();
+                const undoable = reader.readBoolean();
+                const undone = reader.readBoolean();
+                const guidCount = reader.readInt32();
+                const guids = [];
+                for (let j = 0; j < guidCount; j++) {
+                    const hex = Array.from(reader.readBytes(16)).map(b => b.toString(16).padStart(2, '0')).join('');
+                    guids.push(`${hex.substring(0, 8)}-${hex.substring(8, 12)}-${hex.substring(12, 16)}-${hex.substring(16, 20)}-${hex.substring(20, 32)}`);
+                }
+                operations.push({ seq, authorId, authorName, action, pageIndex, undoable, undone, guids });
+            }
+            return operations;
+        } catch (ex) {
+            console.error("Failed to deserialize operation log.", ex);
+            return null;
+        }
+    }
+
+    _deserializeConflict(data) {
+        try {
+            const reader = new BufferHandler(data);
+            const pageIndex = reader.readInt32();
+            const count = reader.readInt32();
+            const guids = [];
+            for (let i = 0; i < count; i++) {
+                const hex = Array.from(reader.readBytes(16)).map(b => b.toString(16).padStart(2, '0')).join('');
+                guids.push(`${hex.substring(0, 8)}-${hex.substring(8, 12)}-${hex.substring(12, 16)}-${hex.substring(16, 20)}-${hex.substring(20, 32)}`);
+            }
+            return { pageIndex, guids };
+        } catch (ex) {
+            console.error("Failed to deserialize conflict.", ex);
+            return null;
+        }
+    }
+
+    _deserializeLock(data) {
+        try {
+            const reader = new BufferHandler(data);
+            const holderId = reader.readString();
+            const holderName = reader.readString();
+            const locked = reader.readBoolean();
+            const count = reader.readInt32();
+            const guids = [];
+            for (let i = 0; i < count; i++) {
+                const hex = Array.from(reader.readBytes(16)).map(b => b.toString(16).padStart(2, '0')).join('');
+                guids.push(`${hex.substring(0, 8)}-${hex.substring(8, 12)}-${hex.substring(12, 16)}-${hex.substring(16, 20)}-${hex.substring(20, 32)}`);
+            }
+            return { holderId, holderName, locked, guids };
+        } catch (ex) {
+            console.error("Failed to deserialize lock.", ex);
+            return null;
+        }
+    }
+
+    // Session body: [sessionId string][seq int64][resumed byte].
+    _deserializeSession(data) {
+        try {
+            const reader = new BufferHandler(data);
+            const sessionId = reader.readString();
+            const seq = Number(reader.dataView.getBigInt64(reader.offset, true));
+            reader.offset += 8;
+            const resumed = reader.readBoolean();
+            return { sessionId, seq, resumed };
+        } catch (ex) {
+            console.error("Failed to deserialize session.", ex);
+            return null;
+        }
+    }
+
+    // Pointer body: [clientId string][pageIndex int32][x float32][y float32][kind byte].
+    _deserializePointer(data) {
+        try {
+            const reader = new BufferHandler(data);
+            return {
+                clientId: reader.readString(),
+                pageIndex: reader.readInt32(),
+                x: reader.readFloat32(),
+                y: reader.readFloat32(),
+                kind: reader.readUint8(),
+            };
+        } catch (ex) {
+            console.error("Failed to deserialize pointer update.", ex);
+            return null;
+        }
+    }
+
+    // Presence body: [kind byte][count int32] then per entry
+    // [id string][displayName string][clientType string][spectator byte][joinedAt int64 ms].
+    _deserializePresence(data) {
+        try {
+            const reader = new BufferHandler(data);
+            const kind = reader.readUint8();
+            const count = reader.readInt32();
+            const entries = [];
+            for (let i = 0; i < count; i++) {
+                const id = reader.readString();
+                const displayName = reader.readString();
+                const clientType = reader.readString();
+                const isSpectator = reader.readBoolean();
+                const joinedAt = new Date(Number(reader.dataView.getBigInt64(reader.offset, true)));
+                reader.offset += 8;
+                entries.push({ id, displayName, clientType, isSpectator, joinedAt });
+            }
+            return { kind, entries };
+        } catch (ex) {
+            console.error("Failed to deserialize presence update.", ex);
+            return null;
         }
     }
 
@@ -84,10 +339,16 @@ class NetworkManager {
 
         try {
             const payloadBytes = PayloadSerializer.serialize(payload);
-            const messageToSend = new Uint8Array(1 + payloadBytes.byteLength);
-            
-            messageToSend[0] = MessageType.STATE_UPDATE;
-            messageToSend.set(new Uint8Array(payloadBytes), 1);
+            // Name the last relayed frame we applied, so the server can drop our changes
+            // to drawables someone else moved in the meantime instead of flip-flopping.
+            const header = this.sessionId ? 1 + 8 + 1 : 1;
+            const messageToSend = new Uint8Array(header + payloadBytes.byteLength);
+            if (this.sessionId) {
+                messageToSend[0] = MessageType.BASED_ON;
+                new DataView(messageToSend.buffer).setBigInt64(1, BigInt(this.lastSeq), true);
+            }
+            messageToSend[header - 1] = MessageType.STATE_UPDATE;
+            messageToSend.set(new Uint8Array(payloadBytes), header);
 
             this.webSocket.send(messageToSend.buffer);
         } catch (ex) {
@@ -96,6 +357,81 @@ class NetworkManager {
         }
     }
 
+    // Pointer positions are relayed to the other room members but never stored.
+    // The server coalesces them, so calling this on every mouse move is fine.
+    sendPointer(pageIndex, x, y, kind = PointerKind.Cursor) {
+        if (!this.isConnected) return;
+
+        const message = new DataView(new ArrayBuffer(1 + 4 + 4 + 4 + 1));
+        message.setUint8(0, MessageType.POINTER_UPDATE);
+        message.setInt32(1, pageIndex, true);
+        message.setFloat32(5, x, true);
+        message.setFloat32(9, y, true);
+        message.setUint8(13, kind);
+        this.webSocket.send(message.buffer);
+    }
+
+    // Asks the server to keep the room open longer. The answer arrives through
+    // onRoomExtension; the server caps the step and the room's total lifetime.
+    requestRoomExtension(minutes) {
+        if (!this.isConnected) return;
+
+        const message = new DataView(new ArrayBuffer(1 + 4));
+        message.setUint8(0, MessageType.ROOM_EXTENSION);
+        message.setInt32(1, minutes, true);
+        this.webSocket.send(message.buffer);
+    }
+
+    // The answer arrives through onOperationLogReceived.
+    requestOperationLog() {
+        if (!this.isConnected) return;
+
+        this.webSocket.send(new Uint8Array([MessageType.OPERATION_LOG]).buffer);
+    }
+
+    // Undoes an operation from the room's log for everyone, identified by its room
+    // sequence number. The outcome arrives through onUndoResult.
+    requestUndo(seq) {
+        if (!this.isConnected) return;
+
+        const message = new DataView(new ArrayBuffer(1 + 8));
+        message.setUint8(0, MessageType.UNDO_OPERATION);
+        message.setBigInt64(1, BigInt(seq), true);
+        this.webSocket.send(message.buffer);
+    }
+
+    // Takes (locked = true) or releases edit locks on drawables, by GUID. While we hold
+    // a lock the server drops other clients' changes to the drawable; locks expire after
+    // 30 seconds, so take them again to keep them while editing.
+    requestLock(guids, locked = true) {
+        if (!this.isConnected || guids.length === 0) return;
+
+        const message = new DataView(new ArrayBuffer(1 + 1 + 4 + 16 * guids.length));
+        message.setUint8(0, MessageType.OBJECT_LOCK);
+        message.setUint8(1, locked ? 1 : 0);
+        message.setInt32(2, guids.length, true);
+        guids.forEach((guid, i) => {
+            const hex = guid.replace(/-/g, '');
+            for (let j = 0; j < 16; j++) {
+                message.setUint8(6 + 16 * i + j, parseInt(hex.substring(j * 2, j * 2 + 2), 16));
+            }
+        });
+        this.webSocket.send(message.buffer);
+    }
+
+    // Receive state updates only for the given pages, plus pages being added or deleted.
+    // The server sends the current state of pages that were not followed before. Pass an
+    // empty array to follow every page again.
+    subscribeToPages(pageIndices) {
+        if (!this.isConnected) return;
+
+        const message = new DataView(new ArrayBuffer(1 + 4 + 4 * pageIndices.length));
+        message.setUint8(0, MessageType.PAGE_SUBSCRIPTION);
+        message.setInt32(1, pageIndices.length, true);
+        pageIndices.forEach((pageIndex, i) => message.setInt32(5 + 4 * i, pageIndex, true));
+        this.webSocket.send(message.buffer);
+    }
+
     dispose() {
         this.disconnectAsync();
     }
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// recordingFlushInterval is how often recorded frames are written to the database.
+	recordingFlushInterval = 10 * time.Second
+	// recordingRetention is how long recordings are kept.
+	recordingRetention = 7 * 24 * time.Hour
+	// maxRecordingBytes bounds how much of a room one instance records.
+	maxRecordingBytes = 32 << 20
+	// maxReplaySpeed bounds the speed query parameter of /room/replay.
+	maxReplaySpeed = 64
+	// maxReplayGap caps the pause between two replayed frames at original
+	// speed, so breaks in a session do not stall its replay.
+	maxReplayGap = 30 * time.Second
+)
+
+// createRecordingsTableSQL stores recordings as chunks of frames, each chunk
+// the frames one instance recorded between two flushes, see encodeRecordedFrame.
+const createRecordingsTableSQL = `CREATE TABLE IF NOT EXISTS room_recording_chunks (
+	id BIGSERIAL PRIMARY KEY,
+	recording_id TEXT NOT NULL,
+	frames BYTEA NOT NULL,
+	saved_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);`
+
+// createRecordingsIndexSQL speeds up loading a recording.
+const createRecordingsIndexSQL = `CREATE INDEX IF NOT EXISTS room_recording_chunks_recording_id ON room_recording_chunks (recording_id, id)`
+
+// errBadRecording is returned for stored chunks that cannot be parsed.
+var errBadRecording = errors.New("malformed recording chunk")
+
+// recordedFrame is a relayed frame and when it was relayed.
+type recordedFrame struct {
+	at    time.Time
+	frame []byte
+}
+
+// encodeRecordedFrame appends a frame to a chunk as
+//
+//	[unixMilli int64][length int32][frame]
+//
+// all little-endian, so chunks can be concatenated.
+func encodeRecordedFrame(dst []byte, f recordedFrame) []byte {
+	dst = binary.LittleEndian.AppendUint64(dst, uint64(f.at.UnixMilli()))
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(len(f.frame)))
+	return append(dst, f.frame...)
+}
+
+// decodeRecordedFrames parses a chunk written by encodeRecordedFrame.
+func decodeRecordedFrames(chunk []byte) ([]recordedFrame, error) {
+	var frames []recordedFrame
+	for len(chunk) > 0 {
+		if len(chunk) < 12 {
+			return nil, errBadRecording
+		}
+		at := time.UnixMilli(int64(binary.LittleEndian.Uint64(chunk)))
+		size := int(binary.LittleEndian.Uint32(chunk[8:]))
+		chunk = chunk[12:]
+		if size <= 0 || size > len(chunk) {
+			return nil, errBadRecording
+		}
+		frames = append(frames, recordedFrame{at: at, frame: chunk[:size]})
+		chunk = chunk[size:]
+	}
+	return frames, nil
+}
+
+// recorder buffers the frames a room relays until they are flushed to the
+// database. A nil recorder records nothing, so rooms that are not recorded
+// need no checks.
+type recorder struct {
+	id string
+
+	mu      sync.Mutex
+	pending []byte
+	// Bytes recorded so far, flushed or not, and whether maxRecordingBytes was reached.
+	size int
+	full bool
+}
+
+func newRecorder(id string) *recorder {
+	return &recorder{id: id}
+}
+
+// add records a frame the room relayed. Only called from the room's loop.
+func (rec *recorder) add(frame []byte) {
+	if rec == nil {
+		return
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.full {
+		return
+	}
+	if rec.size+len(frame) > maxRecordingBytes {
+		slog.Warn("Recording is full, no longer recording", "recording", roomLogID(rec.id))
+		rec.full = true
+		return
+	}
+	rec.size += len(frame)
+	rec.pending = encodeRecordedFrame(rec.pending, recordedFrame{at: time.Now(), frame: frame})
+}
+
+// flush writes the pending frames to the database as one chunk.
+func (rec *recorder) flush() {
+	if rec == nil {
+		return
+	}
+	rec.mu.Lock()
+	chunk := rec.pending
+	rec.pending = nil
+	rec.mu.Unlock()
+	if len(chunk) == 0 || db == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), checkpointQueryTimeout)
+	defer cancel()
+	if _, err := db.ExecContext(ctx, "INSERT INTO room_recording_chunks (recording_id, frames) VALUES ($1, $2)", rec.id, chunk); err != nil {
+		slog.Error("Failed to save recording", "recording", roomLogID(rec.id), "error", err)
+	}
+}
+
+// flushRecordings writes the frames recorded by every live room and prunes
+// recordings past their retention.
+func (h *Hub) flushRecordings() {
+	var recorders []*recorder
+	h.roomsMux.RLock()
+	for _, room := range h.rooms {
+		if room.recorder != nil {
+			recorders = append(recorders, room.recorder)
+		}
+	}
+	h.roomsMux.RUnlock()
+	for _, rec := range recorders {
+		rec.flush()
+	}
+
+	if db == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), checkpointQueryTimeout)
+	defer cancel()
+	if _, err := db.ExecContext(ctx, "DELETE FROM room_recording_chunks WHERE saved_at < $1", time.Now().Add(-recordingRetention)); err != nil {
+		slog.Error("Failed to prune recordings", "error", err)
+	}
+}
+
+// loadRecording returns the frames of a recording in the order they were
+// relayed. Instances sharing a room each record the frames their own clients
+// sent, so chunks are merged by time.
+func loadRecording(ctx context.Context, id string) ([]recordedFrame, error) {
+	rows, err := db.QueryContext(ctx, "SELECT frames FROM room_recording_chunks WHERE recording_id = $1 ORDER BY id", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var frames []recordedFrame
+	for rows.Next() {
+		var chunk []byte
+		if err := rows.Scan(&chunk); err != nil {
+			return nil, err
+		}
+		decoded, err := decodeRecordedFrames(chunk)
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, decoded...)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	slices.SortStableFunc(frames, func(a, b recordedFrame) int { return a.at.Compare(b.at) })
+	return frames, nil
+}
+
+// handleRoomReplay replays a recording over a WebSocket, sending the recorded
+// StateUpdate frames as they were relayed, starting from an empty board.
+// With step=1 the server sends the next frame whenever the client sends a
+// message; otherwise frames follow their original timing, sped up by the
+// speed query parameter (default 1, at most maxReplaySpeed). The server
+// closes the connection once the recording ends.
+func handleRoomReplay(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/room/replay/")
+	if id == "" || db == nil {
+		http.Error(w, "Recording not found", http.StatusNotFound)
+		return
+	}
+	query := r.URL.Query()
+	step := query.Get("step") == "1"
+	speed := 1.0
+	if value := query.Get("speed"); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || !(parsed > 0 && parsed <= maxReplaySpeed) {
+			http.Error(w, "speed must be greater than 0 and at most 64", http.StatusBadRequest)
+			return
+		}
+		speed = parsed
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), checkpointQueryTimeout)
+	frames, err := loadRecording(ctx, id)
+	cancel()
+	if err != nil {
+		slog.Error("Failed to load recording", "recording", roomLogID(id), "error", err)
+		http.Error(w, "Failed to load recording", http.StatusInternalServerError)
+		return
+	}
+	if len(frames) == 0 {
+		http.Error(w, "Recording not found", http.StatusNotFound)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.Error("Failed to upgrade connection", "error", err)
+		return
+	}
+	defer conn.Close()
+	slog.Info("Replaying recording", "recording", roomLogID(id), "frames", len(frames), "speed", speed, "step", step)
+	streamRecording(conn, frames, speed, step)
+}
+
+// streamRecording sends frames to a replay viewer, see handleRoomReplay.
+func streamRecording(conn *websocket.Conn, frames []recordedFrame, speed float64, step bool) {
+	// Incoming messages only advance stepwise replays; the reader also notices
+	// when the viewer goes away.
+	next := make(chan struct{}, 1)
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		conn.SetReadLimit(maxMessageSize)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+			select {
+			case next <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	for i, f := range frames {
+		var wait <-chan time.Time
+		switch {
+		case step:
+			select {
+			case <-next:
+			case <-gone:
+				return
+			}
+		case i > 0:
+			gap := min(f.at.Sub(frames[i-1].at), maxReplayGap)
+			wait = time.After(time.Duration(float64(gap) / speed))
+		}
+		if wait != nil {
+			select {
+			case <-wait:
+			case <-gone:
+				return
+			}
+		}
+		conn.SetWriteDeadline(time.Now().Add(writeWait))
+		if err := conn.WriteMessage(websocket.BinaryMessage, f.frame); err != nil {
+			return
+		}
+	}
+	conn.SetWriteDeadline(time.Now().Add(writeWait))
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "End of recording"))
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// redisDialTimeout bounds connecting to Redis.
+	redisDialTimeout = 5 * time.Second
+	// redisIOTimeout bounds a single command on the publishing connection.
+	redisIOTimeout = 5 * time.Second
+	// redisMaxReconnectDelay caps the backoff between subscriber reconnects.
+	redisMaxReconnectDelay = 30 * time.Second
+	// redisMaxBulkLength bounds a single bulk string read from the server.
+	redisMaxBulkLength = 16 * 1024 * 1024
+)
+
+// redisBroker is a Broker speaking the Redis pub/sub protocol (RESP). It
+// keeps one connection for PUBLISH and one for SUBSCRIBE, and resubscribes
+// to every room after reconnecting.
+type redisBroker struct {
+	addr     string
+	username string
+	password string
+
+	// Publishing connection, dialed lazily.
+	pubMux    sync.Mutex
+	pub       net.Conn
+	pubReader *bufio.Reader
+
+	// Subscribing connection and the subscribers of each channel.
+	subMux sync.Mutex
+	sub    net.Conn
+	nextID int
+	subs   map[string]map[int]func([]byte)
+
+	closed chan struct{}
+}
+
+// redisError is an error reply sent by the server.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// newRedisBroker connects to the server at a redis://[user:password@]host:port URL.
+func newRedisBroker(rawURL string) (*redisBroker, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid BROKER_URL: %w", err)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	b := &redisBroker{
+		addr:   addr,
+		subs:   make(map[string]map[int]func([]byte)),
+		closed: make(chan struct{}),
+	}
+	if u.User != nil {
+		b.password, _ = u.User.Password()
+		b.username = u.User.Username()
+	}
+	// Fail fast on a bad address or credentials.
+	b.pubMux.Lock()
+	err = b.dialPublisher()
+	b.pubMux.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	go b.subscribeLoop()
+	return b, nil
+}
+
+// dial opens an authenticated connection.
+func (b *redisBroker) dial() (net.Conn, *bufio.Reader, error) {
+	conn, err := net.DialTimeout("tcp", b.addr, redisDialTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader := bufio.NewReader(conn)
+	if b.password != "" {
+		args := []string{"AUTH", b.password}
+		if b.username != "" {
+			args = []string{"AUTH", b.username, b.password}
+		}
+		conn.SetDeadline(time.Now().Add(redisIOTimeout))
+		err := writeRedisCommand(conn, args...)
+		if err == nil {
+			_, err = readRedisReply(reader)
+		}
+		if err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+		conn.SetDeadline(time.Time{})
+	}
+	return conn, reader, nil
+}
+
+// dialPublisher (re)opens the publishing connection. The caller must hold pubMux.
+func (b *redisBroker) dialPublisher() error {
+	conn, reader, err := b.dial()
+	if err != nil {
+		return err
+	}
+	b.pub, b.pubReader = conn, reader
+	return nil
+}
+
+func (b *redisBroker) Publish(room string, msg []byte) error {
+	b.pubMux.Lock()
+	defer b.pubMux.Unlock()
+	// Retry once on a fresh connection if the old one went away.
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if b.pub == nil {
+			if err = b.dialPublisher(); err != nil {
+				return err
+			}
+		}
+		b.pub.SetDeadline(time.Now().Add(redisIOTimeout))
+		if err = writeRedisCommand(b.pub, "PUBLISH", room, string(msg)); err == nil {
+			_, err = readRedisReply(b.pubReader)
+		}
+		var replyErr redisError
+		if err == nil || errors.As(err, &replyErr) {
+			return err
+		}
+		b.pub.Close()
+		b.pub = nil
+	}
+	return err
+}
+
+func (b *redisBroker) Subscribe(room string, deliver func([]byte)) (func(), error) {
+	b.subMux.Lock()
+	defer b.subMux.Unlock()
+	b.nextID++
+	id := b.nextID
+	if b.subs[room] == nil {
+		b.subs[room] = make(map[int]func([]byte))
+		if b.sub != nil {
+			// On failure the subscribe loop reconnects and subscribes again.
+			writeRedisCommand(b.sub, "SUBSCRIBE", room)
+		}
+	}
+	b.subs[room][id] = deliver
+	return func() {
+		b.subMux.Lock()
+		defer b.subMux.Unlock()
+		delete(b.subs[room], id)
+		if len(b.subs[room]) == 0 {
+			delete(b.subs, room)
+			if b.sub != nil {
+				writeRedisCommand(b.sub, "UNSUBSCRIBE", room)
+			}
+		}
+	}, nil
+}
+
+func (b *redisBroker) Close() error {
+	close(b.closed)
+	b.pubMux.Lock()
+	if b.pub != nil {
+		b.pub.Close()
+	}
+	b.pubMux.Unlock()
+	b.subMux.Lock()
+	if b.sub != nil {
+		b.sub.Close()
+	}
+	b.subMux.Unlock()
+	return nil
+}
+
+// subscribeLoop keeps the subscribing connection open and dispatches messages.
+func (b *redisBroker) subscribeLoop() {
+	delay := time.Second
+	for {
+		subscribed, err := b.receive()
+		if subscribed {
+			// The connection worked; back off from scratch if it drops.
+			delay = time.Second
+		}
+		select {
+		case <-b.closed:
+			return
+		default:
+		}
+		slog.Error("Lost connection to Redis broker, reconnecting", "error", err, "delay", delay)
+		time.Sleep(delay)
+		delay = min(delay*2, redisMaxReconnectDelay)
+	}
+}
+
+// receive connects, subscribes to every room with subscribers and dispatches
+// messages until the connection fails. It reports whether it got as far as
+// subscribing.
+func (b *redisBroker) receive() (subscribed bool, err error) {
+	conn, reader, err := b.dial()
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	b.subMux.Lock()
+	b.sub = conn
+	for room := range b.subs {
+		if err := writeRedisCommand(conn, "SUBSCRIBE", room); err != nil {
+			b.sub = nil
+			b.subMux.Unlock()
+			return false, err
+		}
+	}
+	b.subMux.Unlock()
+	defer func() {
+		b.subMux.Lock()
+		b.sub = nil
+		b.subMux.Unlock()
+	}()
+
+	for {
+		reply, err := readRedisReply(reader)
+		if err != nil {
+			return true, err
+		}
+		// Pushed messages look like ["message", channel, payload].
+		parts, ok := reply.([]any)
+		if !ok || len(parts) != 3 {
+			continue
+		}
+		kind, _ := parts[0].([]byte)
+		channel, _ := parts[1].([]byte)
+		payload, _ := parts[2].([]byte)
+		if string(kind) != "message" {
+			continue
+		}
+		b.subMux.Lock()
+		delivers := make([]func([]byte), 0, len(b.subs[string(channel)]))
+		for _, deliver := range b.subs[string(channel)] {
+			delivers = append(delivers, deliver)
+		}
+		b.subMux.Unlock()
+		for _, deliver := range delivers {
+			deliver(payload)
+		}
+	}
+}
+
+// writeRedisCommand writes a command as a RESP array of bulk strings.
+func writeRedisCommand(w io.Writer, args ...string) error {
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		buf = append(buf, "$"+strconv.Itoa(len(arg))+"\r\n"...)
+		buf = append(buf, arg...)
+		buf = append(buf, "\r\n"...)
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// readRedisReply reads one RESP reply. Simple strings and bulk strings are
+// returned as []byte, integers as int64, arrays as []any and error replies
+// as a redisError.
+func readRedisReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("redis: malformed reply")
+	}
+	kind, rest := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return []byte(rest), nil
+	case '-':
+		return nil, redisError(rest)
+	case ':':
+		return strconv.ParseInt(rest, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(rest)
+		if err != nil || n > redisMaxBulkLength {
+			return nil, errors.New("redis: bad bulk length")
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(rest)
+		if err != nil || n > 1024 {
+			return nil, errors.New("redis: bad array length")
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = readRedisReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply type %q", kind)
+}
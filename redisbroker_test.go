@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis speaks just enough RESP for redisBroker: AUTH, PUBLISH,
+// SUBSCRIBE and UNSUBSCRIBE.
+type fakeRedis struct {
+	ln       net.Listener
+	password string
+
+	// Guards conns and subs, and every write so pushed messages never
+	// interleave with replies.
+	mu    sync.Mutex
+	conns map[net.Conn]bool
+	subs  map[net.Conn]map[string]bool
+	// Receives the channel of every SUBSCRIBE.
+	subscribed chan string
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() error = %v", err)
+	}
+	s := &fakeRedis{
+		ln:         ln,
+		password:   password,
+		conns:      make(map[net.Conn]bool),
+		subs:       make(map[net.Conn]map[string]bool),
+		subscribed: make(chan string, 16),
+	}
+	t.Cleanup(func() {
+		ln.Close()
+		s.dropConnections()
+	})
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns[conn] = true
+			s.mu.Unlock()
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+// dropConnections closes every client connection, as a restarting server would.
+func (s *fakeRedis) dropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func (s *fakeRedis) serve(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		delete(s.subs, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+	reader := bufio.NewReader(conn)
+	authenticated := s.password == ""
+	for {
+		reply, err := readRedisReply(reader)
+		if err != nil {
+			return
+		}
+		parts, _ := reply.([]any)
+		args := make([]string, len(parts))
+		for i, part := range parts {
+			b, _ := part.([]byte)
+			args[i] = string(b)
+		}
+		if len(args) == 0 {
+			return
+		}
+		s.mu.Lock()
+		switch {
+		case args[0] == "AUTH":
+			if args[len(args)-1] == s.password {
+				authenticated = true
+				conn.Write([]byte("+OK\r\n"))
+			} else {
+				conn.Write([]byte("-WRONGPASS invalid password\r\n"))
+			}
+		case !authenticated:
+			conn.Write([]byte("-NOAUTH Authentication required.\r\n"))
+		case args[0] == "SUBSCRIBE" && len(args) == 2:
+			if s.subs[conn] == nil {
+				s.subs[conn] = make(map[string]bool)
+			}
+			s.subs[conn][args[1]] = true
+			writeRedisCommand(conn, "subscribe", args[1])
+			s.subscribed <- args[1]
+		case args[0] == "UNSUBSCRIBE" && len(args) == 2:
+			delete(s.subs[conn], args[1])
+		case args[0] == "PUBLISH" && len(args) == 3:
+			receivers := 0
+			for sub, channels := range s.subs {
+				if channels[args[1]] {
+					writeRedisCommand(sub, "message", args[1], args[2])
+					receivers++
+				}
+			}
+			conn.Write([]byte(":" + strconv.Itoa(receivers) + "\r\n"))
+		default:
+			conn.Write([]byte("-ERR unknown command\r\n"))
+		}
+		s.mu.Unlock()
+	}
+}
+
+// waitSubscribed waits for the broker to subscribe to channel.
+func (s *fakeRedis) waitSubscribed(t *testing.T, channel string) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case got := <-s.subscribed:
+			if got == channel {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("broker did not subscribe to %q", channel)
+		}
+	}
+}
+
+// expectMessage waits for a message delivered to a subscriber.
+func expectMessage(t *testing.T, got <-chan []byte, want string) {
+	t.Helper()
+	select {
+	case msg := <-got:
+		if string(msg) != want {
+			t.Errorf("delivered %q, want %q", msg, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%q was not delivered", want)
+	}
+}
+
+func TestRedisBroker(t *testing.T) {
+	s := newFakeRedis(t, "secret")
+	b, err := newRedisBroker("redis://:secret@" + s.ln.Addr().String())
+	if err != nil {
+		t.Fatalf("newRedisBroker() error = %v", err)
+	}
+	defer b.Close()
+
+	got := make(chan []byte, 4)
+	cancel, err := b.Subscribe("room", func(msg []byte) { got <- msg })
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	defer cancel()
+	s.waitSubscribed(t, "room")
+	if err := b.Publish("room", []byte("hello")); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	expectMessage(t, got, "hello")
+
+	// After the server drops both connections the broker resubscribes, and
+	// publishing retries on a fresh connection.
+	s.dropConnections()
+	s.waitSubscribed(t, "room")
+	if err := b.Publish("room", []byte("again")); err != nil {
+		t.Fatalf("Publish() after reconnect error = %v", err)
+	}
+	expectMessage(t, got, "again")
+}
+
+func TestRedisBrokerWrongPassword(t *testing.T) {
+	s := newFakeRedis(t, "secret")
+	if _, err := newRedisBroker("redis://:wrong@" + s.ln.Addr().String()); err == nil {
+		t.Fatal("newRedisBroker() with a wrong password succeeded")
+	}
+}
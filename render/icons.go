@@ -0,0 +1,170 @@
+package render
+
+import (
+	"image"
+	"image/draw"
+	_ "image/jpeg"
+	_ "image/png"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+)
+
+// svgAlternatives names bundled raster images to draw in place of SVG icons,
+// which the renderer cannot rasterize. SVG icons not listed here fall back
+// to a raster file with the same base name, if there is one.
+var svgAlternatives = map[string]string{
+	"prox_aoe.svg": "circle aoe.jpg",
+}
+
+// iconTypes are the MIME types of the icon files by extension.
+var iconTypes = map[string]string{
+	".svg":  "image/svg+xml",
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+}
+
+// IconSet loads the bundled icons that image drawables refer to. Loaded
+// icons are kept for the life of the set, keyed by file, so the caches grow
+// no larger than the icons directory.
+type IconSet struct {
+	fsys fs.FS
+	// bundled holds the names of the files in fsys.
+	bundled map[string]bool
+
+	mu    sync.Mutex
+	icons map[string]*image.NRGBA
+	files map[string]iconData
+}
+
+// iconData is an icon file as stored.
+type iconData struct {
+	data        []byte
+	contentType string
+}
+
+// NewIconSet returns an IconSet reading icons from fsys, the icons directory
+// of the web client. The files are listed once; files added later are not
+// found.
+func NewIconSet(fsys fs.FS) *IconSet {
+	bundled := make(map[string]bool)
+	entries, _ := fs.ReadDir(fsys, ".")
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			bundled[entry.Name()] = true
+		}
+	}
+	return &IconSet{fsys: fsys, bundled: bundled, icons: make(map[string]*image.NRGBA), files: make(map[string]iconData)}
+}
+
+// Icon returns the icon a pluginResourcePath (like
+// "PluginImages.toolbar.Tank.JPG") refers to, or nil if it is not bundled.
+// Emoji and images from URLs are never bundled.
+func (s *IconSet) Icon(pluginResourcePath string) *image.NRGBA {
+	if s == nil {
+		return nil
+	}
+	// The renderer cannot rasterize SVG, so only raster files are drawn.
+	file := s.find(iconFile(pluginResourcePath), func(candidate string) bool {
+		return !strings.EqualFold(path.Ext(candidate), ".svg")
+	})
+	if file == "" {
+		return nil
+	}
+	s.mu.Lock()
+	icon, loaded := s.icons[file]
+	s.mu.Unlock()
+	if loaded {
+		return icon
+	}
+	icon = s.load(file)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.icons[file] = icon
+	return icon
+}
+
+// find returns the first bundled candidate file for the icon called name
+// that accepts, or "" if there is none.
+func (s *IconSet) find(name string, accept func(candidate string) bool) string {
+	if name == "" {
+		return ""
+	}
+	for _, candidate := range iconCandidates(name) {
+		if candidate != "" && s.bundled[candidate] && accept(candidate) {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// iconFile returns the file name a pluginResourcePath refers to.
+func iconFile(pluginResourcePath string) string {
+	for _, prefix := range []string{"PluginImages.toolbar.", "PluginImages.svg."} {
+		if name, ok := strings.CutPrefix(pluginResourcePath, prefix); ok {
+			if name == "" || strings.ContainsAny(name, `/\`) || !fs.ValidPath(name) {
+				return ""
+			}
+			return name
+		}
+	}
+	return ""
+}
+
+// iconCandidates lists the files that may hold the icon called name, best
+// first. The bundled files do not spell their extensions consistently, and
+// SVG icons may have raster alternatives.
+func iconCandidates(name string) []string {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	if strings.EqualFold(ext, ".svg") {
+		return []string{name, svgAlternatives[name], base + ".png", base + ".jpg", base + ".JPG"}
+	}
+	return []string{name, base + strings.ToLower(ext), base + strings.ToUpper(ext)}
+}
+
+// load decodes a raster icon file, or returns nil if it cannot be decoded.
+func (s *IconSet) load(file string) *image.NRGBA {
+	f, err := s.fsys.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil || img.Bounds().Empty() {
+		return nil
+	}
+	nrgba := image.NewNRGBA(img.Bounds())
+	draw.Draw(nrgba, nrgba.Rect, img, img.Bounds().Min, draw.Src)
+	return nrgba
+}
+
+// File returns the contents and MIME type of the file holding the icon a
+// pluginResourcePath refers to, SVG included, or nil if it is not bundled.
+func (s *IconSet) File(pluginResourcePath string) ([]byte, string) {
+	if s == nil {
+		return nil, ""
+	}
+	file := s.find(iconFile(pluginResourcePath), func(candidate string) bool {
+		return iconTypes[strings.ToLower(path.Ext(candidate))] != ""
+	})
+	if file == "" {
+		return nil, ""
+	}
+	s.mu.Lock()
+	icon, loaded := s.files[file]
+	s.mu.Unlock()
+	if !loaded {
+		data, err := fs.ReadFile(s.fsys, file)
+		if err != nil {
+			return nil, ""
+		}
+		icon = iconData{data: data, contentType: iconTypes[strings.ToLower(path.Ext(file))]}
+		s.mu.Lock()
+		s.files[file] = icon
+		s.mu.Unlock()
+	}
+	return icon.data, icon.contentType
+}
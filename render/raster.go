@@ -0,0 +1,251 @@
+package render
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"slices"
+)
+
+// subsamples is the number of scanlines sampled per pixel row. Horizontal
+// coverage is computed exactly.
+const subsamples = 4
+
+// vec is a point in image space, or in page space before the viewport is applied.
+type vec struct {
+	x, y float64
+}
+
+// rgba is a straight-alpha color with components in [0, 1].
+type rgba struct {
+	r, g, b, a float64
+}
+
+// edge is a non-horizontal polygon edge, oriented top to bottom. dir is +1
+// for edges that went down in the original path and -1 for edges that went up.
+type edge struct {
+	x0, y0, x1, y1 float64
+	dir            int
+}
+
+// canvas rasterizes filled paths and images onto an opaque RGBA image.
+type canvas struct {
+	img *image.RGBA
+	// Coverage of the pixel row being filled, reused between fills.
+	cover []float64
+}
+
+func newCanvas(width, height int, background color.RGBA) *canvas {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = background.R, background.G, background.B, 255
+	}
+	return &canvas{img: img, cover: make([]float64, width+1)}
+}
+
+// fill paints the union of paths with c, anti-aliased. Every path is a closed
+// polygon; paths are oriented the same way before filling, so overlapping
+// paths do not cancel out, while a path that winds back over itself (see ring)
+// leaves a hole.
+func (cv *canvas) fill(paths [][]vec, c rgba) {
+	if c.a <= 0 {
+		return
+	}
+	bounds := cv.img.Bounds()
+	var edges []edge
+	minY, maxY := math.Inf(1), math.Inf(-1)
+	for _, path := range paths {
+		if len(path) < 3 || slices.ContainsFunc(path, func(p vec) bool { return !finite(p) }) {
+			continue
+		}
+		sign := 1
+		if signedArea(path) < 0 {
+			sign = -1
+		}
+		for i, p := range path {
+			q := path[(i+1)%len(path)]
+			if p.y == q.y {
+				continue
+			}
+			e := edge{p.x, p.y, q.x, q.y, sign}
+			if p.y > q.y {
+				e = edge{q.x, q.y, p.x, p.y, -sign}
+			}
+			edges = append(edges, e)
+			minY, maxY = min(minY, e.y0), max(maxY, e.y1)
+		}
+	}
+	if len(edges) == 0 {
+		return
+	}
+	slices.SortFunc(edges, func(a, b edge) int {
+		switch {
+		case a.y0 < b.y0:
+			return -1
+		case a.y0 > b.y0:
+			return 1
+		}
+		return 0
+	})
+
+	rowStart := max(int(math.Floor(minY)), bounds.Min.Y)
+	rowEnd := min(int(math.Ceil(maxY)), bounds.Max.Y)
+	width := bounds.Dx()
+	var active []edge
+	next := 0
+	type crossing struct {
+		x   float64
+		dir int
+	}
+	var crossings []crossing
+	for row := rowStart; row < rowEnd; row++ {
+		clear(cv.cover)
+		touched := false
+		for sub := 0; sub < subsamples; sub++ {
+			sy := float64(row) + (float64(sub)+0.5)/subsamples
+			for next < len(edges) && edges[next].y0 <= sy {
+				active = append(active, edges[next])
+				next++
+			}
+			active = slices.DeleteFunc(active, func(e edge) bool { return e.y1 <= sy })
+			crossings = crossings[:0]
+			for _, e := range active {
+				x := e.x0 + (sy-e.y0)*(e.x1-e.x0)/(e.y1-e.y0)
+				crossings = append(crossings, crossing{x, e.dir})
+			}
+			slices.SortFunc(crossings, func(a, b crossing) int {
+				switch {
+				case a.x < b.x:
+					return -1
+				case a.x > b.x:
+					return 1
+				}
+				return 0
+			})
+			// Non-zero winding: inside from where the winding leaves zero until it returns.
+			winding, start := 0, 0.0
+			for _, cr := range crossings {
+				if winding == 0 {
+					start = cr.x
+				}
+				winding += cr.dir
+				if winding == 0 {
+					cv.addSpan(start, cr.x, width)
+					touched = true
+				}
+			}
+		}
+		if touched {
+			cv.blendRow(row, c)
+		}
+	}
+}
+
+// addSpan adds a subsample's coverage of [x0, x1) to the row.
+func (cv *canvas) addSpan(x0, x1 float64, width int) {
+	const weight = 1.0 / subsamples
+	x0 = max(x0, 0)
+	x1 = min(x1, float64(width))
+	if x1 <= x0 {
+		return
+	}
+	i0, i1 := int(x0), int(x1)
+	if i0 == i1 {
+		cv.cover[i0] += (x1 - x0) * weight
+		return
+	}
+	cv.cover[i0] += (float64(i0+1) - x0) * weight
+	for i := i0 + 1; i < i1; i++ {
+		cv.cover[i] += weight
+	}
+	cv.cover[i1] += (x1 - float64(i1)) * weight
+}
+
+func (cv *canvas) blendRow(row int, c rgba) {
+	width := cv.img.Bounds().Dx()
+	for x := 0; x < width; x++ {
+		if cv.cover[x] > 0 {
+			cv.blend(x, row, c, min(cv.cover[x], 1))
+		}
+	}
+}
+
+// blend composites c over the pixel at x, y with the given coverage.
+func (cv *canvas) blend(x, y int, c rgba, coverage float64) {
+	alpha := c.a * coverage
+	i := cv.img.PixOffset(x, y)
+	pix := cv.img.Pix[i : i+3 : i+3]
+	pix[0] = uint8(c.r*255*alpha + float64(pix[0])*(1-alpha) + 0.5)
+	pix[1] = uint8(c.g*255*alpha + float64(pix[1])*(1-alpha) + 0.5)
+	pix[2] = uint8(c.b*255*alpha + float64(pix[2])*(1-alpha) + 0.5)
+}
+
+// drawImage paints src scaled to width by height, centered on center and
+// rotated by rotation radians, sampling it bilinearly.
+func (cv *canvas) drawImage(src *image.NRGBA, center vec, width, height, rotation float64) {
+	if !(width > 0 && height > 0) || !finite(center) || math.IsNaN(rotation) {
+		return
+	}
+	corners := quad(center, width/2, height/2, rotation)
+	minX, minY, maxX, maxY := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	for _, p := range corners {
+		minX, minY, maxX, maxY = min(minX, p.x), min(minY, p.y), max(maxX, p.x), max(maxY, p.y)
+	}
+	bounds := cv.img.Bounds()
+	x0, y0 := max(int(math.Floor(minX)), bounds.Min.X), max(int(math.Floor(minY)), bounds.Min.Y)
+	x1, y1 := min(int(math.Ceil(maxX)), bounds.Max.X), min(int(math.Ceil(maxY)), bounds.Max.Y)
+	sw, sh := float64(src.Rect.Dx()), float64(src.Rect.Dy())
+	cos, sin := math.Cos(-rotation), math.Sin(-rotation)
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			dx, dy := float64(x)+0.5-center.x, float64(y)+0.5-center.y
+			u := (dx*cos-dy*sin)/width + 0.5
+			v := (dx*sin+dy*cos)/height + 0.5
+			if u < 0 || u >= 1 || v < 0 || v >= 1 {
+				continue
+			}
+			c := sample(src, u*sw-0.5, v*sh-0.5)
+			if c.a > 0 {
+				cv.blend(x, y, c, 1)
+			}
+		}
+	}
+}
+
+// sample reads src at a fractional pixel position, clamping at the edges.
+func sample(src *image.NRGBA, fx, fy float64) rgba {
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	x0, y0 := int(math.Floor(fx)), int(math.Floor(fy))
+	tx, ty := fx-float64(x0), fy-float64(y0)
+	var out rgba
+	for _, s := range [4]struct {
+		dx, dy int
+		w      float64
+	}{{0, 0, (1 - tx) * (1 - ty)}, {1, 0, tx * (1 - ty)}, {0, 1, (1 - tx) * ty}, {1, 1, tx * ty}} {
+		px, py := min(max(x0+s.dx, 0), w-1), min(max(y0+s.dy, 0), h-1)
+		p := src.Pix[src.PixOffset(src.Rect.Min.X+px, src.Rect.Min.Y+py):]
+		a := float64(p[3]) / 255 * s.w
+		// Accumulate premultiplied, so transparent pixels do not bleed their color.
+		out.r += float64(p[0]) / 255 * a
+		out.g += float64(p[1]) / 255 * a
+		out.b += float64(p[2]) / 255 * a
+		out.a += a
+	}
+	if out.a > 0 {
+		out.r, out.g, out.b = out.r/out.a, out.g/out.a, out.b/out.a
+	}
+	return out
+}
+
+func signedArea(path []vec) float64 {
+	area := 0.0
+	for i, p := range path {
+		q := path[(i+1)%len(path)]
+		area += p.x*q.y - q.x*p.y
+	}
+	return area / 2
+}
+
+func finite(p vec) bool {
+	return !math.IsNaN(p.x) && !math.IsInf(p.x, 0) && !math.IsNaN(p.y) && !math.IsInf(p.y, 0)
+}
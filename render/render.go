@@ -0,0 +1,390 @@
+// Package render draws plan pages as images, the way the web client's
+// CanvasManager draws them on its Konva stage: a dark background with a
+// grid, and each drawable on top in page order.
+package render
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/rail2025/AetherDraw-Server/drawable"
+)
+
+const (
+	// DefaultWidth is the image width Page uses when asked for none.
+	DefaultWidth = 800
+	// MinWidth and MaxWidth bound the image width Page draws.
+	MinWidth = 16
+	MaxWidth = 2048
+	// maxHeight bounds the image height; taller pages are drawn narrower.
+	maxHeight = 4096
+
+	// The smallest area of a page that is drawn, so a few drawables near the
+	// origin are shown at their size in the app rather than blown up.
+	minPageWidth  = 800
+	minPageHeight = 600
+	// margin is the space kept around drawables outside the minimum area.
+	margin = 20
+	// gridSize is the grid spacing of CanvasManager._drawGrid.
+	gridSize = 40
+	// minGridSpacing is the closest, in pixels, grid lines are drawn.
+	minGridSpacing = 4
+	// maxDashes bounds the dashes drawn for a dashed line; lines that would
+	// need more are drawn solid.
+	maxDashes = 10000
+)
+
+var (
+	backgroundColor = color.RGBA{0x26, 0x26, 0x2b, 0xff}
+	gridColor       = rgba{0x4d / 255.0, 0x4d / 255.0, 0x4d / 255.0, 1}
+)
+
+// item is something to draw, in page space: filled paths, or an image.
+// Images without an icon only count towards the page's bounds.
+type item struct {
+	paths [][]vec
+	color rgba
+
+	icon                    *image.NRGBA
+	center                  vec
+	width, height, rotation float64
+}
+
+// Page draws drawables into an image width pixels wide, clamped to
+// [MinWidth, MaxWidth]. The image covers the page's bounds, keeping their
+// aspect ratio. Image drawables are drawn with icons; those it does
+// not have are drawn as dots in the drawable's color.
+func Page(drawables []*drawable.Drawable, width int, icons *IconSet) *image.RGBA {
+	width = min(max(width, MinWidth), MaxWidth)
+	var items []item
+	for _, d := range drawables {
+		items = append(items, drawableItems(d, icons)...)
+	}
+
+	minX, minY, maxX, maxY := bounds(items)
+	scale := float64(width) / (maxX - minX)
+	height := int(math.Ceil((maxY - minY) * scale))
+	if height > maxHeight {
+		scale = maxHeight / (maxY - minY)
+		width, height = max(int(math.Ceil((maxX-minX)*scale)), 1), maxHeight
+	}
+	height = max(height, 1)
+	toImage := func(p vec) vec {
+		return vec{(p.x - minX) * scale, (p.y - minY) * scale}
+	}
+
+	cv := newCanvas(width, height, backgroundColor)
+	// Pages zoomed out so far that the grid would be a blur are drawn without it.
+	if gridSize*scale >= minGridSpacing {
+		var grid [][]vec
+		for x := math.Ceil(minX/gridSize) * gridSize; x <= maxX; x += gridSize {
+			grid = append(grid, rect(toImage(vec{x, 0}).x, 0, 1, float64(height)))
+		}
+		for y := math.Ceil(minY/gridSize) * gridSize; y <= maxY; y += gridSize {
+			grid = append(grid, rect(0, toImage(vec{0, y}).y, float64(width), 1))
+		}
+		cv.fill(grid, gridColor)
+	}
+
+	for _, it := range items {
+		if it.icon != nil {
+			cv.drawImage(it.icon, toImage(it.center), it.width*scale, it.height*scale, it.rotation)
+			continue
+		}
+		paths := make([][]vec, len(it.paths))
+		for i, path := range it.paths {
+			paths[i] = make([]vec, len(path))
+			for j, p := range path {
+				paths[i][j] = toImage(p)
+			}
+		}
+		cv.fill(paths, it.color)
+	}
+	return cv.img
+}
+
+// bounds returns the area of a page to draw: the items with a margin around
+// them, and at least the area from the origin to minPageWidth by minPageHeight.
+func bounds(items []item) (minX, minY, maxX, maxY float64) {
+	minX, minY, maxX, maxY = 0, 0, minPageWidth, minPageHeight
+	extend := func(p vec) {
+		if finite(p) {
+			minX, minY = min(minX, p.x-margin), min(minY, p.y-margin)
+			maxX, maxY = max(maxX, p.x+margin), max(maxY, p.y+margin)
+		}
+	}
+	for _, it := range items {
+		if it.width > 0 && it.height > 0 {
+			for _, p := range quad(it.center, it.width/2, it.height/2, it.rotation) {
+				extend(p)
+			}
+		}
+		for _, path := range it.paths {
+			for _, p := range path {
+				extend(p)
+			}
+		}
+	}
+	return minX, minY, maxX, maxY
+}
+
+// drawableItems returns what to draw for d, following
+// CanvasManager._createShapeFromDrawable. Lines are always stroked; closed
+// shapes are filled or outlined depending on IsFilled.
+func drawableItems(d *drawable.Drawable, icons *IconSet) []item {
+	c := rgba{clamp01(d.Color.R), clamp01(d.Color.G), clamp01(d.Color.B), clamp01(d.Color.A)}
+	thickness := float64(d.Thickness)
+	shape := func(outline []vec) []item {
+		if d.IsFilled {
+			return []item{{paths: [][]vec{outline}, color: c}}
+		}
+		return []item{{paths: stroke(outline, thickness, true), color: c}}
+	}
+
+	switch body := d.Body.(type) {
+	case *drawable.PathBody:
+		return []item{{paths: stroke(points(body.Points), thickness, false), color: c}}
+	case *drawable.LineBody:
+		return []item{{paths: stroke([]vec{pt(body.Start), pt(body.End)}, thickness, false), color: c}}
+	case *drawable.DashBody:
+		return []item{{paths: dash(points(body.Points), thickness, float64(body.DashLength), float64(body.GapLength)), color: c}}
+	case *drawable.RectangleBody:
+		start, end := pt(body.Start), pt(body.End)
+		center := vec{(start.x + end.x) / 2, (start.y + end.y) / 2}
+		return shape(quad(center, math.Abs(end.x-start.x)/2, math.Abs(end.y-start.y)/2, float64(body.Rotation)))
+	case *drawable.CircleBody:
+		center, radius := pt(body.Center), float64(body.Radius)
+		if d.IsFilled {
+			return []item{{paths: [][]vec{circle(center, radius)}, color: c}}
+		}
+		return []item{{paths: [][]vec{ring(center, radius-thickness/2, radius+thickness/2)}, color: c}}
+	case *drawable.ConeBody:
+		return shape(coneVertices(body))
+	case *drawable.TriangleBody:
+		return shape(points(body.Vertices[:]))
+	case *drawable.ArrowBody:
+		return arrowItems(body, thickness, c)
+	case *drawable.TextBody:
+		unit, maxChars := textMetrics(body)
+		return []item{{paths: textPaths(body.Text, pt(body.Position), unit, maxChars), color: c}}
+	case *drawable.ImageBody:
+		center, w, h := pt(body.Position), float64(body.Width), float64(body.Height)
+		image := item{icon: icons.Icon(body.PluginResourcePath), center: center, width: w, height: h, rotation: float64(body.Rotation)}
+		if image.icon == nil && iconFile(body.PluginResourcePath) != "" {
+			// An icon the server does not have; emoji and images from URLs are not drawn at all.
+			return []item{image, {paths: [][]vec{circle(center, min(math.Abs(w), math.Abs(h))/4)}, color: c}}
+		}
+		return []item{image}
+	}
+	return nil
+}
+
+// textMetrics returns the size of a font pixel for b, and how many
+// characters fit on a line, 0 for no limit.
+func textMetrics(b *drawable.TextBody) (unit float64, maxChars int) {
+	// DrawableText keeps font sizes at 1 or more.
+	fontSize := float64(b.FontSize)
+	if !(fontSize >= 1) {
+		fontSize = 1
+	}
+	unit = fontSize / lineUnits
+	if wrap := float64(b.WrappingWidth) / (glyphAdvance * unit); wrap > 0 && wrap < math.MaxInt32 {
+		maxChars = max(int(wrap), 1)
+	}
+	return unit, maxChars
+}
+
+// coneVertices mirrors DrawableCone.getVertices.
+func coneVertices(b *drawable.ConeBody) []vec {
+	const coneWidthFactor = 0.3
+	apex, base := pt(b.Apex), pt(b.BaseCenter)
+	v := vec{base.x - apex.x, base.y - apex.y}
+	height := math.Hypot(v.x, v.y)
+	if height < 0.1 {
+		return nil
+	}
+	half := height * coneWidthFactor
+	perp := vec{v.y / height * half, -v.x / height * half}
+	b1 := vec{base.x + perp.x - apex.x, base.y + perp.y - apex.y}
+	b2 := vec{base.x - perp.x - apex.x, base.y - perp.y - apex.y}
+	cos, sin := math.Cos(float64(b.Rotation)), math.Sin(float64(b.Rotation))
+	return []vec{apex, rotate(b1, cos, sin, apex), rotate(b2, cos, sin, apex)}
+}
+
+// arrowItems mirrors DrawableArrow.getTransformedVertices: a stroked shaft
+// and a filled head, rotated around the start point.
+func arrowItems(b *drawable.ArrowBody, thickness float64, c rgba) []item {
+	const minAbsoluteDim = 5.0
+	start, end := pt(b.Start), pt(b.End)
+	shaft := vec{end.x - start.x, end.y - start.y}
+	length := math.Hypot(shaft.x, shaft.y)
+	if length*length < 0.01 {
+		return nil
+	}
+	dir := vec{shaft.x / length, shaft.y / length}
+	headLength := max(minAbsoluteDim, float64(b.ArrowheadLengthOffset))
+	headHalfWidth := max(minAbsoluteDim/2, thickness*float64(b.ArrowheadWidthScale)/2)
+	tip := vec{shaft.x + dir.x*headLength, shaft.y + dir.y*headLength}
+	perp := vec{dir.y * headHalfWidth, -dir.x * headHalfWidth}
+	base1 := vec{shaft.x + perp.x, shaft.y + perp.y}
+	base2 := vec{shaft.x - perp.x, shaft.y - perp.y}
+
+	cos, sin := math.Cos(float64(b.Rotation)), math.Sin(float64(b.Rotation))
+	return []item{
+		{paths: stroke([]vec{start, rotate(shaft, cos, sin, start)}, thickness, false), color: c},
+		{paths: [][]vec{{rotate(tip, cos, sin, start), rotate(base1, cos, sin, start), rotate(base2, cos, sin, start)}}, color: c},
+	}
+}
+
+// stroke returns paths covering a line of the given width through points,
+// with round caps and joins.
+func stroke(points []vec, width float64, closed bool) [][]vec {
+	if width <= 0 || len(points) == 0 {
+		return nil
+	}
+	half := width / 2
+	var paths [][]vec
+	for i, p := range points {
+		// Joins are small and numerous, so they get fewer sides than circles.
+		paths = append(paths, polygon(p, half, 32))
+		var q vec
+		switch {
+		case i+1 < len(points):
+			q = points[i+1]
+		case closed && len(points) > 2:
+			q = points[0]
+		default:
+			continue
+		}
+		length := math.Hypot(q.x-p.x, q.y-p.y)
+		if length == 0 {
+			continue
+		}
+		n := vec{(p.y - q.y) / length * half, (q.x - p.x) / length * half}
+		paths = append(paths, []vec{{p.x + n.x, p.y + n.y}, {q.x + n.x, q.y + n.y}, {q.x - n.x, q.y - n.y}, {p.x - n.x, p.y - n.y}})
+	}
+	return paths
+}
+
+// dash returns paths covering a dashed line through points, dashes and gaps
+// measured along the line like a Konva.Line dash array.
+func dash(points []vec, width, dashLength, gapLength float64) [][]vec {
+	total := 0.0
+	for i := 0; i+1 < len(points); i++ {
+		total += math.Hypot(points[i+1].x-points[i].x, points[i+1].y-points[i].y)
+	}
+	if !(dashLength > 0 && gapLength >= 0) || total/(dashLength+gapLength) > maxDashes {
+		return stroke(points, width, false)
+	}
+	var paths [][]vec
+	var current []vec
+	on, remaining := true, dashLength
+	if len(points) > 0 {
+		current = []vec{points[0]}
+	}
+	for i := 0; i+1 < len(points); i++ {
+		p, q := points[i], points[i+1]
+		length := math.Hypot(q.x-p.x, q.y-p.y)
+		for length > 0 {
+			step := min(remaining, length)
+			t := step / length
+			p = vec{p.x + (q.x-p.x)*t, p.y + (q.y-p.y)*t}
+			length -= step
+			remaining -= step
+			if on {
+				current = append(current, p)
+			}
+			if remaining > 0 {
+				continue
+			}
+			if on {
+				paths = append(paths, stroke(current, width, false)...)
+				current = nil
+				remaining = gapLength
+			} else {
+				current = []vec{p}
+				remaining = dashLength
+			}
+			on = !on
+		}
+	}
+	if on && len(current) > 0 {
+		paths = append(paths, stroke(current, width, false)...)
+	}
+	return paths
+}
+
+// quad returns the corners of a rectangle with the given half size, rotated
+// around its center, like HitDetection.getRotatedQuadVertices.
+func quad(center vec, halfWidth, halfHeight, rotation float64) []vec {
+	cos, sin := math.Cos(rotation), math.Sin(rotation)
+	corners := []vec{{-halfWidth, -halfHeight}, {halfWidth, -halfHeight}, {halfWidth, halfHeight}, {-halfWidth, halfHeight}}
+	for i, p := range corners {
+		corners[i] = rotate(p, cos, sin, center)
+	}
+	return corners
+}
+
+func rect(x, y, width, height float64) []vec {
+	return []vec{{x, y}, {x + width, y}, {x + width, y + height}, {x, y + height}}
+}
+
+// circle returns a polygon approximating a circle.
+func circle(center vec, radius float64) []vec {
+	return polygon(center, radius, 256)
+}
+
+// polygon returns a regular polygon approximating a circle, with at most
+// maxSides sides.
+func polygon(center vec, radius float64, maxSides int) []vec {
+	if !(radius > 0) {
+		return nil
+	}
+	n := min(max(int(math.Ceil(radius)), 12), maxSides)
+	path := make([]vec, n)
+	for i := range path {
+		a := 2 * math.Pi * float64(i) / float64(n)
+		path[i] = vec{center.x + radius*math.Cos(a), center.y + radius*math.Sin(a)}
+	}
+	return path
+}
+
+// ring returns a single path covering the area between two circles: the
+// outer circle, then back around the inner one the other way.
+func ring(center vec, inner, outer float64) []vec {
+	path := circle(center, outer)
+	if inner <= 0 || len(path) == 0 {
+		return path
+	}
+	hole := circle(center, inner)
+	path = append(path, path[0], hole[0])
+	for i := len(hole) - 1; i >= 0; i-- {
+		path = append(path, hole[i])
+	}
+	return path
+}
+
+// rotate rotates p by the angle with the given cosine and sine, then moves it by offset.
+func rotate(p vec, cos, sin float64, offset vec) vec {
+	return vec{p.x*cos - p.y*sin + offset.x, p.x*sin + p.y*cos + offset.y}
+}
+
+func pt(p drawable.Point) vec {
+	return vec{float64(p.X), float64(p.Y)}
+}
+
+func points(ps []drawable.Point) []vec {
+	out := make([]vec, len(ps))
+	for i, p := range ps {
+		out[i] = pt(p)
+	}
+	return out
+}
+
+func clamp01(v float32) float64 {
+	if !(v > 0) {
+		return 0
+	}
+	return min(float64(v), 1)
+}
@@ -0,0 +1,75 @@
+package render
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+	"testing/fstest"
+
+	"github.com/rail2025/AetherDraw-Server/drawable"
+)
+
+// solidPNG returns a PNG of a single color.
+func solidPNG(t *testing.T, c color.Color) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	for y := range 4 {
+		for x := range 4 {
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("png.Encode() error = %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestPage(t *testing.T) {
+	green := color.RGBA{0, 0xff, 0, 0xff}
+	icons := NewIconSet(fstest.MapFS{"Tank.png": {Data: solidPNG(t, green)}})
+	icon := func(path string, x float32, c drawable.Color) *drawable.Drawable {
+		return &drawable.Drawable{Mode: drawable.Image, Color: c, Body: &drawable.ImageBody{
+			PluginResourcePath: path, Position: drawable.Point{X: x, Y: 300}, Width: 40, Height: 40,
+		}}
+	}
+	drawables := []*drawable.Drawable{
+		{Mode: drawable.Circle, Color: drawable.Color{R: 1, A: 1}, IsFilled: true, Body: &drawable.CircleBody{
+			Center: drawable.Point{X: 200, Y: 200}, Radius: 50,
+		}},
+		icon("PluginImages.toolbar.Tank.png", 400, drawable.Color{A: 1}),
+		// Icons that are not bundled are drawn as dots in the drawable's color.
+		icon("PluginImages.toolbar.Healer.png", 600, drawable.Color{B: 1, A: 1}),
+	}
+
+	// The drawables fit in the smallest area drawn, so one pixel is one unit.
+	img := Page(drawables, DefaultWidth, icons)
+	if got := img.Bounds(); got != image.Rect(0, 0, minPageWidth, minPageHeight) {
+		t.Fatalf("Page() bounds = %v, want %dx%d", got, minPageWidth, minPageHeight)
+	}
+	tests := []struct {
+		name string
+		x, y int
+		want color.RGBA
+	}{
+		{"background", 5, 5, backgroundColor},
+		{"grid", 40, 5, color.RGBA{0x4d, 0x4d, 0x4d, 0xff}},
+		{"filled circle", 200, 200, color.RGBA{0xff, 0, 0, 0xff}},
+		{"beside the circle", 210, 270, backgroundColor},
+		{"icon", 405, 305, green},
+		{"missing icon", 602, 302, color.RGBA{0, 0, 0xff, 0xff}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := img.RGBAAt(tt.x, tt.y); got != tt.want {
+				t.Errorf("pixel (%d, %d) = %v, want %v", tt.x, tt.y, got, tt.want)
+			}
+		})
+	}
+
+	if got := Page(drawables, MaxWidth*2, icons).Bounds().Dx(); got != MaxWidth {
+		t.Errorf("Page() width = %d, want %d", got, MaxWidth)
+	}
+}
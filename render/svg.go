@@ -0,0 +1,233 @@
+package render
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/rail2025/AetherDraw-Server/drawable"
+)
+
+// pageGap is the space between the pages SVG stacks.
+const pageGap = 40
+
+// SVGPage is a page to export with SVG.
+type SVGPage struct {
+	Name      string
+	Drawables []*drawable.Drawable
+}
+
+// SVG returns pages as one SVG document, stacked top to bottom, each a group
+// titled with the page's name covering the same area as Page would draw.
+// Drawables map to the matching SVG elements, with rotations kept as rotate
+// transforms and colors as stored. Bundled icons are embedded as data URIs,
+// images from URLs are linked, and emoji are written as text.
+func SVG(pages []SVGPage, icons *IconSet) []byte {
+	type area struct {
+		minX, minY, maxX, maxY float64
+	}
+	areas := make([]area, len(pages))
+	width, height := 0.0, 0.0
+	for i, page := range pages {
+		var items []item
+		for _, d := range page.Drawables {
+			items = append(items, drawableItems(d, icons)...)
+		}
+		a := &areas[i]
+		a.minX, a.minY, a.maxX, a.maxY = bounds(items)
+		width = max(width, a.maxX-a.minX)
+		if i > 0 {
+			height += pageGap
+		}
+		height += a.maxY - a.minY
+	}
+
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" width="%s" height="%s" viewBox="0 0 %s %s">`+"\n",
+		num(width), num(height), num(width), num(height))
+	fmt.Fprintf(&b, `<defs><pattern id="grid" width="%d" height="%d" patternUnits="userSpaceOnUse"><path d="M %d 0 L 0 0 0 %d" fill="none" stroke="#4d4d4d" stroke-width="1"/></pattern></defs>`+"\n",
+		gridSize, gridSize, gridSize, gridSize)
+	offset := 0.0
+	for i, page := range pages {
+		a := areas[i]
+		fmt.Fprintf(&b, `<g id="page-%d" transform="translate(%s %s)">`+"\n", i, num(-a.minX), num(offset-a.minY))
+		fmt.Fprintf(&b, "<title>%s</title>\n", escape(page.Name))
+		for _, fill := range []string{"#26262b", "url(#grid)"} {
+			fmt.Fprintf(&b, `<rect x="%s" y="%s" width="%s" height="%s" fill="%s"/>`+"\n",
+				num(a.minX), num(a.minY), num(a.maxX-a.minX), num(a.maxY-a.minY), fill)
+		}
+		for _, d := range page.Drawables {
+			if element := drawableSVG(d, icons); element != "" {
+				b.WriteString(element)
+				b.WriteByte('\n')
+			}
+		}
+		b.WriteString("</g>\n")
+		offset += a.maxY - a.minY + pageGap
+	}
+	b.WriteString("</svg>\n")
+	return b.Bytes()
+}
+
+// drawableSVG returns the SVG element for d, or "" if it has none or holds
+// values SVG cannot express.
+func drawableSVG(d *drawable.Drawable, icons *IconSet) string {
+	ok := true
+	// n formats a coordinate, noting values that are not finite.
+	n := func(v float64) string {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			ok = false
+		}
+		return num(v)
+	}
+	p := func(v drawable.Point) string {
+		return n(float64(v.X)) + "," + n(float64(v.Y))
+	}
+	list := func(points []drawable.Point) string {
+		out := make([]string, len(points))
+		for i, v := range points {
+			out[i] = p(v)
+		}
+		return strings.Join(out, " ")
+	}
+	deg := func(rad float32) string {
+		return n(float64(rad) * 180 / math.Pi)
+	}
+	color := fmt.Sprintf("rgb(%s%%, %s%%, %s%%)", percent(d.Color.R), percent(d.Color.G), percent(d.Color.B))
+	opacity := num(clamp01(d.Color.A))
+	filled := fmt.Sprintf(`fill="%s" fill-opacity="%s"`, color, opacity)
+	stroked := fmt.Sprintf(`fill="none" stroke="%s" stroke-opacity="%s" stroke-width="%s"`, color, opacity, n(float64(d.Thickness)))
+	line := stroked + ` stroke-linecap="round" stroke-linejoin="round"`
+	shape := filled
+	if !d.IsFilled {
+		shape = stroked
+	}
+
+	var element string
+	switch body := d.Body.(type) {
+	case *drawable.PathBody:
+		element = fmt.Sprintf(`<polyline points="%s" %s/>`, list(body.Points), line)
+	case *drawable.LineBody:
+		element = fmt.Sprintf(`<line x1="%s" y1="%s" x2="%s" y2="%s" %s/>`,
+			n(float64(body.Start.X)), n(float64(body.Start.Y)), n(float64(body.End.X)), n(float64(body.End.Y)), line)
+	case *drawable.DashBody:
+		dashes := ""
+		if body.DashLength > 0 && body.GapLength >= 0 {
+			dashes = fmt.Sprintf(` stroke-dasharray="%s %s"`, n(float64(body.DashLength)), n(float64(body.GapLength)))
+		}
+		element = fmt.Sprintf(`<polyline points="%s" %s%s/>`, list(body.Points), line, dashes)
+	case *drawable.RectangleBody:
+		start, end := pt(body.Start), pt(body.End)
+		x, y := min(start.x, end.x), min(start.y, end.y)
+		w, h := math.Abs(end.x-start.x), math.Abs(end.y-start.y)
+		element = fmt.Sprintf(`<rect x="%s" y="%s" width="%s" height="%s" transform="rotate(%s %s %s)" %s/>`,
+			n(x), n(y), n(w), n(h), deg(body.Rotation), n(x+w/2), n(y+h/2), shape)
+	case *drawable.CircleBody:
+		element = fmt.Sprintf(`<circle cx="%s" cy="%s" r="%s" %s/>`,
+			n(float64(body.Center.X)), n(float64(body.Center.Y)), n(max(float64(body.Radius), 0)), shape)
+	case *drawable.ConeBody:
+		// The cone's base unrotated, as DrawableCone.getVertices computes it.
+		apex, base := pt(body.Apex), pt(body.BaseCenter)
+		v := vec{base.x - apex.x, base.y - apex.y}
+		height := math.Hypot(v.x, v.y)
+		if height < 0.1 {
+			return ""
+		}
+		half := height * 0.3
+		perp := vec{v.y / height * half, -v.x / height * half}
+		element = fmt.Sprintf(`<polygon points="%s,%s %s,%s %s,%s" transform="rotate(%s %s %s)" %s/>`,
+			n(apex.x), n(apex.y), n(base.x+perp.x), n(base.y+perp.y), n(base.x-perp.x), n(base.y-perp.y),
+			deg(body.Rotation), n(apex.x), n(apex.y), shape)
+	case *drawable.TriangleBody:
+		element = fmt.Sprintf(`<polygon points="%s" %s/>`, list(body.Vertices[:]), shape)
+	case *drawable.ArrowBody:
+		element = arrowSVG(body, float64(d.Thickness), line, filled, n, deg)
+	case *drawable.TextBody:
+		unit, maxChars := textMetrics(body)
+		var spans strings.Builder
+		for i, text := range layoutText(body.Text, maxChars) {
+			fmt.Fprintf(&spans, `<tspan x="%s" y="%s">%s</tspan>`,
+				n(float64(body.Position.X)), n(float64(body.Position.Y)+float64(i)*lineUnits*unit), escape(text))
+		}
+		element = fmt.Sprintf(`<text font-family="Arial, sans-serif" font-size="%s" dominant-baseline="hanging" xml:space="preserve" %s>%s</text>`,
+			n(unit*lineUnits), filled, spans.String())
+	case *drawable.ImageBody:
+		element = imageSVG(body, icons, filled, n, deg)
+	}
+	if !ok {
+		return ""
+	}
+	return element
+}
+
+// arrowSVG mirrors DrawableArrow.getTransformedVertices: a shaft and a head
+// in coordinates relative to the start point, rotated around it.
+func arrowSVG(b *drawable.ArrowBody, thickness float64, line, filled string, n func(float64) string, deg func(float32) string) string {
+	const minAbsoluteDim = 5.0
+	start, end := pt(b.Start), pt(b.End)
+	shaft := vec{end.x - start.x, end.y - start.y}
+	length := math.Hypot(shaft.x, shaft.y)
+	if length*length < 0.01 {
+		return ""
+	}
+	dir := vec{shaft.x / length, shaft.y / length}
+	headLength := max(minAbsoluteDim, float64(b.ArrowheadLengthOffset))
+	headHalfWidth := max(minAbsoluteDim/2, thickness*float64(b.ArrowheadWidthScale)/2)
+	perp := vec{dir.y * headHalfWidth, -dir.x * headHalfWidth}
+	return fmt.Sprintf(`<g transform="translate(%s %s) rotate(%s)"><line x1="0" y1="0" x2="%s" y2="%s" %s/><polygon points="%s,%s %s,%s %s,%s" %s/></g>`,
+		n(start.x), n(start.y), deg(b.Rotation),
+		n(shaft.x), n(shaft.y), line,
+		n(shaft.x+dir.x*headLength), n(shaft.y+dir.y*headLength),
+		n(shaft.x+perp.x), n(shaft.y+perp.y), n(shaft.x-perp.x), n(shaft.y-perp.y), filled)
+}
+
+// imageSVG returns the element for an image drawable, centered on its position.
+func imageSVG(b *drawable.ImageBody, icons *IconSet, filled string, n func(float64) string, deg func(float32) string) string {
+	cx, cy, w, h := float64(b.Position.X), float64(b.Position.Y), float64(b.Width), float64(b.Height)
+	if !(w > 0 && h > 0) {
+		return ""
+	}
+	rotate := fmt.Sprintf(`transform="rotate(%s %s %s)"`, deg(b.Rotation), n(cx), n(cy))
+	path := b.PluginResourcePath
+	href := ""
+	switch {
+	case strings.HasPrefix(path, "emoji:"):
+		return fmt.Sprintf(`<text x="%s" y="%s" font-size="%s" text-anchor="middle" dominant-baseline="central" %s>%s</text>`,
+			n(cx), n(cy), n(h*0.8), rotate, escape(strings.TrimPrefix(path, "emoji:")))
+	case strings.HasPrefix(path, "https://"), strings.HasPrefix(path, "http://"):
+		href = path
+	default:
+		data, contentType := icons.File(path)
+		if data == nil {
+			return fmt.Sprintf(`<circle cx="%s" cy="%s" r="%s" %s/>`, n(cx), n(cy), n(min(w, h)/4), filled)
+		}
+		href = "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(data)
+	}
+	return fmt.Sprintf(`<image xlink:href="%s" x="%s" y="%s" width="%s" height="%s" preserveAspectRatio="none" %s/>`,
+		escape(href), n(cx-w/2), n(cy-h/2), n(w), n(h), rotate)
+}
+
+// num formats v as briefly as it round-trips.
+func num(v float64) string {
+	if v == 0 {
+		// No "-0".
+		v = 0
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// percent formats a color component as an SVG percentage.
+func percent(v float32) string {
+	return strconv.FormatFloat(float64(float32(clamp01(v)*100)), 'g', -1, 32)
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,77 @@
+package render
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"slices"
+	"testing"
+
+	"github.com/rail2025/AetherDraw-Server/drawable"
+)
+
+// svgContent parses an SVG document, failing t if it is not well-formed, and
+// returns the names of its elements, its text and its attribute values.
+func svgContent(t *testing.T, doc []byte) (elements, text, attrs []string) {
+	t.Helper()
+	decoder := xml.NewDecoder(bytes.NewReader(doc))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return elements, text, attrs
+		}
+		if err != nil {
+			t.Fatalf("SVG() is not well-formed: %v\n%s", err, doc)
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			elements = append(elements, token.Name.Local)
+			for _, attr := range token.Attr {
+				attrs = append(attrs, attr.Value)
+			}
+		case xml.CharData:
+			text = append(text, string(token))
+		}
+	}
+}
+
+func TestSVGEscaping(t *testing.T) {
+	const markup = `</text><script>alert("x")</script><text>`
+	image := func(path string) *drawable.Drawable {
+		return &drawable.Drawable{Mode: drawable.Image, Color: drawable.Color{A: 1}, Body: &drawable.ImageBody{
+			PluginResourcePath: path, Position: drawable.Point{X: 50, Y: 50}, Width: 30, Height: 30,
+		}}
+	}
+	text := func(s string) *drawable.Drawable {
+		return &drawable.Drawable{Mode: drawable.TextTool, Color: drawable.Color{A: 1}, Body: &drawable.TextBody{
+			Text: s, Position: drawable.Point{X: 10, Y: 10}, FontSize: 16,
+		}}
+	}
+	tests := []struct {
+		name     string
+		page     SVGPage
+		wantText string
+		wantAttr string
+	}{
+		{"page name", SVGPage{Name: "</title><script>alert(1)</script>"}, "</title><script>alert(1)</script>", ""},
+		{"text", SVGPage{Drawables: []*drawable.Drawable{text(markup)}}, markup, ""},
+		{"text with ampersands", SVGPage{Drawables: []*drawable.Drawable{text("Tank & Healer <3 &amp;")}}, "Tank & Healer <3 &amp;", ""},
+		{"emoji", SVGPage{Drawables: []*drawable.Drawable{image("emoji:" + markup)}}, markup, ""},
+		{"href with quotes", SVGPage{Drawables: []*drawable.Drawable{image(`https://example.com/a.png" onload="alert(1)`)}}, "", `https://example.com/a.png" onload="alert(1)`},
+		{"href with markup", SVGPage{Drawables: []*drawable.Drawable{image(`http://example.com/?a=1&b='<script>'`)}}, "", `http://example.com/?a=1&b='<script>'`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			elements, text, attrs := svgContent(t, SVG([]SVGPage{tt.page}, nil))
+			if slices.Contains(elements, "script") {
+				t.Errorf("SVG() elements = %v, want no script", elements)
+			}
+			if tt.wantText != "" && !slices.Contains(text, tt.wantText) {
+				t.Errorf("SVG() text = %q, want %q", text, tt.wantText)
+			}
+			if tt.wantAttr != "" && !slices.Contains(attrs, tt.wantAttr) {
+				t.Errorf("SVG() attribute values = %q, want %q", attrs, tt.wantAttr)
+			}
+		})
+	}
+}
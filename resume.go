@@ -0,0 +1,93 @@
+package main
+
+import (
+	"time"
+
+	"github.com/rail2025/AetherDraw-Server/protocol"
+)
+
+const (
+	// resumeBufferSize is how many relayed frames a room keeps for reconnecting clients.
+	resumeBufferSize = 1024
+	// sessionResumeWindow is how long after disconnecting a client may resume its session.
+	sessionResumeWindow = 2 * time.Minute
+)
+
+// sequencedFrame is a relayed frame kept in a room's resume buffer.
+type sequencedFrame struct {
+	seq int64
+	rel *relay
+}
+
+// roomSession remembers a client that joined with sequencing enabled so it
+// can pick up where it left off after a reconnect.
+type roomSession struct {
+	clientID string
+	role     string
+	// leftAt is zero while the session is connected.
+	leftAt time.Time
+}
+
+// record assigns the next sequence number to a relayed frame and keeps it in
+// the resume buffer. Only called from the room's loop.
+func (r *Room) record(rel *relay) int64 {
+	r.seq++
+	if len(r.history) == resumeBufferSize {
+		copy(r.history, r.history[1:])
+		r.history = r.history[:resumeBufferSize-1]
+	}
+	r.history = append(r.history, sequencedFrame{seq: r.seq, rel: rel})
+	return r.seq
+}
+
+// missedSince returns the buffered frames after lastSeq, or false if some of
+// them have already been dropped from the buffer.
+func (r *Room) missedSince(lastSeq int64) ([]sequencedFrame, bool) {
+	if lastSeq > r.seq || lastSeq < 0 {
+		return nil, false
+	}
+	missed := r.seq - lastSeq
+	if missed > int64(len(r.history)) {
+		return nil, false
+	}
+	return r.history[int64(len(r.history))-missed:], true
+}
+
+// startSession registers the client's session, resuming the one it asked for
+// if possible. It returns the frames the client missed and whether it
+// resumed. Only called from the room's loop.
+func (r *Room) startSession(c *Client) ([]sequencedFrame, bool) {
+	now := time.Now()
+	for id, session := range r.sessions {
+		if !session.leftAt.IsZero() && now.Sub(session.leftAt) > sessionResumeWindow {
+			delete(r.sessions, id)
+		}
+	}
+
+	if c.resumeSession != "" {
+		session, ok := r.sessions[c.resumeSession]
+		if ok && !session.leftAt.IsZero() && session.role == c.role {
+			if missed, ok := r.missedSince(c.resumeSeq); ok {
+				// Keep the identity the rest of the room already knows.
+				c.session = c.resumeSession
+				c.id = session.clientID
+				session.leftAt = time.Time{}
+				return missed, true
+			}
+		}
+	}
+	r.sessions[c.session] = &roomSession{clientID: c.id, role: c.role}
+	return nil, false
+}
+
+// endSession marks the client's session as resumable. Only called from the room's loop.
+func (r *Room) endSession(c *Client) {
+	if session, ok := r.sessions[c.session]; ok {
+		session.leftAt = time.Now()
+	}
+}
+
+// sessionFrame is the SessionStarted frame telling c where the room's sequence stands.
+func (r *Room) sessionFrame(c *Client, resumed bool) []byte {
+	return protocol.EncodeSession(&protocol.Session{ID: c.session, Seq: r.seq, Resumed: resumed})
+}
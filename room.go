@@ -0,0 +1,618 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rail2025/AetherDraw-Server/board"
+	"github.com/rail2025/AetherDraw-Server/drawable"
+	"github.com/rail2025/AetherDraw-Server/protocol"
+)
+
+// roomInboxSize is the number of client messages buffered for a room's loop.
+const roomInboxSize = 256
+
+// roomOutboxSize is the number of broker envelopes buffered for a room's
+// publisher. Past that the broker is falling behind and envelopes are dropped.
+const roomOutboxSize = 256
+
+// closeReason says why a room was asked to close.
+type closeReason int
+
+const (
+	// closeLifetime closes the room because it reached the end of its lifetime.
+	closeLifetime closeReason = iota
+	// closeLoneClient closes a room that has had at most one client for too long.
+	closeLoneClient
+	// closeUnclaimed closes a room that has had no clients for too long.
+	closeUnclaimed
+	// closeAdmin closes the room at the request of its creator.
+	closeAdmin
+	// closeShutdown closes the room because the server is shutting down.
+	closeShutdown
+	// closeIdle closes a room that has seen no activity for too long.
+	closeIdle
+)
+
+// wire returns the reason reported to clients in RoomClosing frames.
+func (reason closeReason) wire() protocol.ClosingReason {
+	switch reason {
+	case closeLoneClient:
+		return protocol.ClosingLoneClient
+	case closeAdmin:
+		return protocol.ClosingAdmin
+	case closeShutdown:
+		return protocol.ClosingShutdown
+	case closeIdle:
+		return protocol.ClosingIdle
+	}
+	return protocol.ClosingLifetime
+}
+
+func (reason closeReason) String() string {
+	return reason.wire().String()
+}
+
+// Room represents a single chat room. Each room runs its own event loop, so
+// a busy room never holds up the others; the hub only routes joins to it.
+type Room struct {
+	hub *Hub
+	// Name the room is registered under: its passphrase or created room ID.
+	name string
+	// Registered clients. Only the room's loop modifies the map, holding clientsMux.
+	clients    map[*Client]bool
+	clientsMux sync.RWMutex
+	// Authoritative page model, used to bring late joiners up to date.
+	board *board.Board
+	// Board restored from a checkpoint, held back until the room has heard
+	// whether another instance holds a live one. Guarded by stateMux.
+	checkpoint *board.Board
+	// Mutex to protect access to the board.
+	stateMux sync.RWMutex
+	// Timer that triggers cleanup when the room is empty or has a single client.
+	cleanupTimer *time.Timer
+	// The time the room was created.
+	creationTime time.Time
+	// When a client last drew or pointed at something, in Unix nanoseconds.
+	lastActivity atomic.Int64
+	// When the room closes because of its lifetime, in Unix nanoseconds.
+	// Starts at creationTime + policy.Lifetime and moves when clients extend the room.
+	deadline atomic.Int64
+	// Type of the client that created the room ("ad", "ad-web" or "ab").
+	clientType string
+	// Limits enforced for this room.
+	policy roomPolicy
+	// Credentials of rooms created through /room/create; nil for passphrase rooms.
+	access *roomAccess
+	// Sequence number of the last relayed frame, the most recent relayed
+	// frames and the sessions that may resume. Only touched by the room's loop.
+	seq      int64
+	history  []sequencedFrame
+	sessions map[string]*roomSession
+	// The most recent state updates with their authors and inverses, and the
+	// last change made to each drawable. Only touched by the room's loop.
+	ops      []*operation
+	versions map[drawable.Guid]objectVersion
+	// The versions in the order they were recorded, for forgetting them, and
+	// the sequence number up to which they have been forgotten.
+	versionQueue []versionEntry
+	forgottenSeq int64
+	// Edit locks by drawable, and the timer that expires them. Only touched by
+	// the room's loop.
+	locks     map[drawable.Guid]objectLock
+	lockTimer *time.Timer
+
+	// Clients connected to other instances, by client ID, as announced through
+	// the broker. Only the room's loop modifies the map, holding clientsMux.
+	remoteClients map[string]protocol.PresenceEntry
+	// Cancels the broker subscription, nil if subscribing failed.
+	unsubscribe func()
+	// Records the frames relayed on behalf of local clients, nil unless the
+	// room was created with recording on.
+	recorder *recorder
+
+	// Events handled by the room's loop.
+	join    chan *Client
+	leave   chan *Client
+	inbox   chan *Message
+	remote  chan []byte
+	notices chan *protocol.Closing
+	// Envelopes waiting for publishLoop. Closed by unregister.
+	outbox chan []byte
+	// IDs of join tokens revoked while the room is open.
+	revocations chan string
+	// Signalled by lockTimer when a lock runs out.
+	lockExpiry chan struct{}
+	// Buffered for one request of each kind, so a stale lone-client or
+	// unclaimed request cannot crowd out a later one.
+	closing chan closeReason
+	// Closed once the loop has exited and the room is no longer registered.
+	done chan struct{}
+}
+
+// newRoom creates an empty room governed by policy. The room's loop is
+// started when the room is registered with Hub.addRoom.
+func newRoom(hub *Hub, name string, policy roomPolicy, clientType string) *Room {
+	r := &Room{
+		hub:           hub,
+		name:          name,
+		clients:       make(map[*Client]bool),
+		board:         board.New(),
+		creationTime:  time.Now(),
+		clientType:    clientType,
+		policy:        policy,
+		sessions:      make(map[string]*roomSession),
+		remoteClients: make(map[string]protocol.PresenceEntry),
+		versions:      make(map[drawable.Guid]objectVersion),
+		locks:         make(map[drawable.Guid]objectLock),
+		join:          make(chan *Client),
+		leave:         make(chan *Client),
+		inbox:         make(chan *Message, roomInboxSize),
+		remote:        make(chan []byte, roomInboxSize),
+		notices:       make(chan *protocol.Closing, 1),
+		outbox:        make(chan []byte, roomOutboxSize),
+		revocations:   make(chan string),
+		lockExpiry:    make(chan struct{}, 1),
+		closing:       make(chan closeReason, closeIdle+1),
+		done:          make(chan struct{}),
+	}
+	r.touch()
+	r.setDeadline(r.creationTime.Add(policy.Lifetime))
+	return r
+}
+
+// touch records activity in the room, postponing its idle closing.
+func (r *Room) touch() {
+	r.lastActivity.Store(time.Now().UnixNano())
+}
+
+// idleSince returns when the room last saw any activity.
+func (r *Room) idleSince() time.Time {
+	return time.Unix(0, r.lastActivity.Load())
+}
+
+// requestClose asks the room's loop to close the room. It never blocks.
+func (r *Room) requestClose(reason closeReason) {
+	select {
+	case r.closing <- reason:
+	default:
+		// A close request is already pending.
+	}
+}
+
+// warnClosing asks the room's loop to tell its clients that the room will
+// close for reason after remaining. It never blocks; a warning is dropped if
+// another is still pending.
+func (r *Room) warnClosing(reason closeReason, remaining time.Duration) {
+	notice := &protocol.Closing{Reason: reason.wire(), SecondsRemaining: int32((remaining + time.Second - 1) / time.Second)}
+	select {
+	case r.notices <- notice:
+	default:
+	}
+}
+
+// countRoles returns the number of members and spectators in the room.
+func (r *Room) countRoles() (members, spectators int) {
+	r.clientsMux.RLock()
+	defer r.clientsMux.RUnlock()
+	for client := range r.clients {
+		if client.role == roleSpectator {
+			spectators++
+		} else {
+			members++
+		}
+	}
+	for _, entry := range r.remoteClients {
+		if entry.Spectator {
+			spectators++
+		} else {
+			members++
+		}
+	}
+	return members, spectators
+}
+
+// population is the number of clients in the room across all instances.
+// Only called from the room's loop.
+func (r *Room) population() int {
+	return len(r.clients) + len(r.remoteClients)
+}
+
+// startCleanupTimer schedules a close request, replacing any pending one.
+func (r *Room) startCleanupTimer(timeout time.Duration, reason closeReason) {
+	r.stopCleanupTimer()
+	r.cleanupTimer = time.AfterFunc(timeout, func() { r.requestClose(reason) })
+}
+
+// stopCleanupTimer cancels a scheduled cleanup, if any.
+func (r *Room) stopCleanupTimer() {
+	if r.cleanupTimer != nil {
+		r.cleanupTimer.Stop()
+		r.cleanupTimer = nil
+	}
+}
+
+// updateLoneTimer starts the lone-client timer when a single client is left
+// across all instances and cancels it once someone else arrives.
+// Only called from the room's loop while the room has local clients.
+func (r *Room) updateLoneTimer() {
+	if r.population() == 1 {
+		if r.cleanupTimer == nil {
+			slog.Info("Only one client in room, starting cleanup timer", "room", roomLogID(r.name), "timeout", r.policy.LoneClientTimeout)
+			r.startCleanupTimer(r.policy.LoneClientTimeout, closeLoneClient)
+		}
+	} else if r.cleanupTimer != nil {
+		r.stopCleanupTimer()
+		slog.Info("Stopped cleanup timer for room", "room", roomLogID(r.name))
+	}
+}
+
+// run is the room's event loop. It owns the client map, the resume buffer
+// and all writes to the clients' send channels.
+func (r *Room) run() {
+	go r.publishLoop()
+	r.subscribe()
+	var checkpointWait <-chan time.Time
+	if r.checkpoint != nil {
+		if r.unsubscribe == nil {
+			// No other instance can answer.
+			r.useCheckpoint()
+		} else {
+			checkpointWait = time.After(liveBoardWait)
+		}
+	}
+	// Drop the room if nobody ever joins it.
+	r.startCleanupTimer(unclaimedRoomTimeout, closeUnclaimed)
+	for {
+		select {
+		case client := <-r.join:
+			r.handleJoin(client)
+		case client := <-r.leave:
+			if r.handleLeave(client) {
+				return
+			}
+		case message := <-r.inbox:
+			r.handleMessage(message)
+		case msg := <-r.remote:
+			r.handleRemote(msg)
+		case notice := <-r.notices:
+			slog.Info("Warning room of upcoming closing", "room", roomLogID(r.name), "reason", notice.Reason, "seconds", notice.SecondsRemaining)
+			r.sendClosing(notice)
+		case <-r.lockExpiry:
+			r.expireLocks()
+		case <-checkpointWait:
+			r.useCheckpoint()
+		case id := <-r.revocations:
+			r.disconnectToken(id)
+			r.publish(&envelope{kind: envelopeRevoke, body: []byte(id)})
+		case reason := <-r.closing:
+			// A timer may have fired just before the room changed; re-check it.
+			if reason == closeLoneClient && r.population() > 1 || reason == closeUnclaimed && len(r.clients) > 0 {
+				continue
+			}
+			r.shutdown(reason)
+			return
+		}
+	}
+}
+
+// handleJoin adds a client to the room and brings it up to date.
+func (r *Room) handleJoin(client *Client) {
+	r.clientsMux.Lock()
+	r.clients[client] = true
+	r.clientsMux.Unlock()
+	if client.tokenID != "" && r.tokenRevoked(client.tokenID) {
+		// The token was revoked while the client was connecting.
+		client.disconnect(closeTokenRevoked, "join token revoked")
+	}
+
+	if len(r.clients) == 1 {
+		// Replace the timer that waited for the first client.
+		r.stopCleanupTimer()
+	}
+	r.updateLoneTimer()
+	// A resumed session gives the client back its old ID, so settle that
+	// before anyone is told about the client.
+	var missed []sequencedFrame
+	resumed := false
+	if client.sequenced {
+		missed, resumed = r.startSession(client)
+	}
+	// Tell the newcomer who is here and everyone else who arrived.
+	if client.presence {
+		r.sendPresence(&protocol.Presence{Kind: protocol.PresenceRoster, Entries: r.roster()}, nil)
+	}
+	if client.lockUpdates {
+		r.sendLocks(client)
+	}
+	joined := &protocol.Presence{Kind: protocol.PresenceJoined, Entries: []protocol.PresenceEntry{client.presenceEntry()}}
+	r.sendPresence(joined, client)
+	r.publish(&envelope{kind: envelopePresence, body: protocol.EncodePresence(joined)})
+	if client.sequenced {
+		client.enqueue(r.sessionFrame(client, resumed))
+	}
+	if resumed {
+		// The client kept its board; replay only what it missed.
+		for _, f := range missed {
+			if frame := client.pages.relayed(f.rel.sequenced(f.seq)); frame != nil {
+				client.enqueue(frame)
+			}
+			client.pages.follow(f.rel)
+		}
+		slog.Info("Client resumed session", "room", roomLogID(r.name), "missed", len(missed), "clients_in_room", len(r.clients))
+		return
+	}
+
+	r.stateMux.RLock()
+	snapshot := r.board.Snapshot()
+	r.stateMux.RUnlock()
+	for _, payload := range snapshot {
+		if payload = client.pages.payload(payload); payload == nil {
+			continue
+		}
+		select {
+		case client.send <- protocol.EncodeStateUpdate(payload):
+		default:
+			slog.Warn("Failed to send snapshot message to client, send channel full", "room", roomLogID(r.name))
+		}
+	}
+	slog.Info("Client snapshot sent", "room", roomLogID(r.name), "frames", len(snapshot), "clients_in_room", len(r.clients))
+}
+
+// handleLeave removes a client from the room. It reports whether the room
+// closed because it became empty.
+func (r *Room) handleLeave(client *Client) bool {
+	if _, ok := r.clients[client]; !ok {
+		return false
+	}
+	r.clientsMux.Lock()
+	delete(r.clients, client)
+	r.clientsMux.Unlock()
+	close(client.send)
+	slog.Info("Client unregistered", "room", roomLogID(r.name), "clients_in_room", len(r.clients))
+	r.endSession(client)
+	// Other instances release the client's locks when they see it leave.
+	r.releaseLocks(client.id, client.displayName, nil)
+	left := &protocol.Presence{Kind: protocol.PresenceLeft, Entries: []protocol.PresenceEntry{client.presenceEntry()}}
+	r.sendPresence(left, nil)
+	r.publish(&envelope{kind: envelopePresence, body: protocol.EncodePresence(left)})
+
+	if len(r.clients) > 0 {
+		r.updateLoneTimer()
+		return false
+	}
+	r.stopCleanupTimer()
+	if r.access != nil {
+		// Invites to created rooms stay usable for a while after everyone leaves.
+		slog.Info("Room is empty, keeping it for invited clients", "room", roomLogID(r.name), "timeout", unclaimedRoomTimeout)
+		r.startCleanupTimer(unclaimedRoomTimeout, closeUnclaimed)
+		return false
+	}
+	r.unregister()
+	slog.Info("Room is empty, deleting", "room", roomLogID(r.name))
+	return true
+}
+
+// handleMessage applies a client message to the board and relays it.
+func (r *Room) handleMessage(message *Message) {
+	if _, ok := r.clients[message.source]; !ok {
+		// The sender left before its message was handled.
+		return
+	}
+	switch {
+	case message.extension != 0:
+		r.handleExtension(message.source, message.extension)
+		return
+	case message.undo != 0:
+		r.handleUndo(message.source, message.undo)
+		return
+	case message.opLog:
+		r.sendOperationLog(message.source)
+		return
+	case message.lock != nil:
+		r.handleLock(message.source, message.lock)
+		return
+	case message.subscribe:
+		r.handleSubscription(message.source, message.subscription)
+		return
+	}
+	if message.ephemeral {
+		r.touch()
+		r.relayEphemeral(message.data, message.source)
+		r.publish(&envelope{kind: envelopeFrame, ephemeral: true, body: message.data})
+		return
+	}
+	if message.ackOnly {
+		r.ack(message, 0, message.ackStatus)
+		return
+	}
+	r.touch()
+
+	if r.rejectLocked(message) {
+		return
+	}
+	lost, ok := r.resolveConflicts(message)
+	if !ok {
+		r.ack(message, 0, protocol.AckConflict)
+		r.sendConflict(message.source, message.payload.PageIndex, lost)
+		return
+	}
+	op, status, ok := r.apply(message.payload)
+	if !ok {
+		r.ack(message, 0, status)
+		return
+	}
+	seq := r.fanOut(message.data, op, message.source, message.source.clientType)
+	r.logOperation(op, seq, message.source.id, message.source.displayName)
+	r.recorder.add(message.data)
+	if len(lost) > 0 && status == protocol.AckRelayed {
+		status = protocol.AckConflict
+	}
+	r.ack(message, seq, status)
+	if len(lost) > 0 {
+		r.sendConflict(message.source, message.payload.PageIndex, lost)
+	}
+	r.publish(&envelope{kind: envelopeFrame, sourceType: message.source.clientType, author: message.source.id, body: message.data})
+}
+
+// apply records a StateUpdate payload on the room's board. It returns the
+// entry to log once the update is relayed, nil if it is not logged, the
+// outcome for the sender and whether the update should be relayed.
+func (r *Room) apply(payload *protocol.NetworkPayload) (*operation, protocol.AckStatus, bool) {
+	// Only AetherDraw frames carry a payload.
+	if payload == nil || !r.policy.keepsBoard() {
+		return nil, protocol.AckRelayed, true
+	}
+	r.stateMux.Lock()
+	wasInitialized := r.board.Initialized()
+	pages := r.board.PageCount()
+	undo, ids, undoable := r.board.Inverse(payload)
+	err := r.board.Apply(payload)
+	removedPage := r.board.PageCount() < pages
+	r.stateMux.Unlock()
+	switch {
+	case errors.Is(err, board.ErrNotInitialized):
+		// The first message for a new room MUST be a ReplacePage action.
+		// Other updates are still relayed but not recorded.
+		slog.Warn("Ignoring non-ReplacePage message for new room", "room", roomLogID(r.name), "action", payload.Action)
+		return nil, protocol.AckNoInitialState, true
+	case err != nil:
+		slog.Warn("Rejected state update", "room", roomLogID(r.name), "action", payload.Action, "page", payload.PageIndex, "error", err)
+		if errors.Is(err, board.ErrMalformed) {
+			return nil, protocol.AckMalformed, false
+		}
+		return nil, protocol.AckRejected, false
+	case !wasInitialized:
+		slog.Info("Initial state set for room", "room", roomLogID(r.name))
+	}
+	if !undoable {
+		undo = nil
+	}
+	op := &operation{action: payload.Action, page: payload.PageIndex, ids: ids, undo: undo, removedPage: removedPage}
+	return op, protocol.AckRelayed, true
+}
+
+// fanOut relays a frame to the room's local clients and returns the sequence
+// number it was given, or 0 for AetherBreaker frames. op is what the frame
+// did to the board, nil if it was not recorded; source is nil for frames
+// from other instances.
+func (r *Room) fanOut(data []byte, op *operation, source *Client, sourceType string) int64 {
+	// If the message is from an "ab" client, send only to the other player.
+	if sourceType == "ab" {
+		for client := range r.clients {
+			if client != source {
+				client.enqueue(data)
+			}
+		}
+		return 0
+	}
+	// Otherwise (for "ad" and "ad-web" clients), broadcast to everyone.
+	// AetherDraw frames are numbered so sequenced clients can resume.
+	// Clients that follow only some pages get what their filter lets through.
+	// Without a board the room cannot tell whether a DeletePage took effect,
+	// so page filters follow every one.
+	rel := newRelay(data, op != nil && op.removedPage || !r.policy.keepsBoard())
+	seq := r.record(rel)
+	sequenced := rel.sequenced(seq)
+	for client := range r.clients {
+		relayed := rel
+		if client.sequenced {
+			relayed = sequenced
+		}
+		if frame := client.pages.relayed(relayed); frame != nil {
+			client.enqueue(frame)
+		}
+		client.pages.follow(rel)
+	}
+	return seq
+}
+
+// relayEphemeral relays a pointer frame to the clients other than source that
+// asked for pointers. Pointer frames are best effort: clients that are behind
+// skip them.
+func (r *Room) relayEphemeral(data []byte, source *Client) {
+	for client := range r.clients {
+		if client == source || !client.pointers {
+			continue
+		}
+		select {
+		case client.send <- data:
+		default:
+		}
+	}
+}
+
+// sendClosing queues a RoomClosing frame for every client in the room that
+// asked for notices. The others only understand the final warningMessage.
+// Only called from the room's loop.
+func (r *Room) sendClosing(notice *protocol.Closing) {
+	frame := protocol.EncodeClosing(notice)
+	for client := range r.clients {
+		if client.notices {
+			client.enqueue(frame)
+		}
+	}
+}
+
+// shutdown warns the clients, disconnects them and unregisters the room.
+func (r *Room) shutdown(reason closeReason) {
+	r.stopCleanupTimer()
+	slog.Info("Sending closing warning to room", "room", roomLogID(r.name), "reason", reason)
+	r.sendClosing(&protocol.Closing{Reason: reason.wire()})
+	for client := range r.clients {
+		client.enqueue(warningMessage)
+	}
+	if len(r.clients) > 0 {
+		// Other instances keep the room open, so tell them these clients are gone.
+		left := &protocol.Presence{Kind: protocol.PresenceLeft, Entries: r.localRoster()}
+		r.publish(&envelope{kind: envelopePresence, body: protocol.EncodePresence(left)})
+	}
+	if reason == closeAdmin {
+		// The room is closed for good, not just on this instance.
+		r.publish(&envelope{kind: envelopeClose})
+	}
+
+	// Give the write pumps a moment to deliver the warning. Only this room waits.
+	time.Sleep(100 * time.Millisecond)
+
+	r.clientsMux.Lock()
+	for client := range r.clients {
+		close(client.send)
+		delete(r.clients, client)
+	}
+	r.clientsMux.Unlock()
+	r.unregister()
+	slog.Info("Closed room", "room", roomLogID(r.name), "reason", reason)
+}
+
+// unregister stops listening to other instances and removes the room from
+// the hub. The room's loop exits right after.
+func (r *Room) unregister() {
+	r.stopLockTimer()
+	r.recorder.flush()
+	if r.unsubscribe != nil {
+		r.unsubscribe()
+	}
+	// Lets publishLoop send what is queued, such as the last Left presence, and exit.
+	close(r.outbox)
+	r.hub.removeRoom(r)
+}
+
+// deliver hands a message to the client's room, dropping it if the room has closed.
+func (c *Client) deliver(message *Message) {
+	select {
+	case c.joinedRoom.inbox <- message:
+	case <-c.joinedRoom.done:
+	}
+}
+
+// leaveRoom removes the client from its room, if the room is still open.
+func (c *Client) leaveRoom() {
+	select {
+	case c.joinedRoom.leave <- c:
+	case <-c.joinedRoom.done:
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/rail2025/AetherDraw-Server/drawable"
+	"github.com/rail2025/AetherDraw-Server/protocol"
+)
+
+// newTestHub returns a hub with its own in-memory broker. Its rooms are
+// closed when the test ends.
+func newTestHub(tb testing.TB) *Hub {
+	tb.Helper()
+	return closeRoomsOnCleanup(tb, newMemoryBroker())
+}
+
+// closeRoomsOnCleanup returns a hub on broker whose rooms are closed when the
+// test ends, so their loops do not outlive it.
+func closeRoomsOnCleanup(tb testing.TB, broker Broker) *Hub {
+	tb.Helper()
+	hub, err := newHub(broker)
+	if err != nil {
+		tb.Fatalf("newHub() error = %v", err)
+	}
+	tb.Cleanup(func() {
+		hub.roomsMux.RLock()
+		rooms := make([]*Room, 0, len(hub.rooms))
+		for _, room := range hub.rooms {
+			rooms = append(rooms, room)
+		}
+		hub.roomsMux.RUnlock()
+		for _, room := range rooms {
+			room.requestClose(closeShutdown)
+			<-room.done
+		}
+	})
+	return hub
+}
+
+// newTestClient returns an AetherDraw member of room without a connection.
+// Tests read what the room sends it from its send channel.
+func newTestClient(tb testing.TB, hub *Hub, room string) *Client {
+	tb.Helper()
+	id, err := generateShortID()
+	if err != nil {
+		tb.Fatalf("generateShortID() error = %v", err)
+	}
+	return &Client{
+		hub:        hub,
+		send:       make(chan []byte, clientQueueDepth),
+		room:       room,
+		clientType: "ad",
+		role:       roleMember,
+		id:         id,
+		joinedAt:   time.Now(),
+		limiter:    rate.NewLimiter(rate.Inf, 0),
+	}
+}
+
+// nextFrame returns the next frame of type kind queued for c, skipping others.
+func nextFrame(t *testing.T, c *Client, kind protocol.MessageType) []byte {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case frame := <-c.send:
+			if len(frame) > 0 && protocol.MessageType(frame[0]) == kind {
+				return frame
+			}
+		case <-timeout:
+			t.Fatalf("no %s frame", kind)
+		}
+	}
+}
+
+func TestResumedClientKeepsIDInRoster(t *testing.T) {
+	hub := newTestHub(t)
+	// Someone stays in the room so it outlives the first connection.
+	other := newTestClient(t, hub, "resume")
+	hub.join(other)
+
+	first := newTestClient(t, hub, "resume")
+	first.sequenced, first.session = true, "session"
+	hub.join(first)
+	first.leaveRoom()
+
+	again := newTestClient(t, hub, "resume")
+	again.sequenced, again.session, again.presence = true, "new session", true
+	again.resumeSession, again.resumeSeq = "session", 0
+	throwaway := again.id
+	hub.join(again)
+
+	frame := nextFrame(t, again, protocol.PresenceUpdate)
+	roster, err := protocol.DecodePresence(frame[1:])
+	if err != nil {
+		t.Fatalf("DecodePresence() error = %v", err)
+	}
+	ids := make(map[string]bool)
+	for _, entry := range roster.Entries {
+		ids[entry.ID] = true
+	}
+	if !ids[first.id] || ids[throwaway] || len(ids) != 2 {
+		t.Errorf("roster IDs = %v, want %s and %s", ids, first.id, other.id)
+	}
+}
+
+// circleUpdate returns a StateUpdate payload for page 0 carrying one circle.
+func circleUpdate(action protocol.PayloadActionType, n int) *protocol.NetworkPayload {
+	d := &drawable.Drawable{
+		Mode:  drawable.Circle,
+		Color: drawable.Color{R: 1, A: 1},
+		ID:    drawable.Guid{byte(n), byte(n >> 8)},
+		Body:  &drawable.CircleBody{Center: drawable.Point{X: float32(n), Y: 10}, Radius: 5},
+	}
+	return &protocol.NetworkPayload{Action: action, Data: drawable.EncodePage([]drawable.Raw{d.Raw()})}
+}
+
+// BenchmarkRooms sends StateUpdates through many rooms at once, each with a
+// few clients, and reports how many frames the rooms fan out per second.
+func BenchmarkRooms(b *testing.B) {
+	const clientsPerRoom = 4
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	defer func(depth int, policy string) { clientQueueDepth, slowConsumerPolicy = depth, policy }(clientQueueDepth, slowConsumerPolicy)
+	// The clients have no connection to evict; drop frames instead, if ever.
+	clientQueueDepth, slowConsumerPolicy = maxClientQueueDepth, slowConsumerDropOldest
+
+	for _, rooms := range []int{100, 500} {
+		b.Run(fmt.Sprintf("rooms=%d", rooms), func(b *testing.B) {
+			hub := newTestHub(b)
+			var received atomic.Int64
+			var drained sync.WaitGroup
+			members := make([][]*Client, rooms)
+			for i := range members {
+				for j := 0; j < clientsPerRoom; j++ {
+					c := newTestClient(b, hub, fmt.Sprintf("bench-%d", i))
+					hub.join(c)
+					drained.Add(1)
+					go func() {
+						defer drained.Done()
+						for range c.send {
+							received.Add(1)
+						}
+					}()
+					members[i] = append(members[i], c)
+				}
+				initial := circleUpdate(protocol.ReplacePage, 0)
+				members[i][0].deliver(&Message{room: members[i][0].room, data: protocol.EncodeStateUpdate(initial), source: members[i][0], payload: initial})
+			}
+			// Wait for the joins and initial pages to reach everyone.
+			for received.Load() < int64(rooms*clientsPerRoom) {
+				time.Sleep(time.Millisecond)
+			}
+
+			updates := make([]*Message, rooms*clientsPerRoom)
+			for i := range updates {
+				c := members[i/clientsPerRoom][i%clientsPerRoom]
+				payload := circleUpdate(protocol.UpdateObjects, i)
+				updates[i] = &Message{room: c.room, data: protocol.EncodeStateUpdate(payload), source: c, payload: payload}
+			}
+			start := received.Load()
+			dropped := stats.DroppedFrames.Load()
+			b.ResetTimer()
+			var sent sync.WaitGroup
+			for i := range members {
+				sent.Add(1)
+				go func(i int) {
+					defer sent.Done()
+					for n := i; n < b.N; n += rooms {
+						m := updates[(n%rooms)*clientsPerRoom+n/rooms%clientsPerRoom]
+						m.source.deliver(m)
+					}
+				}(i)
+			}
+			sent.Wait()
+			want := start + int64(b.N)*clientsPerRoom
+			for received.Load()+stats.DroppedFrames.Load()-dropped < want {
+				time.Sleep(100 * time.Microsecond)
+			}
+			b.StopTimer()
+			frames := received.Load() - start
+			b.ReportMetric(float64(frames)/b.Elapsed().Seconds(), "frames/s")
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "updates/s")
+
+			for _, room := range members {
+				for _, c := range room {
+					c.leaveRoom()
+				}
+			}
+			drained.Wait()
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/rail2025/AetherDraw-Server/plan"
+)
+
+const (
+	// maxShareDescription bounds the length of the page list in share page
+	// descriptions, which previews cut short anyway.
+	maxShareDescription = 200
+	// defaultPublicOrigin is where the web client reaches the server.
+	// Override with the PUBLIC_ORIGIN environment variable.
+	defaultPublicOrigin = "https://aetherdraw-server.onrender.com"
+)
+
+// publicOrigin is the scheme and host of the absolute URLs in share pages.
+// It is configured rather than taken from the request: share pages are
+// cached publicly, and the Host header is whatever the client sent.
+var publicOrigin = defaultPublicOrigin
+
+// sharePage is the template of the /p/{id} pages.
+var sharePage = template.Must(template.New("share").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}} - AetherDraw</title>
+<meta name="description" content="{{.Description}}">
+<meta property="og:type" content="website">
+<meta property="og:site_name" content="AetherDraw">
+<meta property="og:title" content="{{.Title}}">
+<meta property="og:description" content="{{.Description}}">
+<meta property="og:url" content="{{.URL}}">
+{{- if .Image}}
+<meta property="og:image" content="{{.Image}}">
+<meta name="twitter:card" content="summary_large_image">
+{{- end}}
+<style>
+body { margin: 0 auto; max-width: 840px; padding: 20px; background: #1e1e22; color: #e0e0e0; font-family: Arial, sans-serif; }
+img { display: block; max-width: 100%; margin: 16px 0; }
+a.button, button { display: inline-block; padding: 8px 16px; margin-right: 8px; border: 0; border-radius: 4px; background: #3a6ea5; color: #fff; font-size: 14px; text-decoration: none; cursor: pointer; }
+textarea { width: 100%; height: 6em; margin-top: 16px; background: #26262b; color: #e0e0e0; border: 1px solid #4d4d4d; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.Description}}</p>
+{{- if .Image}}
+<img src="{{.Image}}" alt="Preview of {{.Title}}">
+{{- end}}
+<a class="button" href="{{.Open}}">Open in web client</a>
+<button id="copy" type="button">Copy import string</button>
+<span id="status"></span>
+<textarea id="import" readonly aria-label="Import string for the plugin">{{.Import}}</textarea>
+<script>
+document.getElementById("copy").addEventListener("click", function () {
+	var text = document.getElementById("import");
+	var status = document.getElementById("status");
+	navigator.clipboard.writeText(text.value).then(function () {
+		status.textContent = "Copied!";
+	}, function () {
+		text.select();
+		status.textContent = "Select the text and copy it.";
+	});
+});
+</script>
+</body>
+</html>
+`))
+
+// shareData fills sharePage.
+type shareData struct {
+	Title, Description string
+	// Absolute URLs of the page itself and its preview image, if any.
+	URL, Image string
+	// Open loads the plan in the web client.
+	Open string
+	// Import is the plan as the plugin imports it from the clipboard.
+	Import string
+}
+
+// handlePlanShare serves /p/{id}, an HTML page for people following a plan
+// link: it previews the plan in chat apps through OpenGraph tags and offers
+// to open the plan in the web client or copy it for the plugin.
+func handlePlanShare(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/p/")
+	if id == "" || strings.Contains(id, "/") || db == nil {
+		http.Error(w, "Plan not found", http.StatusNotFound)
+		return
+	}
+	planData, p := loadPlanFile(r.Context(), w, id)
+	if p == nil {
+		return
+	}
+	serveSharePage(w, id, planData, p)
+}
+
+// serveSharePage answers with the share page of the plan p saved as planData.
+func serveSharePage(w http.ResponseWriter, id string, planData []byte, p *plan.Plan) {
+
+	title := p.Name
+	if strings.TrimSpace(title) == "" {
+		title = "AetherDraw plan"
+	}
+	description := strconv.Itoa(len(p.Pages)) + " pages"
+	if len(p.Pages) == 1 {
+		description = "1 page"
+	}
+	names := make([]string, len(p.Pages))
+	for i, page := range p.Pages {
+		names[i] = page.Name
+		if strings.TrimSpace(page.Name) == "" {
+			names[i] = strconv.Itoa(i + 1)
+		}
+	}
+	if list := strings.Join(names, ", "); list != "" {
+		if len(list) > maxShareDescription {
+			list = strings.ToValidUTF8(list[:maxShareDescription], "") + "…"
+		}
+		description += ": " + list
+	}
+
+	// The plugin imports the plan file as saved.
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	_, err := zw.Write(planData)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		slog.Error("Failed to compress plan for import", "id", id, "error", err)
+		http.Error(w, "Failed to load plan", http.StatusInternalServerError)
+		return
+	}
+
+	data := shareData{
+		Title:       title,
+		Description: description,
+		URL:         publicOrigin + "/p/" + id,
+		Open:        "/?plan=" + id,
+		Import:      base64.StdEncoding.EncodeToString(compressed.Bytes()),
+	}
+	if len(p.Pages) > 0 {
+		data.Image = publicOrigin + "/plan/render/" + id + ".png"
+	}
+
+	var page bytes.Buffer
+	if err := sharePage.Execute(&page, data); err != nil {
+		slog.Error("Failed to render share page", "id", id, "error", err)
+		http.Error(w, "Failed to load plan", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write(page.Bytes())
+	slog.Info("Served plan share page", "id", id, "pages", len(p.Pages))
+}
+
+// loadPublicOrigin reads PUBLIC_ORIGIN, falling back to the default.
+func loadPublicOrigin() string {
+	value := strings.TrimSuffix(os.Getenv("PUBLIC_ORIGIN"), "/")
+	if value == "" {
+		return defaultPublicOrigin
+	}
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		slog.Warn("Invalid PUBLIC_ORIGIN, using default", "value", value, "default", defaultPublicOrigin)
+		return defaultPublicOrigin
+	}
+	return value
+}
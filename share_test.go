@@ -0,0 +1,61 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rail2025/AetherDraw-Server/plan"
+)
+
+func TestSharePageIgnoresRequestHost(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	p := &plan.Plan{FormatVersion: plan.FormatVersion, Name: "Raid plan", Pages: []plan.Page{{Name: "Phase 1"}}}
+	planData := p.Encode()
+	// The database is out of the way; serve the page as handlePlanShare would.
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		serveSharePage(w, strings.TrimPrefix(r.URL.Path, "/p/"), planData, p)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/p/abc", nil)
+	r.Host = "attacker.example"
+	r.Header.Set("X-Forwarded-Proto", "https")
+	r.Header.Set("X-Forwarded-Host", "attacker.example")
+	w := httptest.NewRecorder()
+	handler(w, r)
+	body := w.Body.String()
+	if strings.Contains(body, "attacker.example") {
+		t.Errorf("share page mentions the request's host:\n%s", body)
+	}
+	for _, want := range []string{publicOrigin + "/p/abc", publicOrigin + "/plan/render/abc.png"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("share page does not link %s", want)
+		}
+	}
+}
+
+func TestLoadPublicOrigin(t *testing.T) {
+	tests := []struct {
+		value, want string
+	}{
+		{"", defaultPublicOrigin},
+		{"https://draw.example", "https://draw.example"},
+		{"http://localhost:8080/", "http://localhost:8080"},
+		{"draw.example", defaultPublicOrigin},
+		{"ftp://draw.example", defaultPublicOrigin},
+		{"https://draw.example/app", defaultPublicOrigin},
+		{"https://user@draw.example", defaultPublicOrigin},
+	}
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	for _, tt := range tests {
+		t.Setenv("PUBLIC_ORIGIN", tt.value)
+		if got := loadPublicOrigin(); got != tt.want {
+			t.Errorf("loadPublicOrigin() with %q = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Slow-consumer policies, selected with the SLOW_CONSUMER_POLICY environment variable.
+const (
+	// slowConsumerDisconnect closes the connection of a client whose queue is full.
+	slowConsumerDisconnect = "disconnect"
+	// slowConsumerDropOldest discards the oldest queued frame to make room.
+	// The client stays connected but misses that frame. Sequenced clients
+	// that follow every page can spot the gap and reconnect to resume; those
+	// that follow only some pages see gaps for the pages they skip anyway and
+	// cannot tell the difference.
+	slowConsumerDropOldest = "drop-oldest"
+)
+
+const (
+	// defaultClientQueueDepth is the number of outbound frames buffered per client.
+	// Override with the CLIENT_QUEUE_DEPTH environment variable.
+	defaultClientQueueDepth = 256
+	// maxClientQueueDepth bounds CLIENT_QUEUE_DEPTH.
+	maxClientQueueDepth = 65536
+
+	// closeSlowConsumer is the WebSocket close code sent to evicted clients.
+	closeSlowConsumer = 4001
+)
+
+// Configured slow-consumer settings, set in main.
+var (
+	clientQueueDepth   = defaultClientQueueDepth
+	slowConsumerPolicy = slowConsumerDisconnect
+)
+
+// loadSlowConsumerConfig reads CLIENT_QUEUE_DEPTH and SLOW_CONSUMER_POLICY, falling back to the defaults.
+func loadSlowConsumerConfig() (int, string) {
+	depth, policy := defaultClientQueueDepth, slowConsumerDisconnect
+	if value := os.Getenv("CLIENT_QUEUE_DEPTH"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxClientQueueDepth {
+			slog.Warn("Invalid CLIENT_QUEUE_DEPTH, using default", "value", value, "default", defaultClientQueueDepth)
+		} else {
+			depth = n
+		}
+	}
+	switch value := os.Getenv("SLOW_CONSUMER_POLICY"); value {
+	case "":
+	case slowConsumerDisconnect, slowConsumerDropOldest:
+		policy = value
+	default:
+		slog.Warn("Invalid SLOW_CONSUMER_POLICY, using default", "value", value, "default", slowConsumerDisconnect)
+	}
+	return depth, policy
+}
+
+// enqueue queues a frame for the client, applying the slow-consumer policy
+// when its queue is full. It reports whether the frame was queued.
+//
+// It must only be called from the room's loop while the client is still in
+// the room, since the loop closes c.send when it removes the client. enqueue
+// never touches the room's client map: an evicted client is removed by the
+// regular leave path once its readPump sees the closed connection.
+func (c *Client) enqueue(frame []byte) bool {
+	if c.evicted.Load() {
+		return false
+	}
+	select {
+	case c.send <- frame:
+		return true
+	default:
+	}
+
+	if slowConsumerPolicy == slowConsumerDropOldest {
+		select {
+		case <-c.send:
+			stats.DroppedFrames.Add(1)
+		default:
+		}
+		select {
+		case c.send <- frame:
+			return true
+		default:
+		}
+	}
+	c.evict("send queue full")
+	return false
+}
+
+// evict disconnects a client that cannot keep up with its room.
+func (c *Client) evict(reason string) {
+	if !c.evicted.CompareAndSwap(false, true) {
+		return
+	}
+	stats.Evictions.Add(1)
+	slog.Warn("Evicting slow client", "room", roomLogID(c.room), "clientType", c.clientType, "reason", reason)
+	c.disconnect(closeSlowConsumer, reason)
+}
+
+// disconnect closes the client's connection with a close code. Its readPump
+// then sees the closed connection and the client leaves the room as usual.
+func (c *Client) disconnect(code int, reason string) {
+	// The write can block on a stalled connection, so keep it off the room's loop.
+	go func() {
+		message := websocket.FormatCloseMessage(code, reason)
+		c.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(writeWait))
+		c.conn.Close()
+	}()
+}
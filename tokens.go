@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// closeTokenRevoked is the WebSocket close code sent to clients whose join token was revoked.
+	closeTokenRevoked = 4003
+	// maxTokenLifetime is the longest expiry an invite token may be issued with.
+	maxTokenLifetime = 7 * 24 * time.Hour
+	// defaultTokenLifetime is used when an invite does not ask for a specific expiry.
+	defaultTokenLifetime = 24 * time.Hour
+)
+
+// Errors returned by verifyJoinToken.
+var (
+	errTokenMalformed = errors.New("malformed join token")
+	errTokenSignature = errors.New("invalid join token signature")
+	errTokenExpired   = errors.New("join token expired")
+)
+
+// joinTokenSecret signs join tokens and keys the hashes of passphrases, see
+// checkpointKey. It comes from JOIN_TOKEN_SECRET, or else from the database,
+// where the first instance to start stores a random one for all instances.
+var joinTokenSecret []byte
+
+const createServerSecretsTableSQL = `CREATE TABLE IF NOT EXISTS server_secrets (
+	name TEXT PRIMARY KEY,
+	value BYTEA NOT NULL
+);`
+
+// loadJoinTokenSecret reads JOIN_TOKEN_SECRET or falls back to the secret
+// stored in the database, and to a random secret if that fails.
+func loadJoinTokenSecret() []byte {
+	if secret := os.Getenv("JOIN_TOKEN_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		slog.Error("Failed to generate join token secret", "error", err)
+		os.Exit(1)
+	}
+	if db != nil {
+		stored, err := loadStoredSecret("join_token", secret)
+		if err == nil {
+			return stored
+		}
+		slog.Error("Failed to load join token secret from database", "error", err)
+	}
+	slog.Warn("JOIN_TOKEN_SECRET is not set, join tokens and room checkpoints will not survive a restart")
+	return secret
+}
+
+// loadStoredSecret returns the secret stored under name, storing candidate
+// if there is none yet.
+func loadStoredSecret(name string, candidate []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), checkpointQueryTimeout)
+	defer cancel()
+	if _, err := db.ExecContext(ctx, "INSERT INTO server_secrets (name, value) VALUES ($1, $2) ON CONFLICT (name) DO NOTHING", name, candidate); err != nil {
+		return nil, err
+	}
+	var secret []byte
+	err := db.QueryRowContext(ctx, "SELECT value FROM server_secrets WHERE name = $1", name).Scan(&secret)
+	return secret, err
+}
+
+// joinClaims is the signed content of a join token.
+type joinClaims struct {
+	ID   string `json:"jti"`
+	Room string `json:"room"`
+	Role string `json:"role"`
+	// Expiry as a Unix timestamp in seconds.
+	Expiry int64  `json:"exp"`
+	Name   string `json:"name,omitempty"`
+}
+
+// signJoinToken encodes claims as base64url(JSON) + "." + base64url(HMAC-SHA256).
+func signJoinToken(claims joinClaims) (string, error) {
+	body, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(body)
+	mac := hmac.New(sha256.New, joinTokenSecret)
+	mac.Write([]byte(payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// verifyJoinToken checks the signature and expiry of a token and returns its claims.
+func verifyJoinToken(token string) (*joinClaims, error) {
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, errTokenMalformed
+	}
+	got, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return nil, errTokenMalformed
+	}
+	mac := hmac.New(sha256.New, joinTokenSecret)
+	mac.Write([]byte(payload))
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return nil, errTokenSignature
+	}
+	body, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, errTokenMalformed
+	}
+	var claims joinClaims
+	if err := json.Unmarshal(body, &claims); err != nil || claims.Room == "" {
+		return nil, errTokenMalformed
+	}
+	if claims.Role != roleMember && claims.Role != roleSpectator {
+		return nil, errTokenMalformed
+	}
+	if time.Now().Unix() >= claims.Expiry {
+		return nil, errTokenExpired
+	}
+	return &claims, nil
+}
+
+// roomLogID returns a short, stable identifier for a room that is safe to log.
+// Passphrases double as secrets, so they never appear in log lines, and the
+// identifier is keyed with the server's secret so weak passphrases cannot be
+// guessed back from it.
+func roomLogID(room string) string {
+	mac := hmac.New(sha256.New, joinTokenSecret)
+	mac.Write([]byte("room-log:" + room))
+	return hex.EncodeToString(mac.Sum(nil)[:6])
+}
+
+// hashManageKey hashes a room's manage key for storage.
+func hashManageKey(key string) []byte {
+	sum := sha256.Sum256([]byte(key))
+	return sum[:]
+}
+
+// roomAccess holds the credentials of a room created through /room/create.
+type roomAccess struct {
+	// ManageKeyHash is the SHA-256 of the key that may issue and revoke invites.
+	ManageKeyHash []byte `json:"manageKeyHash"`
+	// Revoked maps revoked token IDs to their expiry, after which they can be forgotten.
+	Revoked map[string]int64 `json:"revoked,omitempty"`
+	// RecordingID identifies the room's recording, empty if it is not recorded.
+	RecordingID string `json:"recordingId,omitempty"`
+}
+
+// isRevoked reports whether the token with the given ID has been revoked.
+func (a *roomAccess) isRevoked(id string) bool {
+	if a == nil {
+		return false
+	}
+	_, revoked := a.Revoked[id]
+	return revoked
+}
+
+// checkRoomAccess decides whether a connection may join a room with the given
+// access record, which is nil for passphrase rooms. Rooms created through
+// /room/create require a valid, unrevoked token, and tokens are only valid
+// for such rooms. It returns "" on success and the reason otherwise.
+func checkRoomAccess(access *roomAccess, claims *joinClaims) string {
+	switch {
+	case access == nil && claims == nil:
+		return ""
+	case access == nil:
+		return "Unknown room"
+	case claims == nil:
+		return "This room requires a join token"
+	case access.isRevoked(claims.ID):
+		return "Join token has been revoked"
+	}
+	return ""
+}
+
+// pruneRevoked forgets revocations of tokens that have expired anyway.
+// The caller must hold the hub's roomsMux for writing.
+func (a *roomAccess) pruneRevoked() {
+	now := time.Now().Unix()
+	for id, expiry := range a.Revoked {
+		if expiry <= now {
+			delete(a.Revoked, id)
+		}
+	}
+}
+
+// marshal encodes the access record for a checkpoint. It returns "" for
+// rooms without one. The caller must hold the hub's roomsMux.
+func (a *roomAccess) marshal() string {
+	if a == nil {
+		return ""
+	}
+	data, err := json.Marshal(a)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// inviteRequest is the JSON body accepted by /room/invite and /room/revoke.
+type inviteRequest struct {
+	RoomID           string `json:"roomId"`
+	ManageKey        string `json:"manageKey"`
+	Role             string `json:"role"`
+	Name             string `json:"name"`
+	ExpiresInMinutes int    `json:"expiresInMinutes"`
+	// TokenID selects the token to revoke.
+	TokenID string `json:"tokenId"`
+}
+
+// inviteResponse describes an issued join token.
+type inviteResponse struct {
+	Token     string `json:"token"`
+	TokenID   string `json:"tokenId"`
+	Role      string `json:"role"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+// issueJoinToken signs a new token for the room.
+func issueJoinToken(roomID, role, name string, lifetime time.Duration) (*inviteResponse, error) {
+	id, err := generateShortID()
+	if err != nil {
+		return nil, err
+	}
+	claims := joinClaims{
+		ID:     id,
+		Room:   roomID,
+		Role:   role,
+		Expiry: time.Now().Add(lifetime).Unix(),
+		Name:   sanitizeDisplayName(name),
+	}
+	token, err := signJoinToken(claims)
+	if err != nil {
+		return nil, err
+	}
+	return &inviteResponse{Token: token, TokenID: id, Role: role, ExpiresAt: claims.Expiry}, nil
+}
+
+// authorizeRoomManager decodes an inviteRequest and checks its manage key.
+// On failure it writes the HTTP error and returns nil.
+func authorizeRoomManager(hub *Hub, w http.ResponseWriter, r *http.Request) (*inviteRequest, *Room) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return nil, nil
+	}
+	var req inviteRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return nil, nil
+	}
+	room := hub.managedRoom(req.RoomID)
+	if room == nil || room.access == nil || !hmac.Equal(room.access.ManageKeyHash, hashManageKey(req.ManageKey)) {
+		http.Error(w, "Unknown room or invalid manage key", http.StatusForbidden)
+		return nil, nil
+	}
+	return &req, room
+}
+
+// handleRoomInvite issues a join token for a room created through /room/create.
+func handleRoomInvite(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	req, room := authorizeRoomManager(hub, w, r)
+	if req == nil {
+		return
+	}
+	role := req.Role
+	switch role {
+	case "":
+		role = roleMember
+	case roleMember:
+	case roleSpectator:
+		if room.policy.MaxSpectators == 0 {
+			http.Error(w, "Spectators are not allowed in this room", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Unknown role", http.StatusBadRequest)
+		return
+	}
+	lifetime := defaultTokenLifetime
+	if req.ExpiresInMinutes != 0 {
+		lifetime = time.Duration(req.ExpiresInMinutes) * time.Minute
+		if lifetime < time.Minute || lifetime > maxTokenLifetime {
+			http.Error(w, "expiresInMinutes must be between 1 and 10080", http.StatusBadRequest)
+			return
+		}
+	}
+
+	invite, err := issueJoinToken(req.RoomID, role, req.Name, lifetime)
+	if err != nil {
+		slog.Error("Failed to issue join token", "room", roomLogID(req.RoomID), "error", err)
+		http.Error(w, "Failed to issue token", http.StatusInternalServerError)
+		return
+	}
+	slog.Info("Issued join token", "room", roomLogID(req.RoomID), "tokenId", invite.TokenID, "role", role)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invite)
+}
+
+// handleRoomRevoke revokes a previously issued join token and disconnects the
+// clients that joined with it.
+func handleRoomRevoke(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	req, room := authorizeRoomManager(hub, w, r)
+	if req == nil {
+		return
+	}
+	if req.TokenID == "" {
+		http.Error(w, "tokenId is required", http.StatusBadRequest)
+		return
+	}
+	hub.roomsMux.Lock()
+	if room.access.Revoked == nil {
+		room.access.Revoked = make(map[string]int64)
+	}
+	room.access.pruneRevoked()
+	// Tokens never outlive maxTokenLifetime, so the entry can be dropped after that.
+	room.access.Revoked[req.TokenID] = time.Now().Add(maxTokenLifetime).Unix()
+	record := room.access.marshal()
+	hub.roomsMux.Unlock()
+	saveRoomAccess(req.RoomID, room.creationTime, room.policy, record)
+	room.revokeToken(req.TokenID)
+
+	slog.Info("Revoked join token", "room", roomLogID(req.RoomID), "tokenId", req.TokenID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// revokeToken asks the room's loop to disconnect the clients that joined
+// with the token id, here and on the other instances. It returns once the
+// loop has taken the request, or right away if the room has closed.
+func (r *Room) revokeToken(id string) {
+	select {
+	case r.revocations <- id:
+	case <-r.done:
+	}
+}
+
+// tokenRevoked reports whether the join token id has been revoked for the room.
+func (r *Room) tokenRevoked(id string) bool {
+	r.hub.roomsMux.RLock()
+	defer r.hub.roomsMux.RUnlock()
+	return r.access.isRevoked(id)
+}
+
+// addRevocation records a token revoked through another instance.
+// Only called from the room's loop.
+func (r *Room) addRevocation(id string) {
+	r.hub.roomsMux.Lock()
+	defer r.hub.roomsMux.Unlock()
+	if r.access == nil {
+		return
+	}
+	if r.access.Revoked == nil {
+		r.access.Revoked = make(map[string]int64)
+	}
+	r.access.pruneRevoked()
+	r.access.Revoked[id] = time.Now().Add(maxTokenLifetime).Unix()
+}
+
+// disconnectToken disconnects the clients that joined with the token id.
+// Only called from the room's loop.
+func (r *Room) disconnectToken(id string) {
+	for client := range r.clients {
+		if client.tokenID == id {
+			slog.Info("Disconnecting client with revoked join token", "room", roomLogID(r.name), "tokenId", id)
+			client.disconnect(closeTokenRevoked, "join token revoked")
+		}
+	}
+}
+
+// handleRoomClose closes a room created through /room/create for good. Its
+// clients are told the room was closed by its creator.
+func handleRoomClose(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	req, room := authorizeRoomManager(hub, w, r)
+	if req == nil {
+		return
+	}
+	room.requestClose(closeAdmin)
+	deleteRoomAccess(req.RoomID)
+	slog.Info("Closing room at the request of its creator", "room", roomLogID(req.RoomID))
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJoinTokenRoundTrip(t *testing.T) {
+	joinTokenSecret = []byte("test secret")
+	invite, err := issueJoinToken("room", roleSpectator, " Alice\n", time.Hour)
+	if err != nil {
+		t.Fatalf("issueJoinToken() error = %v", err)
+	}
+	claims, err := verifyJoinToken(invite.Token)
+	if err != nil {
+		t.Fatalf("verifyJoinToken() error = %v", err)
+	}
+	if claims.ID != invite.TokenID || claims.Room != "room" || claims.Role != roleSpectator || claims.Name != "Alice" {
+		t.Errorf("verifyJoinToken() = %+v", claims)
+	}
+
+	payload, signature, _ := strings.Cut(invite.Token, ".")
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"no signature", payload, errTokenMalformed},
+		{"bad signature", payload + "." + signature[1:], errTokenSignature},
+		{"other payload", "e30." + signature, errTokenSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := verifyJoinToken(tt.token); !errors.Is(err, tt.err) {
+				t.Errorf("verifyJoinToken() error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+
+	expired, err := signJoinToken(joinClaims{ID: "x", Room: "room", Role: roleMember, Expiry: time.Now().Add(-time.Second).Unix()})
+	if err != nil {
+		t.Fatalf("signJoinToken() error = %v", err)
+	}
+	if _, err := verifyJoinToken(expired); !errors.Is(err, errTokenExpired) {
+		t.Errorf("verifyJoinToken(expired) error = %v, want %v", err, errTokenExpired)
+	}
+}
+
+func TestCheckRoomAccess(t *testing.T) {
+	access := &roomAccess{Revoked: map[string]int64{"revoked": time.Now().Add(time.Hour).Unix()}}
+	tests := []struct {
+		name   string
+		access *roomAccess
+		claims *joinClaims
+		ok     bool
+	}{
+		{"passphrase room", nil, nil, true},
+		{"token for passphrase room", nil, &joinClaims{ID: "a"}, false},
+		{"created room without token", access, nil, false},
+		{"created room with token", access, &joinClaims{ID: "a"}, true},
+		{"revoked token", access, &joinClaims{ID: "revoked"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if problem := checkRoomAccess(tt.access, tt.claims); (problem == "") != tt.ok {
+				t.Errorf("checkRoomAccess() = %q, want ok %v", problem, tt.ok)
+			}
+		})
+	}
+}
+
+func TestPruneRevoked(t *testing.T) {
+	access := &roomAccess{Revoked: map[string]int64{
+		"old":  time.Now().Add(-time.Minute).Unix(),
+		"live": time.Now().Add(time.Minute).Unix(),
+	}}
+	before := access.marshal()
+	if access.marshal() != before || len(access.Revoked) != 2 {
+		t.Fatalf("marshal() changed the record")
+	}
+	access.pruneRevoked()
+	if access.isRevoked("old") || !access.isRevoked("live") {
+		t.Errorf("after pruneRevoked, Revoked = %v", access.Revoked)
+	}
+}
+
+func TestRoomLogIDIsKeyed(t *testing.T) {
+	joinTokenSecret = []byte("one")
+	first := roomLogID("hunter2")
+	if first != roomLogID("hunter2") {
+		t.Fatal("roomLogID() is not stable")
+	}
+	sum := sha256.Sum256([]byte("hunter2"))
+	if first == hex.EncodeToString(sum[:6]) {
+		t.Error("roomLogID() is a plain hash of the passphrase")
+	}
+	joinTokenSecret = []byte("two")
+	if roomLogID("hunter2") == first {
+		t.Error("roomLogID() does not depend on the secret")
+	}
+}
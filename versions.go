@@ -0,0 +1,112 @@
+package main
+
+import (
+	"log/slog"
+
+	"github.com/rail2025/AetherDraw-Server/drawable"
+	"github.com/rail2025/AetherDraw-Server/protocol"
+)
+
+// objectVersion is the last change made to a drawable: the room sequence
+// number it was relayed with and the client that made it.
+type objectVersion struct {
+	seq     int64
+	author  string
+	deleted bool
+}
+
+// versionEntry is a version recorded for a drawable, queued until it is forgotten.
+type versionEntry struct {
+	seq int64
+	id  drawable.Guid
+}
+
+// recordVersions stamps the drawables op touched with its sequence number
+// and forgets versions older than the resume buffer, so the map stays
+// bounded however long the room lives.
+// Only called from the room's loop.
+func (r *Room) recordVersions(op *operation) {
+	deleted := false
+	switch op.action {
+	case protocol.DeleteObjects, protocol.ClearPage, protocol.DeletePage:
+		deleted = true
+	}
+	for _, id := range op.ids {
+		r.versions[id] = objectVersion{seq: op.seq, author: op.authorID, deleted: deleted}
+		r.versionQueue = append(r.versionQueue, versionEntry{op.seq, id})
+	}
+	for len(r.versionQueue) > 0 && r.versionQueue[0].seq <= op.seq-resumeBufferSize {
+		entry := r.versionQueue[0]
+		r.versionQueue = r.versionQueue[1:]
+		// Later changes to the drawable queued their own entry.
+		if r.versions[entry.id].seq == entry.seq {
+			delete(r.versions, entry.id)
+		}
+		r.forgottenSeq = entry.seq
+	}
+}
+
+// resolveConflicts drops the drawables of a versioned UpdateObjects that
+// another client changed after the version the update is based on, so two
+// people dragging the same marker cannot flip-flop it. It returns the GUIDs
+// that were dropped and whether anything is left to relay; message is
+// rewritten to carry only the rest.
+//
+// Versioning is advisory: it only guards clients that wrap their updates in
+// BasedOn. Plain StateUpdates, from older clients or any other update,
+// still apply last writer wins and bump the versions they touch. An update
+// based on changes the room has already forgotten loses all its drawables,
+// since the room can no longer tell which of them are stale.
+// Only called from the room's loop.
+func (r *Room) resolveConflicts(message *Message) (lost []drawable.Guid, ok bool) {
+	payload := message.payload
+	if !message.versioned || payload == nil || payload.Action != protocol.UpdateObjects || !r.policy.keepsBoard() {
+		return nil, true
+	}
+	raws, err := drawable.SplitPage(payload.Data)
+	if err != nil {
+		// Let apply reject it.
+		return nil, true
+	}
+	kept := raws[:0:0]
+	for _, raw := range raws {
+		version, known := r.versions[raw.ID]
+		stale := known && version.seq > message.baseSeq && version.author != message.source.id
+		if stale || message.baseSeq < r.forgottenSeq {
+			lost = append(lost, raw.ID)
+			continue
+		}
+		kept = append(kept, raw)
+	}
+	if len(lost) == 0 {
+		return nil, true
+	}
+	slog.Debug("Dropped stale drawables from update", "room", roomLogID(r.name), "lost", len(lost), "kept", len(kept))
+	if len(kept) == 0 {
+		return lost, false
+	}
+	message.payload = &protocol.NetworkPayload{PageIndex: payload.PageIndex, Action: payload.Action, Data: drawable.EncodePage(kept)}
+	message.data = protocol.EncodeStateUpdate(message.payload)
+	return lost, true
+}
+
+// sendConflict tells c which of its changes on a page lost and brings those
+// drawables back to their current state on its board.
+// Only called from the room's loop.
+func (r *Room) sendConflict(c *Client, pageIndex int32, lost []drawable.Guid) {
+	ids := make([][16]byte, len(lost))
+	for i, id := range lost {
+		ids[i] = id
+	}
+	c.enqueue(protocol.EncodeConflict(pageIndex, ids))
+
+	r.stateMux.RLock()
+	present, missing := r.board.Objects(int(pageIndex), lost)
+	r.stateMux.RUnlock()
+	if len(present) > 0 {
+		c.enqueue(protocol.EncodeStateUpdate(&protocol.NetworkPayload{PageIndex: pageIndex, Action: protocol.UpdateObjects, Data: drawable.EncodePage(present)}))
+	}
+	if len(missing) > 0 {
+		c.enqueue(protocol.EncodeStateUpdate(&protocol.NetworkPayload{PageIndex: pageIndex, Action: protocol.DeleteObjects, Data: drawable.EncodeGuidList(missing)}))
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/rail2025/AetherDraw-Server/drawable"
+	"github.com/rail2025/AetherDraw-Server/protocol"
+)
+
+func TestRecordVersionsForgetsOldVersions(t *testing.T) {
+	r := newRoom(newTestHub(t), "versions", defaultRoomPolicy("versions", "ad"), "ad")
+	for seq := int64(1); seq <= 3*resumeBufferSize; seq++ {
+		id := drawable.Guid{byte(seq), byte(seq >> 8)}
+		r.recordVersions(&operation{seq: seq, action: protocol.UpdateObjects, ids: []drawable.Guid{id}})
+	}
+	if len(r.versions) != resumeBufferSize || len(r.versionQueue) != resumeBufferSize {
+		t.Errorf("room remembers %d versions in a queue of %d, want %d", len(r.versions), len(r.versionQueue), resumeBufferSize)
+	}
+	if want := int64(2 * resumeBufferSize); r.forgottenSeq != want {
+		t.Errorf("forgottenSeq = %d, want %d", r.forgottenSeq, want)
+	}
+}
+
+func TestResolveConflicts(t *testing.T) {
+	hub := newTestHub(t)
+	author := newTestClient(t, hub, "versions")
+	r := newRoom(hub, "versions", defaultRoomPolicy("versions", "ad"), "ad")
+	changed, untouched := circleUpdate(protocol.UpdateObjects, 1), circleUpdate(protocol.UpdateObjects, 2)
+	changedID := drawable.Guid{1}
+	r.recordVersions(&operation{seq: 5, authorID: "someone else", action: protocol.UpdateObjects, ids: []drawable.Guid{changedID}})
+
+	tests := []struct {
+		name      string
+		versioned bool
+		baseSeq   int64
+		payload   *protocol.NetworkPayload
+		lost      bool
+	}{
+		{"unversioned", false, 0, changed, false},
+		{"based on the change", true, 5, changed, false},
+		{"based on an older state", true, 4, changed, true},
+		{"based on nothing", true, 0, changed, true},
+		{"untouched drawable", true, 0, untouched, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := &Message{source: author, versioned: tt.versioned, baseSeq: tt.baseSeq, payload: tt.payload, data: protocol.EncodeStateUpdate(tt.payload)}
+			lost, ok := r.resolveConflicts(message)
+			if (len(lost) > 0) != tt.lost || ok == tt.lost {
+				t.Errorf("resolveConflicts() = %v, %v, want lost %v", lost, ok, tt.lost)
+			}
+		})
+	}
+
+	r.forgottenSeq = 10
+	message := &Message{source: author, versioned: true, baseSeq: 9, payload: untouched, data: protocol.EncodeStateUpdate(untouched)}
+	if lost, ok := r.resolveConflicts(message); len(lost) != 1 || ok {
+		t.Errorf("resolveConflicts() based on forgotten changes = %v, %v, want everything lost", lost, ok)
+	}
+}